@@ -0,0 +1,345 @@
+package allocation
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name of the pseudo-workload that idle cost is reported under
+const IDLE_WORKLOAD = "__idle__"
+
+// Name of the pseudo-namespace that shared cost is reported under when there is no tenant to split it between
+const SHARED_WORKLOAD = "__shared__"
+
+// Decides what happens to the cost of node capacity that no pod used
+type IdlePolicy uint
+
+const (
+	// The cost model spreads the idle cost over the pods of the same node
+	IdleNode IdlePolicy = iota
+	// Pods only pay for their usage and the idle cost is reported separately
+	IdleSeparate
+	// Pods only pay for their usage and the idle cost of the whole cluster is redistributed across namespaces, proportionally to their usage
+	IdleRedistribute
+)
+
+func (p IdlePolicy) String() string {
+	switch p {
+	case IdleNode:
+		return "node"
+	case IdleSeparate:
+		return "separate"
+	case IdleRedistribute:
+		return "redistribute"
+	}
+
+	return ""
+}
+
+func ParseIdlePolicy(s string) (IdlePolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "node":
+		return IdleNode, nil
+	case "separate":
+		return IdleSeparate, nil
+	case "redistribute":
+		return IdleRedistribute, nil
+	}
+
+	return IdleNode, fmt.Errorf("Invalid idle policy: '%s'", s)
+}
+
+/*
+ * Returns the idle cost of a node, i.e. the part of the node price that was not charged to any pod running on it
+ */
+func NodeIdleCost(nodePrice float64, hours float64, podCosts []float64) float64 {
+	idle := nodePrice * hours
+
+	for _, cost := range podCosts {
+		idle -= cost
+	}
+
+	// Floating point errors may make a fully used node slightly negative
+	if idle < 0 {
+		return 0
+	}
+
+	return idle
+}
+
+/*
+ * Splits the idle cost between the namespaces, proportionally to the cost of their usage.
+ * Returns a map with the namespace as key and the namespace's share of the idle cost as value
+ */
+func RedistributeIdle(namespaceUsage map[string]float64, idle float64) map[string]float64 {
+	shares := make(map[string]float64)
+	totalUsage := 0.0
+
+	for _, usage := range namespaceUsage {
+		totalUsage += usage
+	}
+
+	for namespace, usage := range namespaceUsage {
+		if totalUsage == 0 {
+			shares[namespace] = idle / float64(len(namespaceUsage))
+			continue
+		}
+
+		shares[namespace] = idle * usage / totalUsage
+	}
+
+	return shares
+}
+
+/*
+ * Splits a namespace's share of a cost between its pods, proportionally to the pods' own cost.
+ * podCosts and podNamespaces are keyed by pod name, and shares is keyed by namespace
+ */
+func SplitToPods(podCosts map[string]float64, podNamespaces map[string]string, shares map[string]float64) map[string]float64 {
+	namespaceCosts := make(map[string]float64)
+	namespacePods := make(map[string]int)
+
+	for pod, cost := range podCosts {
+		namespaceCosts[podNamespaces[pod]] += cost
+		namespacePods[podNamespaces[pod]]++
+	}
+
+	podShares := make(map[string]float64)
+
+	for pod, cost := range podCosts {
+		namespace := podNamespaces[pod]
+		share, ok := shares[namespace]
+
+		if !ok {
+			continue
+		}
+
+		if namespaceCosts[namespace] == 0 {
+			podShares[pod] = share / float64(namespacePods[namespace])
+			continue
+		}
+
+		podShares[pod] = share * cost / namespaceCosts[namespace]
+	}
+
+	return podShares
+}
+
+// Decides how the cost of shared namespaces is split between the tenant namespaces
+type SharePolicy uint
+
+const (
+	// Every tenant gets the same share
+	ShareEven SharePolicy = iota
+	// Tenants get a share proportional to the cost of their usage
+	ShareUsage
+	// Tenants get a share proportional to a fixed weight
+	ShareWeights
+)
+
+func (p SharePolicy) String() string {
+	switch p {
+	case ShareEven:
+		return "even"
+	case ShareUsage:
+		return "usage"
+	case ShareWeights:
+		return "weights"
+	}
+
+	return ""
+}
+
+func ParseSharePolicy(s string) (SharePolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "even":
+		return ShareEven, nil
+	case "usage":
+		return ShareUsage, nil
+	case "weights":
+		return ShareWeights, nil
+	}
+
+	return ShareEven, fmt.Errorf("Invalid shared cost policy: '%s'", s)
+}
+
+// Describes which pods are shared overhead, and how their cost is split between the tenants
+type SharedConfig struct {
+	Namespaces []string
+	Labels     map[string]string
+	Policy     SharePolicy
+	Weights    map[string]float64
+}
+
+func (s SharedConfig) Enabled() bool {
+	return len(s.Namespaces) > 0 || len(s.Labels) > 0
+}
+
+/*
+ * Returns true if a pod in the namespace with the given labels is shared overhead.
+ * A pod is shared if it runs in one of the shared namespaces or has any of the shared labels
+ */
+func (s SharedConfig) IsShared(namespace string, podLabels map[string]string) bool {
+	for _, sharedNamespace := range s.Namespaces {
+		if sharedNamespace == namespace {
+			return true
+		}
+	}
+
+	for key, value := range s.Labels {
+		podValue, ok := podLabels[key]
+
+		if ok && podValue == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Splits the shared cost between the tenant namespaces according to the policy of the config.
+ * tenantUsage has the tenant namespace as key and the cost of its usage as value.
+ * Returns a map with the tenant namespace as key and its shared overhead as value. Without tenants the shared cost is kept under SHARED_WORKLOAD
+ */
+func (s SharedConfig) Distribute(sharedCost float64, tenantUsage map[string]float64) map[string]float64 {
+	if len(tenantUsage) == 0 {
+		if sharedCost == 0 {
+			return map[string]float64{}
+		}
+
+		return map[string]float64{SHARED_WORKLOAD: sharedCost}
+	}
+
+	weights := make(map[string]float64)
+
+	for tenant, usage := range tenantUsage {
+		switch s.Policy {
+		case ShareUsage:
+			weights[tenant] = usage
+		case ShareWeights:
+			weights[tenant] = s.Weights[tenant]
+		default:
+			weights[tenant] = 1
+		}
+	}
+
+	// RedistributeIdle splits evenly when no tenant has any weight
+	return RedistributeIdle(weights, sharedCost)
+}
+
+/*
+ * Parses a comma separated list, e.g. "kube-system, monitoring". Empty entries are ignored
+ */
+func ParseList(s string) []string {
+	list := []string{}
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+/*
+ * Parses a comma separated list of key=value pairs, e.g. "team=platform, tier=infra"
+ */
+func ParseKeyValues(s string) (map[string]string, error) {
+	keyValues := make(map[string]string)
+
+	for _, item := range ParseList(s) {
+		pair := strings.SplitN(item, "=", 2)
+
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, fmt.Errorf("Invalid key value pair: '%s'", item)
+		}
+
+		keyValues[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+
+	return keyValues, nil
+}
+
+/*
+ * Parses a comma separated list of namespace=weight pairs, e.g. "shop=2, blog=1"
+ */
+func ParseWeights(s string) (map[string]float64, error) {
+	keyValues, err := ParseKeyValues(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	weights := make(map[string]float64)
+
+	for key, value := range keyValues {
+		weight, err := strconv.ParseFloat(value, 64)
+
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("Invalid weight for '%s': '%s'", key, value)
+		}
+
+		weights[key] = weight
+	}
+
+	return weights, nil
+}
+
+// Value used for pods that do not have a label that is grouped by
+const UNLABELLED = "unlabelled"
+
+// The summed cost of all pods sharing the same values for the grouped label keys
+type LabelGroup struct {
+	Values []string
+	Cost   float64
+}
+
+/*
+ * Groups the pod costs by the values of the given label keys, in the order of the keys.
+ * A pod missing one of the labels is put in the UNLABELLED bucket for that key.
+ * podCosts and podLabels are keyed by pod name
+ */
+func GroupByLabels(podCosts map[string]float64, podLabels map[string]map[string]string, keys []string) []LabelGroup {
+	groups := make(map[string]*LabelGroup)
+	order := []string{}
+
+	for pod, cost := range podCosts {
+		values := make([]string, len(keys))
+
+		for i, key := range keys {
+			value, ok := podLabels[pod][key]
+
+			if !ok || value == "" {
+				value = UNLABELLED
+			}
+
+			values[i] = value
+		}
+
+		groupKey := strings.Join(values, "\x00")
+		group, ok := groups[groupKey]
+
+		if !ok {
+			group = &LabelGroup{Values: values}
+			groups[groupKey] = group
+			order = append(order, groupKey)
+		}
+
+		group.Cost += cost
+	}
+
+	sort.Strings(order)
+	result := make([]LabelGroup, len(order))
+
+	for i, groupKey := range order {
+		result[i] = *groups[groupKey]
+	}
+
+	return result
+}
@@ -0,0 +1,122 @@
+package allocation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const epsilon float64 = 0.001
+
+func TestParseIdlePolicy(t *testing.T) {
+	for _, policy := range []IdlePolicy{IdleNode, IdleSeparate, IdleRedistribute} {
+		parsed, err := ParseIdlePolicy(policy.String())
+		assert.Nil(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+
+	parsed, err := ParseIdlePolicy(" Separate ")
+	assert.Nil(t, err)
+	assert.Equal(t, IdleSeparate, parsed)
+
+	_, err = ParseIdlePolicy("everywhere")
+	assert.NotNil(t, err)
+}
+
+func TestNodeIdleCost(t *testing.T) {
+	assert.InDelta(t, 20, NodeIdleCost(10, 2, []float64{}), epsilon)
+	assert.InDelta(t, 5, NodeIdleCost(10, 2, []float64{10, 5}), epsilon)
+	assert.InDelta(t, 0, NodeIdleCost(10, 2, []float64{10, 10.0000001}), epsilon)
+}
+
+func TestRedistributeIdle(t *testing.T) {
+	shares := RedistributeIdle(map[string]float64{"a": 30, "b": 10}, 8)
+	assert.InDelta(t, 6, shares["a"], epsilon)
+	assert.InDelta(t, 2, shares["b"], epsilon)
+
+	shares = RedistributeIdle(map[string]float64{"a": 0, "b": 0}, 8)
+	assert.InDelta(t, 4, shares["a"], epsilon)
+	assert.InDelta(t, 4, shares["b"], epsilon)
+}
+
+func TestSplitToPods(t *testing.T) {
+	podCosts := map[string]float64{"a-1": 3, "a-2": 1, "b-1": 2}
+	podNamespaces := map[string]string{"a-1": "a", "a-2": "a", "b-1": "b"}
+
+	podShares := SplitToPods(podCosts, podNamespaces, map[string]float64{"a": 4, "b": 1})
+	assert.InDelta(t, 3, podShares["a-1"], epsilon)
+	assert.InDelta(t, 1, podShares["a-2"], epsilon)
+	assert.InDelta(t, 1, podShares["b-1"], epsilon)
+
+	podShares = SplitToPods(podCosts, podNamespaces, map[string]float64{"a": 4})
+	_, ok := podShares["b-1"]
+	assert.False(t, ok)
+}
+
+func TestIsShared(t *testing.T) {
+	config := SharedConfig{
+		Namespaces: []string{"kube-system", "monitoring"},
+		Labels:     map[string]string{"team": "platform"},
+	}
+
+	assert.True(t, config.Enabled())
+	assert.True(t, config.IsShared("kube-system", nil))
+	assert.True(t, config.IsShared("shop", map[string]string{"team": "platform"}))
+	assert.False(t, config.IsShared("shop", map[string]string{"team": "shop"}))
+	assert.False(t, SharedConfig{}.Enabled())
+}
+
+func TestDistribute(t *testing.T) {
+	tenantUsage := map[string]float64{"shop": 30, "blog": 10}
+
+	overheads := SharedConfig{Policy: ShareEven}.Distribute(10, tenantUsage)
+	assert.InDelta(t, 5, overheads["shop"], epsilon)
+	assert.InDelta(t, 5, overheads["blog"], epsilon)
+
+	overheads = SharedConfig{Policy: ShareUsage}.Distribute(10, tenantUsage)
+	assert.InDelta(t, 7.5, overheads["shop"], epsilon)
+	assert.InDelta(t, 2.5, overheads["blog"], epsilon)
+
+	overheads = SharedConfig{Policy: ShareWeights, Weights: map[string]float64{"blog": 4, "shop": 1}}.Distribute(10, tenantUsage)
+	assert.InDelta(t, 2, overheads["shop"], epsilon)
+	assert.InDelta(t, 8, overheads["blog"], epsilon)
+
+	// Without tenants the shared cost is kept instead of dropped
+	overheads = SharedConfig{Policy: ShareEven}.Distribute(10, map[string]float64{})
+	assert.Equal(t, map[string]float64{SHARED_WORKLOAD: 10}, overheads)
+	assert.Empty(t, SharedConfig{Policy: ShareEven}.Distribute(0, map[string]float64{}))
+}
+
+func TestParseWeights(t *testing.T) {
+	weights, err := ParseWeights("shop=2, blog = 1,")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"shop": 2, "blog": 1}, weights)
+
+	_, err = ParseWeights("shop=two")
+	assert.NotNil(t, err)
+
+	_, err = ParseWeights("shop")
+	assert.NotNil(t, err)
+}
+
+func TestGroupByLabels(t *testing.T) {
+	podCosts := map[string]float64{"a": 1, "b": 2, "c": 4, "d": 8}
+	podLabels := map[string]map[string]string{
+		"a": {"team": "shop", "env": "prod"},
+		"b": {"team": "shop", "env": "prod"},
+		"c": {"team": "shop"},
+	}
+
+	groups := GroupByLabels(podCosts, podLabels, []string{"team", "env"})
+	assert.Equal(t, []LabelGroup{
+		{Values: []string{"shop", "prod"}, Cost: 3},
+		{Values: []string{"shop", UNLABELLED}, Cost: 4},
+		{Values: []string{UNLABELLED, UNLABELLED}, Cost: 8},
+	}, groups)
+
+	groups = GroupByLabels(podCosts, podLabels, []string{"team"})
+	assert.Equal(t, []LabelGroup{
+		{Values: []string{"shop"}, Cost: 7},
+		{Values: []string{UNLABELLED}, Cost: 8},
+	}, groups)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"dat067/costestimation/anomaly"
+	"dat067/costestimation/prometheus"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A pod of a deployment with an anomalous cost, and its cost in the anomalous hour
+type AnomalyPod struct {
+	Pod  string  `json:"pod"`
+	Node string  `json:"node"`
+	Cost float64 `json:"cost"`
+}
+
+// An hour in which the cost of a deployment was far above its usual hourly cost
+type Anomaly struct {
+	Deployment string `json:"deployment"`
+	// The end of the hour
+	Time     time.Time    `json:"time"`
+	Cost     float64      `json:"cost"`
+	Baseline float64      `json:"baseline"`
+	Score    float64      `json:"score"`
+	Pods     []AnomalyPod `json:"pods"`
+	Nodes    []string     `json:"nodes"`
+}
+
+/*
+ * The hourly costs of every deployment in the detection window and the anomalies found in it
+ */
+type anomalyState struct {
+	lock      sync.Mutex
+	window    time.Duration
+	detector  anomaly.Detector
+	history   map[string][]float64
+	end       time.Time
+	anomalies []Anomaly
+}
+
+var anomalies *anomalyState
+
+func newAnomalyState(window time.Duration, detector anomaly.Detector) *anomalyState {
+	return &anomalyState{
+		window:   window,
+		detector: detector,
+		history:  make(map[string][]float64),
+	}
+}
+
+/*
+ * Checks the hours completed since the last check for anomalies every hour, forever, and posts new anomalies to the webhook if it is set
+ */
+func runAnomalyDetector(source prometheus.MetricsSource, state *anomalyState, webhook string) {
+	for {
+		endTime := time.Now().Truncate(time.Hour)
+		found, err := state.detect(source, endTime)
+		if err != nil {
+			fmt.Printf("Could not check the costs for anomalies: '%v'\n", err)
+		}
+
+		for _, a := range found {
+			fmt.Println(a.Message())
+			if webhook != "" {
+				err := postAnomaly(webhook, a)
+				if err != nil {
+					fmt.Printf("Could not post the anomaly: '%v'\n", err)
+				}
+			}
+		}
+
+		time.Sleep(time.Until(endTime.Add(time.Hour)))
+	}
+}
+
+/*
+ * Computes the hourly cost of every deployment from the end of the previous check, or the start of the window, until endTime.
+ * Every hour is compared with the hours before it in the window. Returns the anomalies found
+ */
+func (s *anomalyState) detect(source prometheus.MetricsSource, endTime time.Time) ([]Anomaly, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	startTime := s.end
+	if startTime.Before(endTime.Add(-s.window)) {
+		startTime = endTime.Add(-s.window)
+	}
+
+	if !endTime.After(startTime) {
+		return nil, nil
+	}
+
+	steps, err := getPodCostSteps(source, startTime, endTime, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	podDeployments := source.GetPodsToDeployment(endTime, endTime.Sub(startTime))
+	maxHistory := int(s.window / time.Hour)
+	found := []Anomaly{}
+
+	for _, step := range steps {
+		costs := make(map[string]float64)
+		pods := make(map[string][]AnomalyPod)
+		for pod, price := range step.Costs.Prices {
+			deployment, ok := podDeployments[pod]
+			if !ok {
+				continue
+			}
+
+			costs[deployment] += price
+			pods[deployment] = append(pods[deployment], AnomalyPod{Pod: pod, Node: step.Costs.Nodes[pod], Cost: price})
+		}
+
+		for deployment, cost := range costs {
+			history := s.history[deployment]
+			result, spike := s.detector.Check(history, cost)
+			if spike {
+				found = append(found, newAnomaly(deployment, step.Time, cost, result, pods[deployment]))
+			}
+
+			history = append(history, cost)
+			if len(history) > maxHistory {
+				history = history[len(history)-maxHistory:]
+			}
+			s.history[deployment] = history
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].Time.Equal(found[j].Time) {
+			return found[i].Time.Before(found[j].Time)
+		}
+		return found[i].Deployment < found[j].Deployment
+	})
+
+	// Anomalies are kept as long as the hours they are compared with
+	kept := []Anomaly{}
+	for _, a := range append(s.anomalies, found...) {
+		if a.Time.After(endTime.Add(-s.window)) {
+			kept = append(kept, a)
+		}
+	}
+
+	s.anomalies = kept
+	s.end = endTime
+	return found, nil
+}
+
+func newAnomaly(deployment string, t time.Time, cost float64, result anomaly.Result, pods []AnomalyPod) Anomaly {
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Cost > pods[j].Cost
+	})
+
+	nodes := []string{}
+	seen := make(map[string]bool)
+	for _, pod := range pods {
+		if !seen[pod.Node] {
+			seen[pod.Node] = true
+			nodes = append(nodes, pod.Node)
+		}
+	}
+	sort.Strings(nodes)
+
+	// An infinite score, of a deployment whose cost never varied before, can not be written as JSON
+	score := math.Min(result.Score, math.MaxFloat64)
+
+	return Anomaly{
+		Deployment: deployment,
+		Time:       t,
+		Cost:       cost,
+		Baseline:   result.Baseline,
+		Score:      score,
+		Pods:       pods,
+		Nodes:      nodes,
+	}
+}
+
+// Returns a human readable description of the anomaly
+func (a Anomaly) Message() string {
+	return fmt.Sprintf("The cost of deployment '%s' was %.4f in the hour until %s, usually it is %.4f. It ran %d pods on the nodes %v",
+		a.Deployment, a.Cost, a.Time.Format(time.RFC3339), a.Baseline, len(a.Pods), a.Nodes)
+}
+
+// The webhook payload. The text field makes it a Slack compatible message
+type anomalyNotification struct {
+	Text    string  `json:"text"`
+	Anomaly Anomaly `json:"anomaly"`
+}
+
+func postAnomaly(webhook string, a Anomaly) error {
+	body, err := json.Marshal(anomalyNotification{Text: a.Message(), Anomaly: a})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("The webhook %s answered %s", webhook, response.Status)
+	}
+
+	return nil
+}
+
+/*
+ * Returns the anomalies found in the detection window, optionally only those of the deployment query parameter
+ */
+func getAnomalies(c *gin.Context) {
+	if anomalies == nil {
+		c.String(http.StatusNotFound, "Anomaly detection is not enabled, see -anomaly-detection")
+		return
+	}
+
+	deployment := c.Query("deployment")
+	anomalies.lock.Lock()
+	result := []Anomaly{}
+	for _, a := range anomalies.anomalies {
+		if deployment == "" || a.Deployment == deployment {
+			result = append(result, a)
+		}
+	}
+	anomalies.lock.Unlock()
+
+	c.JSON(http.StatusOK, result)
+}
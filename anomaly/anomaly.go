@@ -0,0 +1,80 @@
+package anomaly
+
+import (
+	"math"
+	"sort"
+)
+
+// Scales the median absolute deviation to the standard deviation of normally distributed values
+const MAD_SCALE = 1.4826
+
+/*
+ * Flags values far above the median of their history. The distance is measured in median absolute deviations, which unlike the
+ * standard deviation is not inflated by earlier spikes in the history
+ */
+type Detector struct {
+	// The robust z-score a value must exceed
+	Threshold float64
+	// The relative increase over the median a value must exceed, so that tiny changes of a flat history are not flagged
+	MinIncrease float64
+	// The smallest history values are compared against
+	MinHistory int
+}
+
+// The comparison of a value with its history
+type Result struct {
+	Baseline float64
+	Score    float64
+}
+
+func DefaultDetector() Detector {
+	return Detector{Threshold: 3.5, MinIncrease: 0.2, MinHistory: 24}
+}
+
+/*
+ * Returns the median of the values, or zero for no values
+ */
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	return sorted[middle]
+}
+
+/*
+ * Compares the value with its history and returns whether it is a spike. The score is infinite if the history does not vary at all
+ */
+func (d Detector) Check(history []float64, value float64) (Result, bool) {
+	if len(history) < d.MinHistory || len(history) == 0 {
+		return Result{}, false
+	}
+
+	median := Median(history)
+	deviations := make([]float64, len(history))
+	for i, v := range history {
+		deviations[i] = math.Abs(v - median)
+	}
+
+	result := Result{Baseline: median}
+	scale := MAD_SCALE * Median(deviations)
+
+	switch {
+	case scale > 0:
+		result.Score = (value - median) / scale
+	case value > median:
+		result.Score = math.Inf(1)
+	case value < median:
+		result.Score = math.Inf(-1)
+	}
+
+	return result, result.Score > d.Threshold && value > median*(1+d.MinIncrease)
+}
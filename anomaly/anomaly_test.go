@@ -0,0 +1,44 @@
+package anomaly
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMedian(t *testing.T) {
+	assert.Equal(t, 0.0, Median(nil))
+	assert.Equal(t, 2.0, Median([]float64{3, 1, 2}))
+	assert.Equal(t, 2.5, Median([]float64{4, 1, 3, 2}))
+}
+
+func TestCheck(t *testing.T) {
+	detector := Detector{Threshold: 3.5, MinIncrease: 0.2, MinHistory: 5}
+	history := []float64{10, 11, 9, 10, 12, 10, 9, 11}
+
+	result, spike := detector.Check(history, 20)
+	assert.True(t, spike)
+	assert.Equal(t, 10.0, result.Baseline)
+	assert.InDelta(t, 10/MAD_SCALE, result.Score, 1e-9)
+
+	_, spike = detector.Check(history, 11.5)
+	assert.False(t, spike)
+
+	// An earlier spike does not hide the next one
+	_, spike = detector.Check(append(history, 40), 20)
+	assert.True(t, spike)
+
+	// Too short history
+	_, spike = detector.Check(history[:4], 20)
+	assert.False(t, spike)
+
+	// A flat history flags increases above the minimum increase only
+	flat := []float64{10, 10, 10, 10, 10}
+	result, spike = detector.Check(flat, 11)
+	assert.True(t, math.IsInf(result.Score, 1))
+	assert.False(t, spike)
+
+	_, spike = detector.Check(flat, 20)
+	assert.True(t, spike)
+}
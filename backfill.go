@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"dat067/costestimation/allocation"
+	"dat067/costestimation/prometheus"
+	"dat067/costestimation/store"
+)
+
+/*
+ * Computes and stores the cost windows between startTime and endTime, one chunk at a time.
+ * The end of every completed chunk is saved as a checkpoint, so a backfill with the same range and step continues where it was interrupted
+ */
+func runBackfill(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, step time.Duration, chunk time.Duration) error {
+	if !startTime.Before(endTime) {
+		return fmt.Errorf("The backfill start time %s is not before the end time %s", startTime, endTime)
+	}
+
+	if step <= 0 || chunk < step {
+		return fmt.Errorf("Invalid backfill step %s and chunk %s", step, chunk)
+	}
+
+	// A whole number of steps per chunk keeps the windows aligned between chunks, and a whole number of steps in the range keeps
+	// the last window from being shorter than a step
+	chunk = chunk.Truncate(step)
+	if tail := endTime.Sub(startTime) % step; tail != 0 {
+		endTime = endTime.Add(-tail)
+		fmt.Printf("Leaving out the last %s of the backfill, it is shorter than the step %s\n", tail, step)
+
+		if !startTime.Before(endTime) {
+			return fmt.Errorf("The backfill between %s and %s is shorter than the step %s", startTime, endTime.Add(tail), step)
+		}
+	}
+
+	checkpointName := fmt.Sprintf("backfill/%d/%d/%s", startTime.Unix(), endTime.Unix(), step)
+	checkpoint, found, err := costStore.GetCheckpoint(checkpointName)
+	if err != nil {
+		return err
+	}
+
+	chunkStart := startTime
+	if found && checkpoint.After(startTime) {
+		fmt.Printf("Resuming backfill from %s\n", checkpoint)
+		chunkStart = checkpoint
+	}
+
+	for chunkStart.Before(endTime) {
+		chunkEnd := chunkStart.Add(chunk)
+		if chunkEnd.After(endTime) {
+			chunkEnd = endTime
+		}
+
+		windows, err := computeWindows(source, chunkStart, chunkEnd, step)
+		if err != nil {
+			return err
+		}
+
+		for _, window := range windows {
+			err = costStore.PutWindow(window)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = costStore.PutCheckpoint(checkpointName, chunkEnd)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Backfilled %d windows between %s and %s\n", len(windows), chunkStart, chunkEnd)
+		chunkStart = chunkEnd
+	}
+
+	return nil
+}
+
+/*
+ * Computes one window per step between startTime and endTime, using a single range query per node
+ */
+func computeWindows(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, step time.Duration) ([]store.Window, error) {
+	steps, err := getPodCostSteps(source, startTime, endTime, step)
+	if err != nil {
+		return nil, err
+	}
+
+	deploymentMap := source.GetPodsToDeployment(endTime, endTime.Sub(startTime))
+	podLabels, warnings, err := source.GetPodLabels(endTime, endTime.Sub(startTime))
+	if warnings != nil {
+		fmt.Println(warnings)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	windows := make([]store.Window, 0, len(steps))
+	for _, costStep := range steps {
+		costs := costStep.Costs
+		if idlePolicy == allocation.IdleRedistribute {
+			redistributeIdleCost(costs)
+		}
+
+		window := store.Window{
+			Start:    costStep.Time.Add(-step),
+			End:      costStep.Time,
+			Pods:     make(map[string]store.PodCost),
+			NodeIdle: costs.NodeIdle,
+		}
+
+		for pod, price := range costs.Prices {
+			window.Pods[pod] = store.PodCost{
+				Namespace:  costs.Namespaces[pod],
+				Deployment: deploymentMap[pod],
+				Price:      price,
+				CPU:        costs.UsageCPU[pod],
+				Memory:     costs.UsageMemory[pod],
+				Waste:      costs.Wasted[pod],
+				Idle:       costs.IdleShares[pod],
+				Labels:     podLabels[pod],
+			}
+		}
+
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
@@ -0,0 +1,206 @@
+package budget
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The webhook formats
+const (
+	FORMAT_SLACK        = "slack"
+	FORMAT_ALERTMANAGER = "alertmanager"
+)
+
+// Whether an alert is about the actual or the projected spend
+const (
+	KIND_ACTUAL    = "actual"
+	KIND_PROJECTED = "projected"
+)
+
+// A budget threshold crossed by the actual or projected spend
+type Alert struct {
+	Status    Status  `json:"status"`
+	Threshold float64 `json:"threshold"`
+	Kind      string  `json:"kind"`
+}
+
+/*
+ * Sends alerts to webhooks, at most once per budget, period, threshold, kind and webhook
+ */
+type Alerter struct {
+	webhooks   []Webhook
+	thresholds []float64
+	client     *http.Client
+	lock       sync.Mutex
+	// The keys of the sent alerts and the end of their period, after which they are forgotten
+	sent map[string]time.Time
+}
+
+func NewAlerter(config Config) *Alerter {
+	thresholds := append([]float64{}, config.Thresholds...)
+	sort.Float64s(thresholds)
+
+	return &Alerter{
+		webhooks:   config.Webhooks,
+		thresholds: thresholds,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		sent:       make(map[string]time.Time),
+	}
+}
+
+/*
+ * Returns the alerts of the thresholds crossed by the statuses. The projected spend is only alerted on for thresholds the actual spend has not crossed yet
+ */
+func (a *Alerter) Alerts(statuses []Status) []Alert {
+	alerts := []Alert{}
+
+	for _, status := range statuses {
+		for _, threshold := range a.thresholds {
+			limit := threshold * status.Budget.Amount
+
+			if status.Actual >= limit {
+				alerts = append(alerts, Alert{Status: status, Threshold: threshold, Kind: KIND_ACTUAL})
+			} else if status.Projected >= limit {
+				alerts = append(alerts, Alert{Status: status, Threshold: threshold, Kind: KIND_PROJECTED})
+			}
+		}
+	}
+
+	return alerts
+}
+
+/*
+ * Sends the alerts of the statuses that have not been sent to a webhook before. Alerts failing to be sent are tried again on the next call.
+ * Returns the alerts and the first error
+ */
+func (a *Alerter) Notify(statuses []Status, now time.Time) ([]Alert, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for key, periodEnd := range a.sent {
+		if !now.Before(periodEnd) {
+			delete(a.sent, key)
+		}
+	}
+
+	alerts := a.Alerts(statuses)
+	var firstErr error
+
+	for _, webhook := range a.webhooks {
+		unsent := []Alert{}
+
+		for _, alert := range alerts {
+			if _, ok := a.sent[alert.key(webhook)]; !ok {
+				unsent = append(unsent, alert)
+			}
+		}
+
+		if len(unsent) == 0 {
+			continue
+		}
+
+		err := a.send(webhook, unsent, now)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		for _, alert := range unsent {
+			a.sent[alert.key(webhook)] = alert.Status.PeriodEnd
+		}
+	}
+
+	return alerts, firstErr
+}
+
+func (a Alert) key(webhook Webhook) string {
+	return fmt.Sprintf("%s/%s/%d/%s/%g", webhook.URL, a.Status.Budget.Name, a.Status.PeriodStart.Unix(), a.Kind, a.Threshold)
+}
+
+// Returns a human readable description of the alert
+func (a Alert) Message() string {
+	b := a.Status.Budget
+
+	if a.Kind == KIND_PROJECTED {
+		return fmt.Sprintf("Budget '%s' is projected to spend %.2f of %.2f %s (%.0f%%) by %s", b.Name, a.Status.Projected, b.Amount, b.Currency, 100*a.Status.Projected/b.Amount, a.Status.PeriodEnd.Format("2006-01-02"))
+	}
+
+	return fmt.Sprintf("Budget '%s' has spent %.2f of %.2f %s (%.0f%%) since %s", b.Name, a.Status.Actual, b.Amount, b.Currency, 100*a.Status.Actual/b.Amount, a.Status.PeriodStart.Format("2006-01-02"))
+}
+
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+func (a *Alerter) send(webhook Webhook, alerts []Alert, now time.Time) error {
+	var payload interface{}
+
+	switch webhook.Format {
+	case FORMAT_SLACK:
+		lines := make([]string, len(alerts))
+		for i, alert := range alerts {
+			lines[i] = alert.Message()
+		}
+
+		payload = slackMessage{Text: strings.Join(lines, "\n")}
+	case FORMAT_ALERTMANAGER:
+		amAlerts := make([]alertmanagerAlert, len(alerts))
+
+		for i, alert := range alerts {
+			severity := "warning"
+			if alert.Threshold >= 1 {
+				severity = "critical"
+			}
+
+			// The alert stays active until the end of the period, when the spend starts over
+			amAlerts[i] = alertmanagerAlert{
+				Labels: map[string]string{
+					"alertname": "CostBudgetThreshold",
+					"budget":    alert.Status.Budget.Name,
+					"kind":      alert.Kind,
+					"threshold": fmt.Sprintf("%g", alert.Threshold),
+					"severity":  severity,
+				},
+				Annotations: map[string]string{"summary": alert.Message()},
+				StartsAt:    now,
+				EndsAt:      alert.Status.PeriodEnd,
+			}
+		}
+
+		payload = amAlerts
+	default:
+		return fmt.Errorf("Unknown webhook format '%s'", webhook.Format)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	response, err := a.client.Post(webhook.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("The webhook %s answered %s", webhook.URL, response.Status)
+	}
+
+	return nil
+}
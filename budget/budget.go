@@ -0,0 +1,179 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"dat067/costestimation/timerange"
+)
+
+// The periods a budget can be defined for
+const (
+	PERIOD_DAY   = "day"
+	PERIOD_WEEK  = "week"
+	PERIOD_MONTH = "month"
+)
+
+// The fractions of a budget alerted on when no thresholds are configured
+var DEFAULT_THRESHOLDS = []float64{0.8, 1.0}
+
+/*
+ * A spending limit of the pods of a namespace, a deployment or a pod label per period. Exactly one of Namespace, Deployment and Label is set.
+ * Label is a key=value pair, e.g. team=shop
+ */
+type Budget struct {
+	Name       string  `json:"name"`
+	Namespace  string  `json:"namespace,omitempty"`
+	Deployment string  `json:"deployment,omitempty"`
+	Label      string  `json:"label,omitempty"`
+	Amount     float64 `json:"amount"`
+	Currency   string  `json:"currency"`
+	// day, week or month. Defaults to month
+	Period string `json:"period,omitempty"`
+}
+
+// Where alerts are sent. Format is "slack" for a Slack compatible incoming webhook or "alertmanager" for the Alertmanager v2 API
+type Webhook struct {
+	URL    string `json:"url"`
+	Format string `json:"format"`
+}
+
+// The budget configuration file, e.g. mounted from a ConfigMap
+type Config struct {
+	Budgets []Budget `json:"budgets"`
+	// The fractions of the amount alerted on, e.g. 0.8 and 1.0
+	Thresholds []float64 `json:"thresholds,omitempty"`
+	Webhooks   []Webhook `json:"webhooks,omitempty"`
+}
+
+// The spend of a budget in its current period
+type Status struct {
+	Budget      Budget    `json:"budget"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	Actual      float64   `json:"actual"`
+	// The spend at the end of the period if the spending continues at the same rate
+	Projected float64 `json:"projected"`
+}
+
+/*
+ * Reads a JSON budget configuration from the path
+ */
+func LoadConfig(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := Config{}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("Could not parse the budget configuration %s: %v", path, err)
+	}
+
+	if len(config.Thresholds) == 0 {
+		config.Thresholds = DEFAULT_THRESHOLDS
+	}
+
+	return config, config.Validate()
+}
+
+/*
+ * Checks that every budget has a unique name, exactly one scope, a positive amount and a known period, and that the webhooks have known formats
+ */
+func (c Config) Validate() error {
+	names := make(map[string]bool)
+
+	for _, b := range c.Budgets {
+		if b.Name == "" || names[b.Name] {
+			return fmt.Errorf("Every budget needs a unique name, got '%s'", b.Name)
+		}
+		names[b.Name] = true
+
+		scopes := 0
+		for _, scope := range []string{b.Namespace, b.Deployment, b.Label} {
+			if scope != "" {
+				scopes++
+			}
+		}
+
+		if scopes != 1 {
+			return fmt.Errorf("The budget '%s' needs exactly one of namespace, deployment and label", b.Name)
+		}
+
+		if b.Label != "" && !strings.Contains(b.Label, "=") {
+			return fmt.Errorf("The label of the budget '%s' is not a key=value pair: '%s'", b.Name, b.Label)
+		}
+
+		if b.Amount <= 0 {
+			return fmt.Errorf("The amount of the budget '%s' must be positive", b.Name)
+		}
+
+		if _, _, err := Period(b.Period, time.Now()); err != nil {
+			return fmt.Errorf("The budget '%s' has an invalid period: %v", b.Name, err)
+		}
+	}
+
+	for _, threshold := range c.Thresholds {
+		if threshold <= 0 {
+			return fmt.Errorf("Invalid threshold %f", threshold)
+		}
+	}
+
+	for _, webhook := range c.Webhooks {
+		if webhook.Format != FORMAT_SLACK && webhook.Format != FORMAT_ALERTMANAGER {
+			return fmt.Errorf("Unknown webhook format '%s'", webhook.Format)
+		}
+	}
+
+	return nil
+}
+
+// Returns the label key and value of a label budget
+func (b Budget) LabelPair() (string, string) {
+	pair := strings.SplitN(b.Label, "=", 2)
+	return strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])
+}
+
+/*
+ * Returns the start and end of the period containing now. Weeks start on Monday
+ */
+func Period(period string, now time.Time) (time.Time, time.Time, error) {
+	switch period {
+	case PERIOD_DAY:
+		start, err := timerange.ParseTime("startOfDay", now)
+		return start, start.AddDate(0, 0, 1), err
+	case PERIOD_WEEK:
+		start, err := timerange.ParseTime("startOfWeek", now)
+		return start, start.AddDate(0, 0, 7), err
+	case PERIOD_MONTH, "":
+		start, err := timerange.ParseTime("startOfMonth", now)
+		return start, start.AddDate(0, 1, 0), err
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("Unknown period '%s'", period)
+}
+
+/*
+ * Projects the actual spend between the start of the period and now linearly to the end of the period
+ */
+func Evaluate(b Budget, actual float64, periodStart time.Time, periodEnd time.Time, now time.Time) Status {
+	status := Status{
+		Budget:      b,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		Actual:      actual,
+		Projected:   actual,
+	}
+
+	elapsed := now.Sub(periodStart)
+	if elapsed > 0 && now.Before(periodEnd) {
+		status.Projected = actual * float64(periodEnd.Sub(periodStart)) / float64(elapsed)
+	}
+
+	return status
+}
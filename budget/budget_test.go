@@ -0,0 +1,104 @@
+package budget
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriod(t *testing.T) {
+	now := time.Date(2021, 12, 24, 15, 30, 0, 0, time.UTC)
+
+	start, end, err := Period(PERIOD_MONTH, now)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), end)
+
+	start, end, err = Period(PERIOD_WEEK, now)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 12, 20, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2021, 12, 27, 0, 0, 0, 0, time.UTC), end)
+
+	_, _, err = Period("fortnight", now)
+	assert.NotNil(t, err)
+}
+
+func TestEvaluate(t *testing.T) {
+	start := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := Budget{Name: "shop", Namespace: "shop", Amount: 100}
+
+	status := Evaluate(b, 25, start, end, start.Add(31*24*time.Hour/4))
+	assert.InDelta(t, 100, status.Projected, 1e-9)
+
+	// Nothing is projected after the end of the period
+	status = Evaluate(b, 25, start, end, end.Add(time.Hour))
+	assert.Equal(t, 25.0, status.Projected)
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, Config{Budgets: []Budget{{Name: "shop", Namespace: "shop", Amount: 1}}}.Validate())
+	assert.NotNil(t, Config{Budgets: []Budget{{Name: "shop", Namespace: "shop", Deployment: "web", Amount: 1}}}.Validate())
+	assert.NotNil(t, Config{Budgets: []Budget{{Name: "shop", Label: "team", Amount: 1}}}.Validate())
+	assert.NotNil(t, Config{Budgets: []Budget{{Name: "shop", Namespace: "shop"}}}.Validate())
+	assert.NotNil(t, Config{Budgets: []Budget{{Name: "a", Namespace: "a", Amount: 1}, {Name: "a", Namespace: "b", Amount: 1}}}.Validate())
+	assert.NotNil(t, Config{Webhooks: []Webhook{{URL: "http://example.com", Format: "teams"}}}.Validate())
+}
+
+func TestAlerter(t *testing.T) {
+	var slackMessages []slackMessage
+	var amAlerts [][]alertmanagerAlert
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/slack" {
+			message := slackMessage{}
+			json.Unmarshal(body, &message)
+			slackMessages = append(slackMessages, message)
+		} else {
+			alerts := []alertmanagerAlert{}
+			json.Unmarshal(body, &alerts)
+			amAlerts = append(amAlerts, alerts)
+		}
+	}))
+	defer server.Close()
+
+	alerter := NewAlerter(Config{
+		Thresholds: DEFAULT_THRESHOLDS,
+		Webhooks: []Webhook{
+			{URL: server.URL + "/slack", Format: FORMAT_SLACK},
+			{URL: server.URL + "/api/v2/alerts", Format: FORMAT_ALERTMANAGER},
+		},
+	})
+
+	start := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(24 * time.Hour)
+	status := Status{Budget: Budget{Name: "shop", Amount: 100, Currency: "SEK"}, PeriodStart: start, PeriodEnd: end, Actual: 85, Projected: 130}
+
+	alerts, err := alerter.Notify([]Status{status}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, []Alert{{Status: status, Threshold: 0.8, Kind: KIND_ACTUAL}, {Status: status, Threshold: 1, Kind: KIND_PROJECTED}}, alerts)
+	assert.Equal(t, 1, len(slackMessages))
+	assert.Equal(t, "Budget 'shop' has spent 85.00 of 100.00 SEK (85%) since 2021-12-01\nBudget 'shop' is projected to spend 130.00 of 100.00 SEK (130%) by 2022-01-01", slackMessages[0].Text)
+	assert.Equal(t, 1, len(amAlerts))
+	assert.Equal(t, "critical", amAlerts[0][1].Labels["severity"])
+	assert.Equal(t, end, amAlerts[0][1].EndsAt)
+
+	// Alerts already sent are not sent again
+	_, err = alerter.Notify([]Status{status}, now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(slackMessages))
+
+	// Crossing the next threshold sends only the new alert
+	status.Actual = 101
+	_, err = alerter.Notify([]Status{status}, now.Add(2*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(slackMessages))
+	assert.Equal(t, "Budget 'shop' has spent 101.00 of 100.00 SEK (101%) since 2021-12-01", slackMessages[1].Text)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"dat067/costestimation/budget"
+	"dat067/costestimation/pricing"
+	"dat067/costestimation/prometheus"
+	"dat067/costestimation/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+var budgetConfig budget.Config
+var budgetAlerter *budget.Alerter
+
+/*
+ * Reads the budget configuration. Budgets without a currency are in the currency the node prices are in, other currencies are refused
+ */
+func loadBudgetConfig(path string) (budget.Config, error) {
+	config, err := budget.LoadConfig(path)
+	if err != nil {
+		return budget.Config{}, err
+	}
+
+	currency, err := pricing.SEK.String()
+	if err != nil {
+		return budget.Config{}, err
+	}
+
+	for i, b := range config.Budgets {
+		if b.Currency == "" {
+			config.Budgets[i].Currency = currency
+		} else if b.Currency != currency {
+			return budget.Config{}, fmt.Errorf("The budget '%s' is in %s, but costs are computed in %s", b.Name, b.Currency, currency)
+		}
+	}
+
+	return config, nil
+}
+
+/*
+ * The statuses of the last evaluation of the budgets. GET /budgets answers with them until they are older than maxAge,
+ * so that the costs of the period are not computed again for every request
+ */
+type budgetCache struct {
+	lock      sync.Mutex
+	maxAge    time.Duration
+	statuses  []budget.Status
+	evaluated time.Time
+}
+
+var budgetStatuses = &budgetCache{maxAge: time.Hour}
+
+// Returns the statuses of the last evaluation if it is recent enough
+func (c *budgetCache) get(now time.Time) ([]budget.Status, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.statuses == nil || now.Sub(c.evaluated) > c.maxAge {
+		return nil, false
+	}
+
+	return c.statuses, true
+}
+
+func (c *budgetCache) set(statuses []budget.Status, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.statuses = statuses
+	c.evaluated = now
+}
+
+/*
+ * Evaluates the budgets and sends alerts for the crossed thresholds every interval, forever
+ */
+func runBudgetEvaluator(source prometheus.MetricsSource, interval time.Duration) {
+	for {
+		now := time.Now()
+		statuses, err := evaluateBudgets(source, budgetConfig.Budgets, now)
+		if err != nil {
+			fmt.Printf("Could not evaluate the budgets: '%v'\n", err)
+		} else {
+			budgetStatuses.set(statuses, now)
+			alerts, err := budgetAlerter.Notify(statuses, now)
+			if err != nil {
+				fmt.Printf("Could not send budget alerts: '%v'\n", err)
+			}
+
+			for _, alert := range alerts {
+				fmt.Println(alert.Message())
+			}
+		}
+
+		time.Sleep(time.Until(now.Add(interval)))
+	}
+}
+
+func getBudgets(c *gin.Context) {
+	now := time.Now()
+	if statuses, ok := budgetStatuses.get(now); ok {
+		c.JSON(http.StatusOK, statuses)
+		return
+	}
+
+	statuses, err := evaluateBudgets(metricsSource, budgetConfig.Budgets, now)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	budgetStatuses.set(statuses, now)
+	c.JSON(http.StatusOK, statuses)
+}
+
+/*
+ * Calculates the spend of every budget from the start of its period until the last full hour before now, and projects it to the end of the period
+ */
+func evaluateBudgets(source prometheus.MetricsSource, budgets []budget.Budget, now time.Time) ([]budget.Status, error) {
+	statuses := make([]budget.Status, 0, len(budgets))
+	// Budgets of the same period share the cost computation
+	periods := make(map[int64]budgetPeriodCosts)
+
+	for _, b := range budgets {
+		periodStart, periodEnd, err := budget.Period(b.Period, now)
+		if err != nil {
+			return nil, err
+		}
+
+		endTime := now.Truncate(time.Hour)
+		if !endTime.After(periodStart) {
+			endTime = now
+		}
+
+		costs, ok := periods[periodStart.Unix()]
+		if !ok {
+			costs, err = getBudgetPeriodCosts(source, periodStart, endTime)
+			if err != nil {
+				return nil, err
+			}
+			periods[periodStart.Unix()] = costs
+		}
+
+		actual, err := costs.spend(source, b)
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, budget.Evaluate(b, actual, periodStart, periodEnd, endTime))
+	}
+
+	return statuses, nil
+}
+
+/*
+ * The costs of a budget period so far. The windows of the cost store are used where there are any, and the rest of the period is computed live
+ */
+type budgetPeriodCosts struct {
+	windows []store.Window
+	live    []liveBudgetCosts
+}
+
+// The live costs of a part of a budget period the cost store does not cover
+type liveBudgetCosts struct {
+	costs     podCosts
+	startTime time.Time
+	endTime   time.Time
+}
+
+func getBudgetPeriodCosts(source prometheus.MetricsSource, startTime time.Time, endTime time.Time) (budgetPeriodCosts, error) {
+	period := budgetPeriodCosts{}
+	uncovered := []store.TimeRange{{Start: startTime, End: endTime}}
+
+	if costStore != nil {
+		windows, err := costStore.GetWindows(startTime, endTime)
+		if err != nil {
+			return budgetPeriodCosts{}, err
+		}
+
+		period.windows = windows
+		uncovered = store.Uncovered(windows, startTime, endTime)
+	}
+
+	for _, r := range uncovered {
+		resolution := time.Hour
+		if r.End.Sub(r.Start) < resolution {
+			resolution = r.End.Sub(r.Start)
+		}
+
+		costs, err := getPodCosts(source, r.Start, r.End, resolution)
+		if err != nil {
+			return budgetPeriodCosts{}, err
+		}
+
+		period.live = append(period.live, liveBudgetCosts{costs: costs, startTime: r.Start, endTime: r.End})
+	}
+
+	return period, nil
+}
+
+/*
+ * Sums the costs of the pods in the scope of the budget over the stored and the live parts of the period
+ */
+func (p budgetPeriodCosts) spend(source prometheus.MetricsSource, b budget.Budget) (float64, error) {
+	spend := 0.0
+
+	for _, window := range p.windows {
+		windowSpend, err := getStoredBudgetSpend(b, window)
+		if err != nil {
+			return 0, err
+		}
+		spend += windowSpend
+	}
+
+	for _, live := range p.live {
+		liveSpend, err := getBudgetSpend(source, b, live.costs, live.endTime, live.endTime.Sub(live.startTime))
+		if err != nil {
+			return 0, err
+		}
+		spend += liveSpend
+	}
+
+	return spend, nil
+}
+
+/*
+ * Sums the costs of the pods of the stored window in the scope of the budget. Windows stored before the pod labels were kept
+ * cannot be matched with a label budget
+ */
+func getStoredBudgetSpend(b budget.Budget, window store.Window) (float64, error) {
+	spend := 0.0
+
+	for name, pod := range window.Pods {
+		switch {
+		case b.Namespace != "":
+			if pod.Namespace == b.Namespace {
+				spend += pod.Price
+			}
+		case b.Deployment != "":
+			if pod.Deployment == b.Deployment {
+				spend += pod.Price
+			}
+		case b.Label != "":
+			if !pod.HasSplit() {
+				return 0, fmt.Errorf("The cost of the pod %s was stored at %s without its labels, backfill the period again to evaluate the budget '%s'", name, window.Start, b.Name)
+			}
+
+			key, value := b.LabelPair()
+			if pod.Labels[prometheus.SanitizeLabelName(key)] == value {
+				spend += pod.Price
+			}
+		}
+	}
+
+	return spend, nil
+}
+
+/*
+ * Sums the costs of the pods in the scope of the budget
+ */
+func getBudgetSpend(source prometheus.MetricsSource, b budget.Budget, costs podCosts, endTime time.Time, duration time.Duration) (float64, error) {
+	spend := 0.0
+
+	switch {
+	case b.Namespace != "":
+		for pod, price := range costs.Prices {
+			if costs.Namespaces[pod] == b.Namespace {
+				spend += price
+			}
+		}
+	case b.Deployment != "":
+		for pod, deployment := range source.GetPodsToDeployment(endTime, duration) {
+			if deployment == b.Deployment {
+				spend += costs.Prices[pod]
+			}
+		}
+	case b.Label != "":
+		podLabels, warnings, err := source.GetPodLabels(endTime, duration)
+		if warnings != nil {
+			fmt.Println(warnings)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		key, value := b.LabelPair()
+		key = prometheus.SanitizeLabelName(key)
+		for pod, price := range costs.Prices {
+			if podLabels[pod][key] == value {
+				spend += price
+			}
+		}
+	}
+
+	return spend, nil
+}
@@ -0,0 +1,198 @@
+package chargeback
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"dat067/costestimation/report"
+)
+
+// Name of the index of the generated reports, at the root of the storage
+const INDEX_NAME = "index.json"
+
+// The formats every report is rendered in
+var FORMATS = []string{report.FORMAT_CSV, report.FORMAT_JSON, report.FORMAT_HTML}
+
+var unsafeNameCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// A generated chargeback report, with the names of its renderings in the storage by format
+type Entry struct {
+	ID string `json:"id"`
+	// The label the cost centres are the values of
+	Label       string            `json:"label"`
+	Start       time.Time         `json:"start"`
+	End         time.Time         `json:"end"`
+	GeneratedAt time.Time         `json:"generatedAt"`
+	Currency    string            `json:"currency"`
+	Total       float64           `json:"total"`
+	Files       map[string]string `json:"files"`
+	Warnings    []string          `json:"warnings,omitempty"`
+}
+
+/*
+ * The generated reports and their index, kept in a Storage. The index is only written after the renderings, so every indexed report
+ * can be read
+ */
+type Archive struct {
+	storage Storage
+	lock    sync.Mutex
+}
+
+func NewArchive(storage Storage) *Archive {
+	return &Archive{storage: storage}
+}
+
+/*
+ * Returns the first day of the month before the one now is in, and the first day of the month now is in, in UTC
+ */
+func PreviousMonth(now time.Time) (time.Time, time.Time) {
+	year, month, _ := now.UTC().Date()
+	end := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return end.AddDate(0, -1, 0), end
+}
+
+// Returns the ID of the report of the cost centres of the label in the month starting at start, e.g. 2021-12-team
+func ID(label string, start time.Time) string {
+	return start.UTC().Format("2006-01") + "-" + unsafeNameCharacters.ReplaceAllString(label, "_")
+}
+
+/*
+ * Returns the index of the generated reports, the newest period first. An archive without an index is empty
+ */
+func (a *Archive) Index() ([]Entry, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.readIndex()
+}
+
+func (a *Archive) readIndex() ([]Entry, error) {
+	data, err := a.storage.Get(INDEX_NAME)
+	if err == ErrNotFound {
+		return []Entry{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("Could not parse the chargeback index: %v", err)
+	}
+
+	return entries, nil
+}
+
+// Returns the entry of the report with the ID, and whether there is one
+func (a *Archive) Find(id string) (Entry, bool, error) {
+	entries, err := a.Index()
+	if err != nil {
+		return Entry{}, false, err
+	}
+
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, true, nil
+		}
+	}
+
+	return Entry{}, false, nil
+}
+
+/*
+ * Renders the report in every format, stores the renderings and adds the report to the index, replacing an earlier report with the same ID
+ */
+func (a *Archive) Save(id string, label string, r *report.Report, generatedAt time.Time, warnings []string) (Entry, error) {
+	entry := Entry{
+		ID:          id,
+		Label:       label,
+		Start:       r.Start,
+		End:         r.End,
+		GeneratedAt: generatedAt,
+		Currency:    r.Currency,
+		Total:       r.Total().Total(),
+		Files:       make(map[string]string),
+		Warnings:    warnings,
+	}
+
+	for _, format := range FORMATS {
+		rendered := bytes.Buffer{}
+		var err error
+		if format == report.FORMAT_HTML {
+			err = r.WriteHTML(&rendered, fmt.Sprintf("Chargeback by %s, %s", label, r.Start.UTC().Format("January 2006")))
+		} else {
+			err = r.Write(&rendered, format)
+		}
+
+		if err != nil {
+			return Entry{}, err
+		}
+
+		name := id + "/chargeback." + format
+		if err := a.storage.Put(name, report.ContentType(format), rendered.Bytes()); err != nil {
+			return Entry{}, err
+		}
+		entry.Files[format] = name
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	entries, err := a.readIndex()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	replaced := false
+	for i := range entries {
+		if entries[i].ID == id {
+			entries[i] = entry
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Start.Equal(entries[j].Start) {
+			return entries[i].Start.After(entries[j].Start)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if err := a.storage.Put(INDEX_NAME, report.ContentType(report.FORMAT_JSON), data); err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+/*
+ * Returns a rendering of the report with the ID in the format, and its MIME type. Returns ErrNotFound if there is no such report or format
+ */
+func (a *Archive) Get(id string, format string) ([]byte, string, error) {
+	entry, ok, err := a.Find(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	name, hasFormat := entry.Files[format]
+	if !ok || !hasFormat {
+		return nil, "", ErrNotFound
+	}
+
+	data, err := a.storage.Get(name)
+	return data, report.ContentType(format), err
+}
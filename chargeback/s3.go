@@ -0,0 +1,181 @@
+package chargeback
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ * Keeps the reports as objects in a bucket of Amazon S3 or an S3 compatible store such as MinIO. Objects are addressed path style,
+ * i.e. as <endpoint>/<bucket>/<prefix><name>, which MinIO expects, and requests are signed with AWS Signature Version 4
+ */
+type S3Storage struct {
+	// e.g. https://s3.eu-north-1.amazonaws.com or http://localhost:9000
+	Endpoint  string
+	Bucket    string
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+	// Returns the time requests are signed at, time.Now unless testing
+	now func() time.Time
+}
+
+func NewS3Storage(endpoint string, bucket string, prefix string, region string, accessKey string, secretKey string) *S3Storage {
+	return &S3Storage{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Bucket:    bucket,
+		Prefix:    prefix,
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: time.Minute},
+		now:       time.Now,
+	}
+}
+
+/*
+ * Parses a bucket URL like s3://bucket/prefix into the bucket and the prefix of the object names
+ */
+func ParseBucketURL(s string) (string, string, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("Invalid bucket URL '%s', expected s3://bucket/prefix", s)
+	}
+
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return u.Host, prefix, nil
+}
+
+func (s *S3Storage) Put(name string, contentType string, data []byte) error {
+	request, err := http.NewRequest(http.MethodPut, s.objectURL(name), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", contentType)
+	_, err = s.do(request, data)
+	return err
+}
+
+func (s *S3Storage) Get(name string) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, s.objectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.do(request, nil)
+}
+
+func (s *S3Storage) objectURL(name string) string {
+	segments := strings.Split(s.Bucket+"/"+s.Prefix+name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return s.Endpoint + "/" + strings.Join(segments, "/")
+}
+
+/*
+ * Signs and sends the request and returns the response body. A missing object is ErrNotFound and other failures include the error
+ * message of the store
+ */
+func (s *S3Storage) do(request *http.Request, payload []byte) ([]byte, error) {
+	s.sign(request, payload, s.now())
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, fmt.Errorf("The object store answered %s to %s %s: %s", response.Status, request.Method, request.URL.Path, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+/*
+ * Adds the AWS Signature Version 4 headers to the request. The host and every header already set on the request are signed
+ */
+func (s *S3Storage) sign(request *http.Request, payload []byte, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
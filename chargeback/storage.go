@@ -0,0 +1,75 @@
+package chargeback
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotFound = errors.New("Not found")
+
+/*
+ * Where the rendered reports and the index are kept. Names are slash separated paths relative to the root of the storage
+ */
+type Storage interface {
+	Put(name string, contentType string, data []byte) error
+	// Returns ErrNotFound if nothing is stored under the name
+	Get(name string) ([]byte, error)
+}
+
+// Keeps the reports as files below a local directory
+type DirectoryStorage struct {
+	Directory string
+}
+
+func NewDirectoryStorage(directory string) *DirectoryStorage {
+	return &DirectoryStorage{Directory: directory}
+}
+
+/*
+ * Writes the file through a temporary file in the same directory, so that a reader never sees half a report
+ */
+func (d *DirectoryStorage) Put(name string, contentType string, data []byte) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	temporary := path + ".tmp"
+	if err := ioutil.WriteFile(temporary, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, path)
+}
+
+func (d *DirectoryStorage) Get(name string) ([]byte, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+// Returns the path of the file, refusing names outside of the directory
+func (d *DirectoryStorage) path(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Invalid report name '%s'", name)
+	}
+
+	return filepath.Join(d.Directory, clean), nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"dat067/costestimation/allocation"
+	"dat067/costestimation/chargeback"
+	"dat067/costestimation/pricing"
+	"dat067/costestimation/prometheus"
+	"dat067/costestimation/report"
+	"dat067/costestimation/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+var chargebackArchive *chargeback.Archive
+var chargebackLabel string
+
+/*
+ * Creates the archive the chargeback reports are kept in: a bucket if bucketURL is set, e.g. s3://reports/chargeback, and the directory
+ * otherwise. The credentials of the bucket are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+ */
+func newChargebackArchive(directory string, bucketURL string, endpoint string, region string) (*chargeback.Archive, error) {
+	if bucketURL == "" {
+		if directory == "" {
+			return nil, fmt.Errorf("Chargeback reports need a directory or a bucket to be kept in, see -chargeback-dir and -chargeback-bucket")
+		}
+		return chargeback.NewArchive(chargeback.NewDirectoryStorage(directory)), nil
+	}
+
+	bucket, prefix, err := chargeback.ParseBucketURL(bucketURL)
+	if err != nil {
+		return nil, err
+	}
+
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are needed to write to the bucket %s", bucket)
+	}
+
+	return chargeback.NewArchive(chargeback.NewS3Storage(endpoint, bucket, prefix, region, accessKey, secretKey)), nil
+}
+
+/*
+ * Generates the chargeback report of the previous month once it has ended, checking every hour, forever.
+ * The index of the archive tells which months are done, so a restart does not generate a report twice
+ */
+func runChargebackScheduler(source prometheus.MetricsSource) {
+	for {
+		entry, generated, err := generateDueChargeback(source, chargebackArchive, chargebackLabel, time.Now())
+		if err != nil {
+			fmt.Printf("Could not generate the chargeback report: '%v'\n", err)
+		} else if generated {
+			fmt.Printf("Generated the chargeback report %s\n", entry.ID)
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
+
+/*
+ * Generates the chargeback report of the month before now, unless the archive already has it. Returns the entry of the report and
+ * whether it was generated now. A month partly older than the retention of Prometheus is built from the cost store, and refused without one,
+ * so that only whole months are archived
+ */
+func generateDueChargeback(source prometheus.MetricsSource, archive *chargeback.Archive, label string, now time.Time) (chargeback.Entry, bool, error) {
+	startTime, endTime := chargeback.PreviousMonth(now)
+	id := chargeback.ID(label, startTime)
+
+	entry, ok, err := archive.Find(id)
+	if err != nil || ok {
+		return entry, false, err
+	}
+
+	var costReport *report.Report
+	if timeLimits.Retention > 0 && startTime.Before(now.Add(-timeLimits.Retention)) {
+		if costStore == nil {
+			return chargeback.Entry{}, false, fmt.Errorf("Prometheus only keeps %s of data, so the chargeback report of %s needs the cost store, see -store",
+				timeLimits.Retention, startTime.Format("January 2006"))
+		}
+		costReport, err = getStoredChargebackReport(source, startTime, endTime, label, now)
+	} else {
+		costReport, err = getCostReport(source, startTime, endTime, time.Hour, REPORT_LABEL, []string{label})
+	}
+
+	if err != nil {
+		return chargeback.Entry{}, false, err
+	}
+
+	entry, err = archive.Save(id, label, costReport, now, nil)
+	return entry, err == nil, err
+}
+
+/*
+ * Sums the costs of the stored windows between startTime and endTime by the values of the label, like getCostReport does with live costs.
+ * The parts of the period the store does not cover are computed live, which fails if Prometheus no longer has them either
+ */
+func getStoredChargebackReport(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, label string, now time.Time) (*report.Report, error) {
+	currency, err := pricing.SEK.String()
+	if err != nil {
+		return nil, err
+	}
+
+	windows, err := costStore.GetWindows(startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// The costs of a pod are summed over the windows, so that the shared overhead is split like in the live report
+	costs := newPodCosts()
+	podLabels := make(map[string]map[string]string)
+	idle := 0.0
+	for _, window := range windows {
+		for name, pod := range window.Pods {
+			if !pod.HasSplit() {
+				return nil, fmt.Errorf("The cost of the pod %s was stored at %s without its CPU and memory split, backfill the month again to generate its chargeback report", name, window.Start)
+			}
+
+			costs.Prices[name] += pod.Price
+			costs.UsageCPU[name] += pod.CPU
+			costs.UsageMemory[name] += pod.Memory
+			costs.Wasted[name] += pod.Waste
+			costs.IdleShares[name] += pod.Idle
+			costs.Namespaces[name] = pod.Namespace
+			podLabels[name] = pod.Labels
+		}
+
+		for _, nodeIdle := range window.NodeIdle {
+			idle += nodeIdle
+		}
+	}
+
+	costReport := newCostReport([]string{label}, currency, startTime, endTime)
+	addPodCosts(costReport, costs, podLabels, func(pod string) ([]string, bool) {
+		value := podLabels[pod][prometheus.SanitizeLabelName(label)]
+		if value == "" {
+			value = allocation.UNLABELLED
+		}
+		return []string{value}, true
+	})
+
+	for _, uncovered := range store.Uncovered(windows, startTime, endTime) {
+		if uncovered.Start.Before(now.Add(-timeLimits.Retention)) {
+			return nil, fmt.Errorf("The cost store has no costs between %s and %s, and Prometheus no longer keeps them", uncovered.Start, uncovered.End)
+		}
+
+		resolution := time.Hour
+		if uncovered.End.Sub(uncovered.Start) < resolution {
+			resolution = uncovered.End.Sub(uncovered.Start)
+		}
+
+		liveReport, err := getCostReport(source, uncovered.Start, uncovered.End, resolution, REPORT_LABEL, []string{label})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range liveReport.Rows {
+			if row.Group[0] == allocation.IDLE_WORKLOAD {
+				idle += row.Idle
+				continue
+			}
+			costReport.Add(row.Group, row.Cost)
+		}
+	}
+
+	costReport.Sort()
+
+	if idlePolicy == allocation.IdleSeparate {
+		costReport.Add(workloadGroup(costReport, allocation.IDLE_WORKLOAD), report.Cost{Idle: idle})
+	}
+
+	return costReport, nil
+}
+
+/*
+ * Returns the index of the generated chargeback reports, the newest month first
+ */
+func getChargebacks(c *gin.Context) {
+	if chargebackArchive == nil {
+		c.String(http.StatusNotFound, "Chargeback reports are not enabled, see -chargeback-label")
+		return
+	}
+
+	entries, err := chargebackArchive.Index()
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+/*
+ * Returns a generated chargeback report as csv, json or html
+ * in postman URL: http://localhost:8080/chargebacks/2021-12-team/html
+ */
+func getChargeback(c *gin.Context) {
+	if chargebackArchive == nil {
+		c.String(http.StatusNotFound, "Chargeback reports are not enabled, see -chargeback-label")
+		return
+	}
+
+	data, contentType, err := chargebackArchive.Get(c.Param("id"), c.Param("format"))
+	if err == chargeback.ErrNotFound {
+		c.String(http.StatusNotFound, "chargeback report not found")
+		return
+	}
+
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Data(http.StatusOK, contentType, data)
+}
@@ -0,0 +1,290 @@
+package consolidation
+
+import (
+	"math"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// The largest number of pods the kubelet runs on a node unless configured otherwise
+const KUBELET_MAX_PODS = 110
+
+// The pod limit of an instance type that cannot take any more pods, e.g. since the pods every node runs use up the limit
+const NO_PODS = -1
+
+// A pod to place, with the sum of the requests of its containers in cores and bytes
+type Pod struct {
+	Name         string
+	CPU          float64
+	Memory       float64
+	NodeSelector map[string]string
+	Tolerations  []v1.Toleration
+}
+
+// A kind of node pods can be placed on, with its allocatable capacity in cores and bytes and its price per hour
+type InstanceType struct {
+	Name   string  `json:"name"`
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+	// The largest number of pods on a node of the type, zero for KUBELET_MAX_PODS and NO_PODS if it takes no pods
+	MaxPods int               `json:"maxPods"`
+	Price   float64           `json:"price"`
+	Labels  map[string]string `json:"labels"`
+	Taints  []v1.Taint        `json:"taints"`
+}
+
+// A node of a plan and the pods placed on it, with the sum of their requests
+type Node struct {
+	InstanceType string   `json:"instanceType"`
+	Pods         []string `json:"pods"`
+	CPU          float64  `json:"cpu"`
+	Memory       float64  `json:"memory"`
+}
+
+// The nodes the pods are packed onto, and the pods that fit no instance type
+type Plan struct {
+	Nodes        []Node   `json:"nodes"`
+	PricePerHour float64  `json:"pricePerHour"`
+	Unscheduled  []string `json:"unscheduled"`
+}
+
+/*
+ * Returns whether a pod can run on a node of the type at all: the node selector of the pod matches the labels of the type,
+ * the pod tolerates the taints of the type and its requests fit an empty node
+ */
+func (t InstanceType) Accepts(pod Pod) bool {
+	for key, value := range pod.NodeSelector {
+		if t.Labels[key] != value {
+			return false
+		}
+	}
+
+	for i := range t.Taints {
+		taint := &t.Taints[i]
+		// PreferNoSchedule taints only keep pods away if there is room elsewhere
+		if taint.Effect == v1.TaintEffectPreferNoSchedule {
+			continue
+		}
+
+		if !tolerates(pod.Tolerations, taint) {
+			return false
+		}
+	}
+
+	return t.PodLimit() > 0 && pod.CPU <= t.CPU && pod.Memory <= t.Memory
+}
+
+// Returns the largest number of pods on a node of the type
+func (t InstanceType) PodLimit() int {
+	if t.MaxPods == 0 {
+		return KUBELET_MAX_PODS
+	}
+
+	if t.MaxPods < 0 {
+		return 0
+	}
+
+	return t.MaxPods
+}
+
+/*
+ * Returns the type with room for n pods less on every node, e.g. for the pods every node runs. A type left without room takes no pods
+ */
+func (t InstanceType) WithoutPods(n int) InstanceType {
+	t.MaxPods = t.PodLimit() - n
+	if t.MaxPods <= 0 {
+		t.MaxPods = NO_PODS
+	}
+
+	return t
+}
+
+func tolerates(tolerations []v1.Toleration, taint *v1.Taint) bool {
+	for i := range tolerations {
+		if tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A node being filled while packing
+type openNode struct {
+	instanceType InstanceType
+	pods         []Pod
+	cpu          float64
+	memory       float64
+}
+
+func (n *openNode) fits(pod Pod) bool {
+	if len(n.pods) >= n.instanceType.PodLimit() {
+		return false
+	}
+
+	return n.cpu+pod.CPU <= n.instanceType.CPU && n.memory+pod.Memory <= n.instanceType.Memory && n.instanceType.Accepts(pod)
+}
+
+// The share of the node capacity left after placing the pod, summed over CPU and memory
+func (n *openNode) slack(pod Pod) float64 {
+	return (n.instanceType.CPU-n.cpu-pod.CPU)/n.instanceType.CPU + (n.instanceType.Memory-n.memory-pod.Memory)/n.instanceType.Memory
+}
+
+/*
+ * Packs the pods onto nodes of the instance types and returns the cheapest plan found. Every instance type is tried as the type new nodes
+ * are opened with, pods the type does not accept open the cheapest type accepting them. Pods are placed largest first on the open node
+ * they fill the most, and finally every node is replaced by the cheapest type its pods fit on. Plans leaving fewer pods unscheduled are preferred.
+ * Without instance types every pod is unscheduled
+ */
+func Pack(pods []Pod, types []InstanceType) Plan {
+	sorted := sortPods(pods, types)
+
+	if len(types) == 0 {
+		plan := Plan{Nodes: []Node{}, Unscheduled: []string{}}
+		for _, pod := range sorted {
+			plan.Unscheduled = append(plan.Unscheduled, pod.Name)
+		}
+		return plan
+	}
+
+	var best Plan
+	for i, primary := range types {
+		plan := packWith(sorted, types, primary)
+
+		if i == 0 || len(plan.Unscheduled) < len(best.Unscheduled) ||
+			len(plan.Unscheduled) == len(best.Unscheduled) && plan.PricePerHour < best.PricePerHour {
+			best = plan
+		}
+	}
+
+	return best
+}
+
+/*
+ * Sorts the pods by their largest share of the largest instance type, largest first
+ */
+func sortPods(pods []Pod, types []InstanceType) []Pod {
+	maxCPU, maxMemory := 0.0, 0.0
+	for _, t := range types {
+		maxCPU = math.Max(maxCPU, t.CPU)
+		maxMemory = math.Max(maxMemory, t.Memory)
+	}
+
+	size := func(pod Pod) float64 {
+		share := 0.0
+		if maxCPU > 0 {
+			share = pod.CPU / maxCPU
+		}
+		if maxMemory > 0 {
+			share = math.Max(share, pod.Memory/maxMemory)
+		}
+		return share
+	}
+
+	sorted := append([]Pod{}, pods...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if size(sorted[i]) != size(sorted[j]) {
+			return size(sorted[i]) > size(sorted[j])
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	return sorted
+}
+
+func packWith(pods []Pod, types []InstanceType, primary InstanceType) Plan {
+	nodes := []*openNode{}
+	plan := Plan{Nodes: []Node{}, Unscheduled: []string{}}
+
+	for _, pod := range pods {
+		var chosen *openNode
+		for _, node := range nodes {
+			if node.fits(pod) && (chosen == nil || node.slack(pod) < chosen.slack(pod)) {
+				chosen = node
+			}
+		}
+
+		if chosen == nil {
+			instanceType, ok := primary, primary.Accepts(pod)
+			if !ok {
+				instanceType, ok = cheapestAccepting(types, []Pod{pod})
+			}
+
+			if !ok {
+				plan.Unscheduled = append(plan.Unscheduled, pod.Name)
+				continue
+			}
+
+			chosen = &openNode{instanceType: instanceType}
+			nodes = append(nodes, chosen)
+		}
+
+		chosen.pods = append(chosen.pods, pod)
+		chosen.cpu += pod.CPU
+		chosen.memory += pod.Memory
+	}
+
+	for _, node := range nodes {
+		if cheaper, ok := cheapestAccepting(types, node.pods); ok && cheaper.Price < node.instanceType.Price {
+			node.instanceType = cheaper
+		}
+
+		names := make([]string, len(node.pods))
+		for i, pod := range node.pods {
+			names[i] = pod.Name
+		}
+		sort.Strings(names)
+
+		plan.Nodes = append(plan.Nodes, Node{InstanceType: node.instanceType.Name, Pods: names, CPU: node.cpu, Memory: node.memory})
+		plan.PricePerHour += node.instanceType.Price
+	}
+
+	return plan
+}
+
+/*
+ * Returns the cheapest instance type a single node of which can run all the pods
+ */
+func cheapestAccepting(types []InstanceType, pods []Pod) (InstanceType, bool) {
+	found := false
+	cheapest := InstanceType{}
+
+	for _, t := range types {
+		if found && t.Price >= cheapest.Price {
+			continue
+		}
+
+		node := &openNode{instanceType: t}
+		fitsAll := true
+		for _, pod := range pods {
+			if !node.fits(pod) {
+				fitsAll = false
+				break
+			}
+
+			node.pods = append(node.pods, pod)
+			node.cpu += pod.CPU
+			node.memory += pod.Memory
+		}
+
+		if fitsAll {
+			cheapest = t
+			found = true
+		}
+	}
+
+	return cheapest, found
+}
+
+/*
+ * Returns the number of nodes of every instance type in the plan
+ */
+func (p Plan) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, node := range p.Nodes {
+		counts[node.InstanceType]++
+	}
+
+	return counts
+}
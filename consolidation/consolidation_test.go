@@ -0,0 +1,96 @@
+package consolidation
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+const GiB = 1024 * 1024 * 1024
+
+var small = InstanceType{Name: "small", CPU: 2, Memory: 8 * GiB, MaxPods: 30, Price: 1}
+var large = InstanceType{Name: "large", CPU: 8, Memory: 32 * GiB, MaxPods: 30, Price: 3}
+
+func TestPackCheapestMix(t *testing.T) {
+	pods := []Pod{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		pods = append(pods, Pod{Name: name, CPU: 1.2, Memory: 2 * GiB})
+	}
+
+	// Six pods fit on one large node for 3, but need six small nodes for 6
+	plan := Pack(pods, []InstanceType{small, large})
+	assert.Empty(t, plan.Unscheduled)
+	assert.Equal(t, map[string]int{"large": 1}, plan.Counts())
+	assert.Equal(t, 3.0, plan.PricePerHour)
+
+	// A single pod is moved to the cheaper small node
+	plan = Pack(pods[:1], []InstanceType{large, small})
+	assert.Equal(t, map[string]int{"small": 1}, plan.Counts())
+}
+
+func TestPackMaxPods(t *testing.T) {
+	limited := large
+	limited.MaxPods = 2
+	pods := []Pod{{Name: "a", CPU: 0.1}, {Name: "b", CPU: 0.1}, {Name: "c", CPU: 0.1}}
+
+	plan := Pack(pods, []InstanceType{limited})
+	assert.Equal(t, 2, len(plan.Nodes))
+	assert.Equal(t, []string{"a", "b"}, plan.Nodes[0].Pods)
+	assert.Equal(t, []string{"c"}, plan.Nodes[1].Pods)
+
+	// Without a limit a node takes as many pods as the kubelet allows
+	unlimited := large
+	unlimited.MaxPods = 0
+	many := []Pod{}
+	for i := 0; i < KUBELET_MAX_PODS+1; i++ {
+		many = append(many, Pod{Name: fmt.Sprintf("pod-%03d", i), CPU: 0.01})
+	}
+	plan = Pack(many, []InstanceType{unlimited})
+	assert.Equal(t, 2, len(plan.Nodes))
+	assert.Equal(t, KUBELET_MAX_PODS, len(plan.Nodes[0].Pods))
+
+	// A limit used up by the pods of every node rejects pods instead of turning into no limit
+	full := limited.WithoutPods(2)
+	assert.Equal(t, NO_PODS, full.MaxPods)
+	assert.False(t, full.Accepts(pods[0]))
+	plan = Pack(pods, []InstanceType{full})
+	assert.Empty(t, plan.Nodes)
+	assert.Equal(t, 3, len(plan.Unscheduled))
+
+	assert.Equal(t, 1, limited.WithoutPods(1).PodLimit())
+	assert.Equal(t, KUBELET_MAX_PODS-3, unlimited.WithoutPods(3).PodLimit())
+}
+
+func TestPackConstraints(t *testing.T) {
+	gpu := InstanceType{
+		Name: "gpu", CPU: 8, Memory: 32 * GiB, Price: 10,
+		Labels: map[string]string{"accelerator": "gpu"},
+		Taints: []v1.Taint{{Key: "gpu", Value: "true", Effect: v1.TaintEffectNoSchedule}},
+	}
+	types := []InstanceType{small, gpu}
+
+	training := Pod{
+		Name: "training", CPU: 1, Memory: GiB,
+		NodeSelector: map[string]string{"accelerator": "gpu"},
+		Tolerations:  []v1.Toleration{{Key: "gpu", Operator: v1.TolerationOpEqual, Value: "true", Effect: v1.TaintEffectNoSchedule}},
+	}
+	web := Pod{Name: "web", CPU: 1, Memory: GiB}
+	huge := Pod{Name: "huge", CPU: 16, Memory: GiB}
+
+	assert.True(t, gpu.Accepts(training))
+	assert.False(t, small.Accepts(training))
+	assert.False(t, gpu.Accepts(web))
+
+	plan := Pack([]Pod{training, web, huge}, types)
+	assert.Equal(t, map[string]int{"gpu": 1, "small": 1}, plan.Counts())
+	assert.Equal(t, []string{"huge"}, plan.Unscheduled)
+	assert.Equal(t, 11.0, plan.PricePerHour)
+
+	// Without any instance type every pod is unscheduled
+	plan = Pack([]Pod{web, {Name: "empty"}}, nil)
+	assert.Equal(t, []string{"empty", "web"}, plan.Unscheduled)
+	assert.Empty(t, plan.Nodes)
+	assert.Equal(t, 0.0, plan.PricePerHour)
+}
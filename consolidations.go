@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"dat067/costestimation/consolidation"
+	"dat067/costestimation/estimate"
+	"dat067/costestimation/kubernetes"
+	"dat067/costestimation/kubernetes/azure"
+	"dat067/costestimation/pricing"
+	"dat067/costestimation/rightsizing"
+
+	"github.com/gin-gonic/gin"
+	v1 "k8s.io/api/core/v1"
+)
+
+/*
+ * The instance types to consider besides the current ones. Candidates without a price are priced in the region of the cluster,
+ * candidates without maxPods take as many pods as the kubelet does by default, see consolidation.KUBELET_MAX_PODS
+ */
+type ConsolidationRequest struct {
+	Candidates []consolidation.InstanceType `json:"candidates"`
+}
+
+type ConsolidationResponse struct {
+	CurrentPricePerHour  float64        `json:"currentPricePerHour"`
+	CurrentNodes         map[string]int `json:"currentNodes"`
+	ProposedPricePerHour float64        `json:"proposedPricePerHour"`
+	ProposedNodes        map[string]int `json:"proposedNodes"`
+	// The requests of the DaemonSet pods every node runs, subtracted from the capacity of every instance type
+	NodeOverhead rightsizing.Resources `json:"nodeOverhead"`
+	// Zero if the plan is infeasible
+	MonthlySavings float64 `json:"monthlySavings"`
+	// Whether some pods fit none of the instance types, see the unscheduled pods of the plan. The proposed nodes do not run them
+	Infeasible bool               `json:"infeasible"`
+	Currency   string             `json:"currency"`
+	Plan       consolidation.Plan `json:"plan"`
+}
+
+/*
+ * Packs the pods of the cluster onto the instance types of the current nodes and the candidates of the request, and compares the cheapest node mix found with the current nodes
+ */
+func postConsolidation(c *gin.Context) {
+	request := ConsolidationRequest{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	region := ""
+	if len(pricedNodes) > 0 {
+		region = pricedNodes[0].Node.Labels[azure.LABEL_AZURE_REGION]
+	}
+
+	for i, candidate := range request.Candidates {
+		if candidate.Name == "" || candidate.CPU <= 0 || candidate.Memory <= 0 {
+			c.String(http.StatusBadRequest, "Every candidate needs a name, a CPU capacity and a memory capacity")
+			return
+		}
+
+		if candidate.Labels == nil {
+			request.Candidates[i].Labels = instanceTypeLabels(candidate.Name, region)
+		}
+
+		if candidate.Price == 0 {
+			price, err := azure.GetSkuPrice(candidate.Name, region, kubernetes.LABEL_OPERATING_SYSTEM_LINUX)
+			if err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			request.Candidates[i].Price = price
+		}
+	}
+
+	pods, err := kubernetes.GetPods(clientSet)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response, err := simulateConsolidation(pricedNodes, pods, request.Candidates)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+ * Packs the running pods onto the instance types of the nodes and the candidates. DaemonSet and static pods are not packed,
+ * since every node runs them, instead the most any node requests for them is reserved on every instance type
+ */
+func simulateConsolidation(nodes []kubernetes.PricedNode, pods []v1.Pod, candidates []consolidation.InstanceType) (ConsolidationResponse, error) {
+	currency, err := pricing.SEK.String()
+	if err != nil {
+		return ConsolidationResponse{}, err
+	}
+
+	response := ConsolidationResponse{CurrentNodes: make(map[string]int), Currency: currency}
+	for _, node := range nodes {
+		response.CurrentPricePerHour += node.Price
+		response.CurrentNodes[node.Node.Labels[azure.LABEL_AZURE_INSTANCE_TYPE]]++
+	}
+
+	nodeOverheads := make(map[string]rightsizing.Resources)
+	nodeOverheadPods := make(map[string]int)
+	packed := []consolidation.Pod{}
+
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+
+		cpu, memory := estimate.PodRequests(pod.Spec)
+
+		if isNodeDaemon(pod) {
+			overhead := nodeOverheads[pod.Spec.NodeName]
+			overhead.CPU += cpu
+			overhead.Memory += memory
+			nodeOverheads[pod.Spec.NodeName] = overhead
+			nodeOverheadPods[pod.Spec.NodeName]++
+			continue
+		}
+
+		packed = append(packed, consolidation.Pod{
+			Name:         pod.Namespace + "/" + pod.Name,
+			CPU:          cpu,
+			Memory:       memory,
+			NodeSelector: pod.Spec.NodeSelector,
+			Tolerations:  pod.Spec.Tolerations,
+		})
+	}
+
+	overheadPods := 0
+	for node, overhead := range nodeOverheads {
+		response.NodeOverhead.CPU = math.Max(response.NodeOverhead.CPU, overhead.CPU)
+		response.NodeOverhead.Memory = math.Max(response.NodeOverhead.Memory, overhead.Memory)
+		if nodeOverheadPods[node] > overheadPods {
+			overheadPods = nodeOverheadPods[node]
+		}
+	}
+
+	types := append(currentInstanceTypes(nodes), candidates...)
+	for i := range types {
+		types[i].CPU -= response.NodeOverhead.CPU
+		types[i].Memory -= response.NodeOverhead.Memory
+		types[i] = types[i].WithoutPods(overheadPods)
+	}
+
+	response.Plan = consolidation.Pack(packed, types)
+	response.ProposedPricePerHour = response.Plan.PricePerHour
+	response.ProposedNodes = response.Plan.Counts()
+	response.Infeasible = len(response.Plan.Unscheduled) > 0
+	if !response.Infeasible {
+		response.MonthlySavings = (response.CurrentPricePerHour - response.ProposedPricePerHour) * rightsizing.HOURS_PER_MONTH
+	}
+
+	return response, nil
+}
+
+/*
+ * Returns the instance types of the nodes, one per node pool, so that the labels pods select a pool by are kept. Nodes of the same pool
+ * with different taints are different instance types. The labels of an instance type are the labels all its nodes have in common,
+ * which leaves out the labels of the single nodes such as the hostname. Nodes outside of a pool are grouped by their instance type
+ */
+func currentInstanceTypes(nodes []kubernetes.PricedNode) []consolidation.InstanceType {
+	types := []consolidation.InstanceType{}
+	indices := make(map[string]int)
+
+	for _, node := range nodes {
+		name := node.Node.Labels[azure.LABEL_AZURE_INSTANCE_TYPE]
+		details := []string{}
+		if pool := nodePool(node.Node); pool != "" {
+			details = append(details, "pool "+pool)
+		}
+
+		taints := []string{}
+		for _, taint := range node.Node.Spec.Taints {
+			taints = append(taints, taint.ToString())
+		}
+		sort.Strings(taints)
+		details = append(details, taints...)
+
+		if len(details) > 0 {
+			name = fmt.Sprintf("%s (%s)", name, strings.Join(details, ", "))
+		}
+
+		index, ok := indices[name]
+		if !ok {
+			instanceType := nodeInstanceType(node)
+			instanceType.Name = name
+			indices[name] = len(types)
+			types = append(types, instanceType)
+			continue
+		}
+
+		for key, value := range types[index].Labels {
+			if node.Node.Labels[key] != value {
+				delete(types[index].Labels, key)
+			}
+		}
+	}
+
+	return types
+}
+
+// Returns the AKS node pool of the node, empty if it is in none
+func nodePool(node v1.Node) string {
+	if pool := node.Labels[azure.LABEL_AZURE_AGENT_POOL]; pool != "" {
+		return pool
+	}
+
+	return node.Labels[azure.LABEL_AZURE_AGENT_POOL_LEGACY]
+}
+
+/*
+ * Returns the allocatable capacity, price, labels and taints of the node as an instance type named after the instance type of the node
+ */
+func nodeInstanceType(node kubernetes.PricedNode) consolidation.InstanceType {
+	allocatable := node.Node.Status.Allocatable
+	labels := make(map[string]string)
+	for key, value := range node.Node.Labels {
+		labels[key] = value
+	}
+
+	return consolidation.InstanceType{
+		Name:    node.Node.Labels[azure.LABEL_AZURE_INSTANCE_TYPE],
+		CPU:     allocatable.Cpu().AsApproximateFloat64(),
+		Memory:  allocatable.Memory().AsApproximateFloat64(),
+		MaxPods: int(allocatable.Pods().Value()),
+		Price:   node.Price,
+		Labels:  labels,
+		Taints:  node.Node.Spec.Taints,
+	}
+}
+
+// Returns the labels a new Linux node of the instance type in the region gets
+func instanceTypeLabels(name string, region string) map[string]string {
+	return map[string]string{
+		azure.LABEL_AZURE_INSTANCE_TYPE:   name,
+		azure.LABEL_AZURE_REGION:          region,
+		kubernetes.LABEL_OPERATING_SYSTEM: strings.ToLower(kubernetes.LABEL_OPERATING_SYSTEM_LINUX),
+	}
+}
+
+// Returns whether the pod runs on its node because of the node itself, as a DaemonSet pod or a static pod
+func isNodeDaemon(pod v1.Pod) bool {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" || owner.Kind == "Node" {
+			return true
+		}
+	}
+
+	return false
+}
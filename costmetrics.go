@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"dat067/costestimation/allocation"
+	"dat067/costestimation/exporter"
+	"dat067/costestimation/prometheus"
+)
+
+var costExporter = exporter.New()
+
+/*
+ * Recomputes the exported cost metrics from the usage of the last interval, every interval, forever
+ */
+func runCostExporter(source prometheus.MetricsSource, interval time.Duration) {
+	for {
+		endTime := time.Now()
+		snapshot, err := getCostSnapshot(source, endTime.Add(-interval), endTime)
+		if err != nil {
+			fmt.Printf("Could not compute the cost metrics: '%v'\n", err)
+		} else {
+			costExporter.Update(snapshot)
+		}
+
+		time.Sleep(time.Until(endTime.Add(interval)))
+	}
+}
+
+/*
+ * Calculates the hourly cost of every pod and the hourly idle cost of every node from their usage between startTime and endTime
+ */
+func getCostSnapshot(source prometheus.MetricsSource, startTime time.Time, endTime time.Time) (exporter.Snapshot, error) {
+	duration := endTime.Sub(startTime)
+	nodeSamples, err := getCostSamples(source, startTime, endTime, duration)
+	if err != nil {
+		return exporter.Snapshot{}, err
+	}
+
+	owners, warnings, err := source.GetPodOwners(endTime, duration)
+	if warnings != nil {
+		fmt.Println(warnings)
+	}
+
+	if err != nil {
+		fmt.Printf("Could not get the pod owners: '%v'\n", err)
+	}
+
+	snapshot := exporter.Snapshot{Time: endTime}
+	hours := duration.Hours()
+
+	for i, samples := range nodeSamples {
+		// A pod may have several samples if the node capacity changed
+		podIndex := make(map[string]int)
+		charged := 0.0
+
+		for j, podsResourceUsage := range samples.Usages {
+			for pod, price := range samples.Prices[j] {
+				wastedCost := samples.WastedCosts[j][pod]
+				if idlePolicy != allocation.IdleNode {
+					price -= wastedCost
+				}
+				charged += price
+
+				k, ok := podIndex[pod]
+				if !ok {
+					k = len(snapshot.Pods)
+					podIndex[pod] = k
+					snapshot.Pods = append(snapshot.Pods, exporter.PodCost{
+						Namespace: podsResourceUsage.ResourceUsages[pod].Namespace,
+						Pod:       pod,
+						OwnerKind: owners[pod].Kind,
+						Owner:     owners[pod].Name,
+						Node:      samples.Node,
+					})
+				}
+
+				snapshot.Pods[k].CostPerHour += price / hours
+				snapshot.Pods[k].WastePerHour += wastedCost / hours
+			}
+		}
+
+		node := pricedNodes[i]
+		snapshot.Nodes = append(snapshot.Nodes, exporter.NodeCost{
+			Node:         node.Node.Name,
+			PricePerHour: node.Price,
+			IdlePerHour:  allocation.NodeIdleCost(node.Price, hours, []float64{charged}) / hours,
+		})
+	}
+
+	sort.Slice(snapshot.Pods, func(i, j int) bool {
+		if snapshot.Pods[i].Node != snapshot.Pods[j].Node {
+			return snapshot.Pods[i].Node < snapshot.Pods[j].Node
+		}
+		return snapshot.Pods[i].Pod < snapshot.Pods[j].Pod
+	})
+
+	return snapshot, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"dat067/costestimation/allocation"
+	"dat067/costestimation/prometheus"
+	"dat067/costestimation/store"
+)
+
+var costStore *store.Store
+var storeGranularity time.Duration
+
+// How long the cost scheduler waits after a failed window before it retries. The wait doubles with every failure in a row, up to the granularity
+const STORE_RETRY_INTERVAL = time.Minute
+
+/*
+ * Computes the cost of every completed window of storeGranularity and stores it, forever.
+ * Starts after the last stored window, or with the last completed window if the store is empty
+ */
+func runCostScheduler(source prometheus.MetricsSource) {
+	next, found, err := costStore.LastWindowEnd()
+	if err != nil {
+		fmt.Printf("Could not read the cost store: '%v'\n", err)
+		return
+	}
+
+	if !found {
+		next = time.Now().Truncate(storeGranularity).Add(-storeGranularity)
+	}
+
+	retry := STORE_RETRY_INTERVAL
+	for {
+		next, err = storeDueWindows(source, next, time.Now())
+		if err != nil {
+			fmt.Printf("Could not compute the costs between %s and %s, retrying in %s: '%v'\n", next, next.Add(storeGranularity), retry, err)
+			time.Sleep(retry)
+			retry *= 2
+			if retry > storeGranularity {
+				retry = storeGranularity
+			}
+			continue
+		}
+
+		retry = STORE_RETRY_INTERVAL
+		time.Sleep(time.Until(next.Add(storeGranularity)))
+	}
+}
+
+/*
+ * Stores the windows starting at next that have completed before now, and returns the start of the first window that is not stored.
+ * Windows that start before the retention of Prometheus are skipped, since their usage is gone. Stops at the first window that fails
+ */
+func storeDueWindows(source prometheus.MetricsSource, next time.Time, now time.Time) (time.Time, error) {
+	if timeLimits.Retention > 0 {
+		oldest := now.Add(-timeLimits.Retention)
+		if next.Before(oldest) {
+			skipped := (oldest.Sub(next) + storeGranularity - 1) / storeGranularity
+			fmt.Printf("Skipping %d windows from %s, they are older than the retention of %s\n", skipped, next, timeLimits.Retention)
+			next = next.Add(skipped * storeGranularity)
+		}
+	}
+
+	for !next.Add(storeGranularity).After(now) {
+		err := storeWindow(source, next, next.Add(storeGranularity))
+		if err != nil {
+			return next, err
+		}
+
+		next = next.Add(storeGranularity)
+	}
+
+	return next, nil
+}
+
+/*
+ * Computes the costs between startTime and endTime and puts them in the cost store as one window
+ */
+func storeWindow(source prometheus.MetricsSource, startTime time.Time, endTime time.Time) error {
+	windows, err := computeWindows(source, startTime, endTime, endTime.Sub(startTime))
+	if err != nil {
+		return err
+	}
+
+	for _, window := range windows {
+		err = costStore.PutWindow(window)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Answers a deployment price query from the stored windows, and computes the parts of the period that are not stored yet live.
+ * The stored windows keep the idle policy that was used when they were computed
+ */
+func getStoredDeploymentPrice(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, resolution time.Duration) (map[string]float64, error) {
+	windows, err := costStore.GetWindows(startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	priceMap := make(map[string]float64)
+	for _, window := range windows {
+		for deployment, price := range window.DeploymentPrices() {
+			priceMap[deployment] += price
+		}
+
+		if idlePolicy == allocation.IdleSeparate {
+			for _, idle := range window.NodeIdle {
+				priceMap[allocation.IDLE_WORKLOAD] += idle
+			}
+		}
+	}
+
+	for _, uncovered := range store.Uncovered(windows, startTime, endTime) {
+		liveResolution := resolution
+		if uncovered.End.Sub(uncovered.Start) < liveResolution {
+			liveResolution = uncovered.End.Sub(uncovered.Start)
+		}
+
+		livePrices, err := getLiveDeploymentPrice(source, uncovered.Start, uncovered.End, liveResolution)
+		if err != nil {
+			return nil, err
+		}
+
+		for deployment, price := range livePrices {
+			priceMap[deployment] += price
+		}
+	}
+
+	return priceMap, nil
+}
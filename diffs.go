@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"dat067/costestimation/consolidation"
+	"dat067/costestimation/estimate"
+	"dat067/costestimation/pricing"
+)
+
+type DiffResponse struct {
+	// The instance type or price sheet the workloads are estimated on, or "cluster" for the current nodes
+	Nodes    string `json:"nodes"`
+	Currency string `json:"currency"`
+	estimate.CostDiff
+}
+
+/*
+ * Runs the diff subcommand, comparing the estimated monthly cost of the manifests of two directories, e.g. of the base and the head
+ * of a pull request in CI:
+ * costestimation diff -before base/deploy -after head/deploy -prices prices.yaml -o markdown
+ */
+func runDiffCommand(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	before := flags.String("before", "", "The directory with the manifests before the change")
+	after := flags.String("after", "", "The directory with the manifests after the change")
+	output := flags.String("o", "table", "The output format, 'table', 'markdown' or 'json'")
+	nodeFlags := addNodeFlags(flags)
+	flags.Parse(args)
+
+	if *before == "" || *after == "" {
+		return fmt.Errorf("Both the directory before and after the change are needed, see -before and -after")
+	}
+
+	instanceTypes, name, err := nodeFlags.instanceTypes()
+	if err != nil {
+		return err
+	}
+
+	response, err := getCostDiff(*before, *after, instanceTypes, name)
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case "json":
+		return writeJSON(os.Stdout, response)
+	case "markdown":
+		return writeDiffMarkdown(os.Stdout, response)
+	case "table":
+		return writeDiffTable(os.Stdout, response)
+	default:
+		return fmt.Errorf("Unknown output format '%s'", *output)
+	}
+}
+
+/*
+ * Estimates the Deployments and StatefulSets of both directories on the nodes and compares them
+ */
+func getCostDiff(beforeDirectory string, afterDirectory string, nodes []consolidation.InstanceType, name string) (DiffResponse, error) {
+	currency, err := pricing.SEK.String()
+	if err != nil {
+		return DiffResponse{}, err
+	}
+
+	beforeWorkloads, err := estimate.ParseDirectory(beforeDirectory)
+	if err != nil {
+		return DiffResponse{}, err
+	}
+
+	afterWorkloads, err := estimate.ParseDirectory(afterDirectory)
+	if err != nil {
+		return DiffResponse{}, err
+	}
+
+	beforeEstimates, _ := estimate.EstimateWorkloads(costModel, beforeWorkloads, nodes)
+	afterEstimates, _ := estimate.EstimateWorkloads(costModel, afterWorkloads, nodes)
+
+	return DiffResponse{
+		Nodes:    name,
+		Currency: currency,
+		CostDiff: estimate.Diff(beforeEstimates, afterEstimates),
+	}, nil
+}
+
+/*
+ * Writes every workload with its replicas, requests and monthly cost before and after the change as an aligned table, followed by the totals
+ */
+func writeDiffTable(w io.Writer, response DiffResponse) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "KIND\tNAMESPACE\tNAME\tSTATUS\tREPLICAS\tCPU\tMEMORY (MiB)\tBEFORE (%s)\tAFTER (%s)\tCHANGE\n", response.Currency, response.Currency)
+
+	for _, workload := range response.Workloads {
+		replicas, cpu, memory, before, after := diffColumns(workload)
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%+.2f\n",
+			workload.Kind, workload.Namespace, workload.Name, workload.Status, replicas, cpu, memory, before, after, workload.Delta)
+	}
+
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Total on %s: %.2f -> %.2f %s per month (%+.2f)\n", response.Nodes, response.Before, response.After, response.Currency, response.Delta)
+	writeDiffNotes(w, response, "")
+	return nil
+}
+
+/*
+ * Writes a Markdown summary for a pull request comment. Unchanged workloads are only counted
+ */
+func writeDiffMarkdown(w io.Writer, response DiffResponse) error {
+	fmt.Fprintf(w, "### Estimated cost change\n\n")
+	fmt.Fprintf(w, "The monthly cost changes by **%+.2f %s**, from %.2f to %.2f %s%s.\n\n",
+		response.Delta, response.Currency, response.Before, response.After, response.Currency, percentChange(response.Before, response.After))
+
+	unchanged := 0
+	rows := 0
+	for _, workload := range response.Workloads {
+		if workload.Status == estimate.STATUS_UNCHANGED {
+			unchanged++
+			continue
+		}
+
+		if rows == 0 {
+			fmt.Fprintf(w, "| Workload | Status | Replicas | CPU | Memory (MiB) | Before (%s) | After (%s) | Change |\n", response.Currency, response.Currency)
+			fmt.Fprintf(w, "|---|---|---:|---:|---:|---:|---:|---:|\n")
+		}
+
+		replicas, cpu, memory, before, after := diffColumns(workload)
+		fmt.Fprintf(w, "| %s `%s/%s` | %s | %s | %s | %s | %s | %s | %+.2f |\n",
+			workload.Kind, workload.Namespace, workload.Name, workload.Status, replicas, cpu, memory, before, after, workload.Delta)
+		rows++
+	}
+
+	if rows == 0 {
+		fmt.Fprintf(w, "No Deployment or StatefulSet changes.\n")
+	}
+
+	if unchanged > 0 {
+		fmt.Fprintf(w, "\n%d unchanged workloads are not shown.\n", unchanged)
+	}
+
+	writeDiffNotes(w, response, "> ")
+	fmt.Fprintf(w, "\n<sub>Estimated on %s.</sub>\n", response.Nodes)
+	return nil
+}
+
+// Returns the change in percent of the total cost in parentheses, or nothing if there was no cost before
+func percentChange(before float64, after float64) string {
+	if before == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" (%+.1f%%)", 100*(after-before)/before)
+}
+
+/*
+ * Returns the replicas, CPU, memory and cost columns of the workload as "before -> after", or as a single value if it did not change
+ */
+func diffColumns(workload estimate.WorkloadDiff) (string, string, string, string, string) {
+	replicas := func(e *estimate.Estimate) string { return fmt.Sprintf("%d", e.Replicas) }
+	cpu := func(e *estimate.Estimate) string { return fmt.Sprintf("%.3f", e.CPU) }
+	memory := func(e *estimate.Estimate) string { return fmt.Sprintf("%.0f", e.Memory/(1024*1024)) }
+	cost := func(e *estimate.Estimate) string {
+		if e == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f", e.MonthlyCost)
+	}
+
+	column := func(value func(e *estimate.Estimate) string) string {
+		switch {
+		case workload.Before == nil:
+			return value(workload.After)
+		case workload.After == nil:
+			return value(workload.Before)
+		case value(workload.Before) == value(workload.After):
+			return value(workload.After)
+		default:
+			return value(workload.Before) + " -> " + value(workload.After)
+		}
+	}
+
+	return column(replicas), column(cpu), column(memory), cost(workload.Before), cost(workload.After)
+}
+
+// Writes the notes of the estimates after the change, or before it for removed workloads, every line starting with the prefix
+func writeDiffNotes(w io.Writer, response DiffResponse, prefix string) {
+	first := true
+	for _, workload := range response.Workloads {
+		e := workload.After
+		if e == nil {
+			e = workload.Before
+		}
+
+		for _, note := range e.Notes {
+			if first {
+				fmt.Fprintln(w)
+				first = false
+			}
+			fmt.Fprintf(w, "%s%s/%s: %s\n", prefix, workload.Namespace, workload.Name, note)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package estimate
+
+import (
+	"math"
+	"sort"
+)
+
+const STATUS_ADDED = "added"
+const STATUS_REMOVED = "removed"
+const STATUS_CHANGED = "changed"
+const STATUS_UNCHANGED = "unchanged"
+
+// The estimates of a workload before and after a change. Before is nil for added workloads and After is nil for removed ones
+type WorkloadDiff struct {
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Before    *Estimate `json:"before,omitempty"`
+	After     *Estimate `json:"after,omitempty"`
+	// The monthly cost after the change minus the monthly cost before it
+	Delta float64 `json:"delta"`
+}
+
+// The workload changes and the total monthly costs before and after them
+type CostDiff struct {
+	Workloads []WorkloadDiff `json:"workloads"`
+	Before    float64        `json:"before"`
+	After     float64        `json:"after"`
+	Delta     float64        `json:"delta"`
+}
+
+type workloadKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+func keyOf(workload Workload) workloadKey {
+	return workloadKey{kind: workload.Kind, namespace: workload.Namespace, name: workload.Name}
+}
+
+/*
+ * Matches the estimates before and after a change by kind, namespace and name. Workloads are sorted by the size of their cost change, largest first
+ */
+func Diff(before []Estimate, after []Estimate) CostDiff {
+	diffs := make(map[workloadKey]*WorkloadDiff)
+	keys := []workloadKey{}
+	diff := CostDiff{Workloads: []WorkloadDiff{}}
+
+	get := func(workload Workload) *WorkloadDiff {
+		key := keyOf(workload)
+		if _, ok := diffs[key]; !ok {
+			diffs[key] = &WorkloadDiff{Kind: workload.Kind, Namespace: workload.Namespace, Name: workload.Name}
+			keys = append(keys, key)
+		}
+		return diffs[key]
+	}
+
+	for i := range before {
+		get(before[i].Workload).Before = &before[i]
+		diff.Before += before[i].MonthlyCost
+	}
+
+	for i := range after {
+		get(after[i].Workload).After = &after[i]
+		diff.After += after[i].MonthlyCost
+	}
+
+	for _, key := range keys {
+		workload := diffs[key]
+		switch {
+		case workload.Before == nil:
+			workload.Status = STATUS_ADDED
+			workload.Delta = workload.After.MonthlyCost
+		case workload.After == nil:
+			workload.Status = STATUS_REMOVED
+			workload.Delta = -workload.Before.MonthlyCost
+		default:
+			workload.Delta = workload.After.MonthlyCost - workload.Before.MonthlyCost
+			workload.Status = STATUS_UNCHANGED
+			if workload.Before.Replicas != workload.After.Replicas || workload.Before.CPU != workload.After.CPU ||
+				workload.Before.Memory != workload.After.Memory || workload.Delta != 0 {
+				workload.Status = STATUS_CHANGED
+			}
+		}
+
+		diff.Workloads = append(diff.Workloads, *workload)
+	}
+
+	sort.SliceStable(diff.Workloads, func(i, j int) bool {
+		return math.Abs(diff.Workloads[i].Delta) > math.Abs(diff.Workloads[j].Delta)
+	})
+
+	diff.Delta = diff.After - diff.Before
+	return diff
+}
@@ -0,0 +1,248 @@
+package estimate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dat067/costestimation/consolidation"
+	"dat067/costestimation/models"
+	"dat067/costestimation/rightsizing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const KIND_DEPLOYMENT = "Deployment"
+const KIND_STATEFULSET = "StatefulSet"
+
+// A Deployment or StatefulSet, with the requests of a single replica in cores and bytes
+type Workload struct {
+	Kind         string            `json:"kind"`
+	Namespace    string            `json:"namespace"`
+	Name         string            `json:"name"`
+	Replicas     int               `json:"replicas"`
+	CPU          float64           `json:"cpu"`
+	Memory       float64           `json:"memory"`
+	NodeSelector map[string]string `json:"-"`
+	Tolerations  []v1.Toleration   `json:"-"`
+}
+
+// The estimated cost of a workload per month
+type Estimate struct {
+	Workload
+	// The average cost of a replica on the nodes that can run it
+	PerReplica  float64  `json:"perReplica"`
+	MonthlyCost float64  `json:"monthlyCost"`
+	Notes       []string `json:"notes,omitempty"`
+}
+
+/*
+ * Reads the Deployments and StatefulSets of a YAML or JSON stream of Kubernetes manifests, separated by "---". Other kinds are skipped
+ */
+func ParseManifests(r io.Reader) ([]Workload, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	workloads := []Workload{}
+
+	for {
+		raw := json.RawMessage{}
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			return workloads, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse the manifests: %v", err)
+		}
+
+		// Empty documents, e.g. after a trailing "---"
+		if len(bytes.TrimSpace(raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			continue
+		}
+
+		typeMeta := metav1.TypeMeta{}
+		if err := json.Unmarshal(raw, &typeMeta); err != nil {
+			return nil, fmt.Errorf("Could not parse the manifests: %v", err)
+		}
+
+		var workload Workload
+		switch typeMeta.Kind {
+		case KIND_DEPLOYMENT:
+			deployment := appsv1.Deployment{}
+			if err := json.Unmarshal(raw, &deployment); err != nil {
+				return nil, fmt.Errorf("Could not parse the Deployment: %v", err)
+			}
+			workload = newWorkload(typeMeta.Kind, deployment.ObjectMeta, deployment.Spec.Replicas, deployment.Spec.Template.Spec)
+		case KIND_STATEFULSET:
+			statefulSet := appsv1.StatefulSet{}
+			if err := json.Unmarshal(raw, &statefulSet); err != nil {
+				return nil, fmt.Errorf("Could not parse the StatefulSet: %v", err)
+			}
+			workload = newWorkload(typeMeta.Kind, statefulSet.ObjectMeta, statefulSet.Spec.Replicas, statefulSet.Spec.Template.Spec)
+		default:
+			continue
+		}
+
+		workloads = append(workloads, workload)
+	}
+}
+
+func newWorkload(kind string, meta metav1.ObjectMeta, replicas *int32, spec v1.PodSpec) Workload {
+	workload := Workload{
+		Kind:         kind,
+		Namespace:    meta.Namespace,
+		Name:         meta.Name,
+		Replicas:     1,
+		NodeSelector: spec.NodeSelector,
+		Tolerations:  spec.Tolerations,
+	}
+
+	if workload.Namespace == "" {
+		workload.Namespace = metav1.NamespaceDefault
+	}
+
+	// Kubernetes defaults to one replica
+	if replicas != nil {
+		workload.Replicas = int(*replicas)
+	}
+
+	workload.CPU, workload.Memory = PodRequests(spec)
+	return workload
+}
+
+/*
+ * Returns the CPU requests in cores and memory requests in bytes of a pod. Init containers run one at a time before the other containers,
+ * so a pod requests the sum of its containers or the largest init container, whichever is larger
+ */
+func PodRequests(spec v1.PodSpec) (float64, float64) {
+	cpu, memory := 0.0, 0.0
+	for _, container := range spec.Containers {
+		cpu += containerRequest(container, v1.ResourceCPU)
+		memory += containerRequest(container, v1.ResourceMemory)
+	}
+
+	for _, container := range spec.InitContainers {
+		cpu = math.Max(cpu, containerRequest(container, v1.ResourceCPU))
+		memory = math.Max(memory, containerRequest(container, v1.ResourceMemory))
+	}
+
+	return cpu, memory
+}
+
+/*
+ * Returns the request of the container for the resource. Like the API server does when it admits the pod, a resource with a limit
+ * but no request requests the limit
+ */
+func containerRequest(container v1.Container, resource v1.ResourceName) float64 {
+	if request, ok := container.Resources.Requests[resource]; ok {
+		return request.AsApproximateFloat64()
+	}
+
+	limit := container.Resources.Limits[resource]
+	return limit.AsApproximateFloat64()
+}
+
+/*
+ * Estimates the monthly cost of the workload on the nodes, e.g. the nodes of a cluster or a single node of a chosen instance type.
+ * A replica costs the average of what the cost model charges for its requests on every node that can run it
+ */
+func EstimateWorkload(model models.ICostCalculator, workload Workload, nodes []consolidation.InstanceType) Estimate {
+	estimate := Estimate{Workload: workload}
+	pod := consolidation.Pod{
+		Name:         workload.Name,
+		CPU:          workload.CPU,
+		Memory:       workload.Memory,
+		NodeSelector: workload.NodeSelector,
+		Tolerations:  workload.Tolerations,
+	}
+
+	total := 0.0
+	accepting := 0
+	for _, node := range nodes {
+		if !node.Accepts(pod) {
+			continue
+		}
+
+		total += rightsizing.MonthlyCost(model, rightsizing.Node{CPU: node.CPU, Memory: node.Memory, Price: node.Price}, rightsizing.Resources{CPU: workload.CPU, Memory: workload.Memory})
+		accepting++
+	}
+
+	if workload.CPU == 0 && workload.Memory == 0 {
+		estimate.Notes = append(estimate.Notes, "The containers request no resources, so the cost model charges nothing for them")
+	}
+
+	if accepting == 0 {
+		estimate.Notes = append(estimate.Notes, "No node can run a replica, because of its node selector, the node taints or the size of its requests")
+		return estimate
+	}
+
+	estimate.PerReplica = total / float64(accepting)
+	estimate.MonthlyCost = estimate.PerReplica * float64(workload.Replicas)
+	return estimate
+}
+
+/*
+ * Estimates every workload on the nodes and returns the estimates and their total monthly cost
+ */
+func EstimateWorkloads(model models.ICostCalculator, workloads []Workload, nodes []consolidation.InstanceType) ([]Estimate, float64) {
+	estimates := make([]Estimate, len(workloads))
+	total := 0.0
+	for i, workload := range workloads {
+		estimates[i] = EstimateWorkload(model, workload, nodes)
+		total += estimates[i].MonthlyCost
+	}
+
+	return estimates, total
+}
+
+/*
+ * Reads the Deployments and StatefulSets of the .yaml, .yml and .json files in the directory and its subdirectories, in the order of their paths
+ */
+func ParseDirectory(directory string) ([]Workload, error) {
+	paths := []string{}
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+			if !info.IsDir() {
+				paths = append(paths, path)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+	workloads := []Workload{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		fileWorkloads, err := ParseManifests(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		workloads = append(workloads, fileWorkloads...)
+	}
+
+	return workloads, nil
+}
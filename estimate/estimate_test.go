@@ -0,0 +1,137 @@
+package estimate
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"dat067/costestimation/consolidation"
+	"dat067/costestimation/models"
+	"dat067/costestimation/rightsizing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const epsilon = 1e-9
+
+const GiB = 1024 * 1024 * 1024
+
+func TestParseManifests(t *testing.T) {
+	file, err := os.Open("testdata/shop.yaml")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	workloads, err := ParseManifests(file)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(workloads))
+
+	// The init container requests more CPU than the containers together
+	web := workloads[0]
+	assert.Equal(t, KIND_DEPLOYMENT, web.Kind)
+	assert.Equal(t, "shop", web.Namespace)
+	assert.Equal(t, 3, web.Replicas)
+	assert.InDelta(t, 1.0, web.CPU, epsilon)
+	assert.InDelta(t, 1152*1024*1024, web.Memory, epsilon)
+
+	db := workloads[1]
+	assert.Equal(t, KIND_STATEFULSET, db.Kind)
+	assert.Equal(t, "default", db.Namespace)
+	assert.Equal(t, 1, db.Replicas)
+	assert.Equal(t, map[string]string{"disk": "ssd"}, db.NodeSelector)
+
+	_, err = ParseManifests(os.Stdin)
+	assert.Nil(t, err)
+}
+
+func TestLimitsOnlyContainers(t *testing.T) {
+	manifest := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  template:
+    spec:
+      containers:
+      - name: worker
+        resources:
+          limits:
+            cpu: 500m
+            memory: 1Gi
+      - name: sidecar
+        resources:
+          requests:
+            cpu: 100m
+          limits:
+            cpu: 200m
+            memory: 256Mi
+`
+	workloads, err := ParseManifests(strings.NewReader(manifest))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(workloads))
+
+	// A resource without a request requests its limit
+	assert.InDelta(t, 0.6, workloads[0].CPU, epsilon)
+	assert.InDelta(t, 1280*1024*1024, workloads[0].Memory, epsilon)
+}
+
+func TestEstimateWorkloads(t *testing.T) {
+	model := models.GoodModel{Balance: []float64{1, 1}}
+	nodes := []consolidation.InstanceType{
+		{Name: "hdd", CPU: 4, Memory: 16 * GiB, Price: 2},
+		{Name: "ssd", CPU: 8, Memory: 32 * GiB, Price: 8, Labels: map[string]string{"disk": "ssd"}},
+	}
+	workloads := []Workload{
+		{Name: "web", Replicas: 2, CPU: 1, Memory: 4 * GiB},
+		{Name: "db", Replicas: 1, CPU: 2, Memory: 8 * GiB, NodeSelector: map[string]string{"disk": "ssd"}},
+		{Name: "huge", Replicas: 1, CPU: 16},
+	}
+
+	estimates, total := EstimateWorkloads(model, workloads, nodes)
+
+	// A quarter of the hdd node and an eighth of the ssd node, averaged
+	assert.InDelta(t, (0.5+1)/2*rightsizing.HOURS_PER_MONTH, estimates[0].PerReplica, epsilon)
+	assert.InDelta(t, 2*estimates[0].PerReplica, estimates[0].MonthlyCost, epsilon)
+
+	// Only the ssd node can run the db
+	assert.InDelta(t, 2*rightsizing.HOURS_PER_MONTH, estimates[1].MonthlyCost, epsilon)
+
+	assert.Equal(t, 0.0, estimates[2].MonthlyCost)
+	assert.Equal(t, 1, len(estimates[2].Notes))
+	assert.InDelta(t, estimates[0].MonthlyCost+estimates[1].MonthlyCost, total, epsilon)
+}
+
+func TestDiff(t *testing.T) {
+	before := []Estimate{
+		{Workload: Workload{Kind: KIND_DEPLOYMENT, Namespace: "shop", Name: "web", Replicas: 2}, MonthlyCost: 20},
+		{Workload: Workload{Kind: KIND_DEPLOYMENT, Namespace: "shop", Name: "api", Replicas: 1}, MonthlyCost: 5},
+		{Workload: Workload{Kind: KIND_STATEFULSET, Namespace: "shop", Name: "db", Replicas: 1}, MonthlyCost: 50},
+	}
+	after := []Estimate{
+		{Workload: Workload{Kind: KIND_DEPLOYMENT, Namespace: "shop", Name: "web", Replicas: 3}, MonthlyCost: 30},
+		{Workload: Workload{Kind: KIND_DEPLOYMENT, Namespace: "shop", Name: "api", Replicas: 1}, MonthlyCost: 5},
+		// A Deployment and a StatefulSet of the same name are different workloads
+		{Workload: Workload{Kind: KIND_DEPLOYMENT, Namespace: "shop", Name: "db", Replicas: 1}, MonthlyCost: 1},
+	}
+
+	diff := Diff(before, after)
+	assert.Equal(t, 75.0, diff.Before)
+	assert.Equal(t, 36.0, diff.After)
+	assert.Equal(t, -39.0, diff.Delta)
+
+	statuses := []string{}
+	for _, workload := range diff.Workloads {
+		statuses = append(statuses, workload.Kind+" "+workload.Name+" "+workload.Status)
+	}
+	assert.Equal(t, []string{"StatefulSet db removed", "Deployment web changed", "Deployment db added", "Deployment api unchanged"}, statuses)
+	assert.Equal(t, 10.0, diff.Workloads[1].Delta)
+}
+
+func TestParseDirectory(t *testing.T) {
+	workloads, err := ParseDirectory("testdata")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(workloads))
+
+	_, err = ParseDirectory("testdata/missing")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,92 @@
+package estimate
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"dat067/costestimation/consolidation"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+/*
+ * The node mix of a cluster, for estimating manifests without access to the cluster. Nodes without a price are priced by the caller
+ */
+type PriceSheet struct {
+	// The region the nodes without a price are priced in
+	Region string           `json:"region"`
+	Nodes  []PriceSheetNode `json:"nodes"`
+}
+
+type PriceSheetNode struct {
+	InstanceType string `json:"instanceType"`
+	// The allocatable CPU and memory of a node as Kubernetes quantities, e.g. 3860m and 12Gi
+	CPU    string  `json:"cpu"`
+	Memory string  `json:"memory"`
+	Price  float64 `json:"price"`
+	// The number of nodes of the instance type, defaults to 1
+	Count  int               `json:"count"`
+	Labels map[string]string `json:"labels"`
+}
+
+/*
+ * Reads a YAML or JSON price sheet
+ */
+func LoadPriceSheet(path string) (PriceSheet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return PriceSheet{}, err
+	}
+
+	sheet := PriceSheet{}
+	if err := yaml.Unmarshal(data, &sheet); err != nil {
+		return PriceSheet{}, fmt.Errorf("Could not parse the price sheet %s: %v", path, err)
+	}
+
+	if len(sheet.Nodes) == 0 {
+		return PriceSheet{}, fmt.Errorf("The price sheet %s has no nodes", path)
+	}
+
+	return sheet, nil
+}
+
+/*
+ * Returns a node of the mix for every node of the sheet, so that an instance type with more nodes weighs more in the estimates
+ */
+func (s PriceSheet) InstanceTypes() ([]consolidation.InstanceType, error) {
+	types := []consolidation.InstanceType{}
+
+	for _, node := range s.Nodes {
+		if node.InstanceType == "" {
+			return nil, fmt.Errorf("Every node of the price sheet needs an instance type")
+		}
+
+		cpu, err := resource.ParseQuantity(node.CPU)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CPU capacity '%s' of %s: %v", node.CPU, node.InstanceType, err)
+		}
+
+		memory, err := resource.ParseQuantity(node.Memory)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid memory capacity '%s' of %s: %v", node.Memory, node.InstanceType, err)
+		}
+
+		count := node.Count
+		if count == 0 {
+			count = 1
+		}
+
+		for i := 0; i < count; i++ {
+			types = append(types, consolidation.InstanceType{
+				Name:   node.InstanceType,
+				CPU:    cpu.AsApproximateFloat64(),
+				Memory: memory.AsApproximateFloat64(),
+				Price:  node.Price,
+				Labels: node.Labels,
+			})
+		}
+	}
+
+	return types, nil
+}
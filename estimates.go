@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"dat067/costestimation/consolidation"
+	"dat067/costestimation/estimate"
+	"dat067/costestimation/kubernetes"
+	"dat067/costestimation/kubernetes/azure"
+	"dat067/costestimation/pricing"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+type EstimateResponse struct {
+	// The instance type the workloads are estimated on, or "cluster" for the current nodes
+	Nodes       string              `json:"nodes"`
+	Estimates   []estimate.Estimate `json:"estimates"`
+	MonthlyCost float64             `json:"monthlyCost"`
+	Currency    string              `json:"currency"`
+}
+
+/*
+ * Estimates the monthly cost of the Deployments and StatefulSets of the YAML manifests in the request body, on the current nodes or
+ * on nodes of the instance type of the sku query parameter. The capacity of the instance type is taken from the cpu and memory
+ * query parameters, e.g. 4 and 16Gi, or from a current node of the type. Its price is taken from the price query parameter,
+ * a current node or the Azure prices
+ */
+func postEstimate(c *gin.Context) {
+	workloads, err := estimate.ParseManifests(c.Request.Body)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	nodes, name, err := getEstimateNodes(pricedNodes, c.Query("sku"), c.Query("cpu"), c.Query("memory"), c.Query("price"), "")
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response, err := estimateWorkloads(workloads, nodes, name)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+ * Returns the nodes to estimate on and their description. Without a sku these are the current nodes, otherwise a single node of the sku.
+ * The region of the sku defaults to the region of the current nodes
+ */
+func getEstimateNodes(nodes []kubernetes.PricedNode, sku string, cpuStr string, memoryStr string, priceStr string, region string) ([]consolidation.InstanceType, string, error) {
+	if sku == "" {
+		if len(nodes) == 0 {
+			return nil, "", fmt.Errorf("There are no priced nodes to estimate on, choose an instance type")
+		}
+
+		types := make([]consolidation.InstanceType, len(nodes))
+		for i, node := range nodes {
+			types[i] = nodeInstanceType(node)
+		}
+
+		return types, "cluster", nil
+	}
+
+	instanceType := consolidation.InstanceType{Name: sku}
+	for _, node := range nodes {
+		if node.Node.Labels[azure.LABEL_AZURE_INSTANCE_TYPE] == sku {
+			instanceType = nodeInstanceType(node)
+			instanceType.Taints = nil
+			break
+		}
+
+		if region == "" {
+			region = node.Node.Labels[azure.LABEL_AZURE_REGION]
+		}
+	}
+
+	if instanceType.Labels == nil {
+		instanceType.Labels = instanceTypeLabels(sku, region)
+	}
+
+	if cpuStr != "" {
+		cpu, err := resource.ParseQuantity(cpuStr)
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid CPU capacity '%s': %v", cpuStr, err)
+		}
+		instanceType.CPU = cpu.AsApproximateFloat64()
+	}
+
+	if memoryStr != "" {
+		memory, err := resource.ParseQuantity(memoryStr)
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid memory capacity '%s': %v", memoryStr, err)
+		}
+		instanceType.Memory = memory.AsApproximateFloat64()
+	}
+
+	if instanceType.CPU <= 0 || instanceType.Memory <= 0 {
+		return nil, "", fmt.Errorf("The capacity of the instance type '%s' is unknown, set the CPU and memory capacity", sku)
+	}
+
+	if priceStr != "" {
+		price, err := strconv.ParseFloat(priceStr, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid price '%s': %v", priceStr, err)
+		}
+		instanceType.Price = price
+	}
+
+	if instanceType.Price == 0 {
+		if region == "" {
+			return nil, "", fmt.Errorf("The price of the instance type '%s' is unknown and there is no region to look it up in, set the region (-region) or the price", sku)
+		}
+
+		price, err := azure.GetSkuPrice(sku, region, kubernetes.LABEL_OPERATING_SYSTEM_LINUX)
+		if err != nil {
+			return nil, "", err
+		}
+		instanceType.Price = price
+	}
+
+	return []consolidation.InstanceType{instanceType}, sku, nil
+}
+
+/*
+ * Estimates the workloads with the cost model the pod costs are computed with
+ */
+func estimateWorkloads(workloads []estimate.Workload, nodes []consolidation.InstanceType, name string) (EstimateResponse, error) {
+	currency, err := pricing.SEK.String()
+	if err != nil {
+		return EstimateResponse{}, err
+	}
+
+	estimates, total := estimate.EstimateWorkloads(costModel, workloads, nodes)
+	return EstimateResponse{Nodes: name, Estimates: estimates, MonthlyCost: total, Currency: currency}, nil
+}
+
+/*
+ * Runs the estimate subcommand, estimating a manifest file without starting the server, e.g.
+ * costestimation estimate -f deployment.yaml -sku Standard_D4s_v3 -cpu 4 -memory 16Gi -region westeurope
+ */
+func runEstimateCommand(args []string) error {
+	flags := flag.NewFlagSet("estimate", flag.ExitOnError)
+	path := flags.String("f", "-", "The manifest file to estimate, '-' for standard input")
+	output := flags.String("o", "table", "The output format, 'table' or 'json'")
+	nodeFlags := addNodeFlags(flags)
+	flags.Parse(args)
+
+	input := os.Stdin
+	if *path != "-" {
+		file, err := os.Open(*path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	workloads, err := estimate.ParseManifests(input)
+	if err != nil {
+		return err
+	}
+
+	instanceTypes, name, err := nodeFlags.instanceTypes()
+	if err != nil {
+		return err
+	}
+
+	response, err := estimateWorkloads(workloads, instanceTypes, name)
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case "json":
+		return writeJSON(os.Stdout, response)
+	case "table":
+		return writeEstimateTable(os.Stdout, response)
+	default:
+		return fmt.Errorf("Unknown output format '%s'", *output)
+	}
+}
+
+// The flags of the subcommands choosing the nodes manifests are estimated on
+type nodeFlags struct {
+	prices     *string
+	priceCache *string
+	sku        *string
+	cpu        *string
+	memory     *string
+	price      *string
+	region     *string
+	kubeconfig *string
+}
+
+func addNodeFlags(flags *flag.FlagSet) *nodeFlags {
+	return &nodeFlags{
+		prices:     flags.String("prices", "", "A YAML or JSON price sheet with the node mix to estimate on, for estimating without access to the cluster"),
+		priceCache: flags.String("price-cache", "", "A file the Azure prices are read from and saved to, so that they are only queried once"),
+		sku:        flags.String("sku", "", "The instance type to estimate on. Estimates on the nodes of the cluster of the kubeconfig if neither a price sheet nor an instance type is set"),
+		cpu:        flags.String("cpu", "", "The allocatable CPU of the instance type, e.g. 3860m"),
+		memory:     flags.String("memory", "", "The allocatable memory of the instance type, e.g. 12Gi"),
+		price:      flags.String("price", "", "The price per hour of the instance type. Taken from the Azure prices if empty"),
+		region:     flags.String("region", "", "The Azure region the instance type is priced in"),
+		kubeconfig: flags.String("kubeconfig", "", "The kubeconfig of the cluster whose nodes are estimated on"),
+	}
+}
+
+/*
+ * Returns the nodes of the price sheet, the chosen instance type or the cluster, and their description. Azure prices are
+ * taken from the price cache if it has them, and the cache is saved afterwards
+ */
+func (f *nodeFlags) instanceTypes() ([]consolidation.InstanceType, string, error) {
+	if *f.priceCache != "" {
+		if err := azure.LoadPriceCache(*f.priceCache); err != nil {
+			return nil, "", err
+		}
+	}
+
+	instanceTypes, name, err := f.resolveInstanceTypes()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if *f.priceCache != "" {
+		if err := azure.SavePriceCache(*f.priceCache); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return instanceTypes, name, nil
+}
+
+func (f *nodeFlags) resolveInstanceTypes() ([]consolidation.InstanceType, string, error) {
+	if *f.prices != "" {
+		sheet, err := estimate.LoadPriceSheet(*f.prices)
+		if err != nil {
+			return nil, "", err
+		}
+
+		instanceTypes, err := priceSheetInstanceTypes(sheet)
+		return instanceTypes, *f.prices, err
+	}
+
+	nodes := []kubernetes.PricedNode{}
+	if *f.sku == "" {
+		clientSet, err := kubernetes.NewClientSet(*f.kubeconfig)
+		if err != nil {
+			return nil, "", err
+		}
+
+		nodes, err = azure.GetPricedAzureNodes(clientSet)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return getEstimateNodes(nodes, *f.sku, *f.cpu, *f.memory, *f.price, *f.region)
+}
+
+/*
+ * Returns the nodes of the price sheet. Nodes without a price are priced with the Azure prices of the region of the sheet,
+ * nodes without labels get the labels of a new node of their instance type
+ */
+func priceSheetInstanceTypes(sheet estimate.PriceSheet) ([]consolidation.InstanceType, error) {
+	instanceTypes, err := sheet.InstanceTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make(map[string]float64)
+	for i, instanceType := range instanceTypes {
+		if instanceType.Labels == nil {
+			instanceTypes[i].Labels = instanceTypeLabels(instanceType.Name, sheet.Region)
+		}
+
+		if instanceType.Price != 0 {
+			continue
+		}
+
+		if sheet.Region == "" {
+			return nil, fmt.Errorf("The node '%s' of the price sheet has no price and the sheet has no region to look it up in, set the region or the price", instanceType.Name)
+		}
+
+		price, ok := prices[instanceType.Name]
+		if !ok {
+			price, err = azure.GetSkuPrice(instanceType.Name, sheet.Region, kubernetes.LABEL_OPERATING_SYSTEM_LINUX)
+			if err != nil {
+				return nil, err
+			}
+			prices[instanceType.Name] = price
+		}
+
+		instanceTypes[i].Price = price
+	}
+
+	return instanceTypes, nil
+}
+
+// Writes the value as indented JSON
+func writeJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+/*
+ * Writes the estimates as an aligned table followed by the total and the notes
+ */
+func writeEstimateTable(w io.Writer, response EstimateResponse) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "KIND\tNAMESPACE\tNAME\tREPLICAS\tCPU\tMEMORY (MiB)\tPER REPLICA\tMONTHLY (%s)\n", response.Currency)
+
+	for _, e := range response.Estimates {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%.3f\t%.0f\t%.2f\t%.2f\n",
+			e.Kind, e.Namespace, e.Name, e.Replicas, e.CPU, e.Memory/(1024*1024), e.PerReplica, e.MonthlyCost)
+	}
+
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Total on %s: %.2f %s per month\n", response.Nodes, response.MonthlyCost, response.Currency)
+
+	for _, e := range response.Estimates {
+		for _, note := range e.Notes {
+			fmt.Fprintf(w, "%s/%s: %s\n", e.Namespace, e.Name, note)
+		}
+	}
+
+	return nil
+}
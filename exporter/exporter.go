@@ -0,0 +1,135 @@
+package exporter
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The hourly cost of a pod, computed from its average usage in the last refresh interval
+type PodCost struct {
+	Namespace    string
+	Pod          string
+	OwnerKind    string
+	Owner        string
+	Node         string
+	CostPerHour  float64
+	WastePerHour float64
+}
+
+// The hourly price of a node and how much of it was left idle in the last refresh interval
+type NodeCost struct {
+	Node         string
+	PricePerHour float64
+	IdlePerHour  float64
+}
+
+// The costs exported by a refresh
+type Snapshot struct {
+	Time  time.Time
+	Pods  []PodCost
+	Nodes []NodeCost
+}
+
+/*
+ * Exports the latest Snapshot as Prometheus gauges. The snapshot is replaced as a whole, so a scrape never sees the costs of two refreshes mixed
+ */
+type Exporter struct {
+	registry *prometheus.Registry
+	lock     sync.RWMutex
+	snapshot *Snapshot
+}
+
+var podLabels = []string{"namespace", "pod", "owner_kind", "owner", "node"}
+
+var (
+	podCostDesc = prometheus.NewDesc(
+		"costestimation_pod_cost_per_hour",
+		"Hourly cost of the pod, including its share of the unused node capacity unless idle cost is charged separately.",
+		podLabels, nil)
+	podWasteDesc = prometheus.NewDesc(
+		"costestimation_pod_waste_cost_per_hour",
+		"Part of the hourly pod cost charged for node capacity the pod does not use.",
+		podLabels, nil)
+	nodePriceDesc = prometheus.NewDesc(
+		"costestimation_node_price_per_hour",
+		"Hourly price of the node.",
+		[]string{"node"}, nil)
+	nodeIdleDesc = prometheus.NewDesc(
+		"costestimation_node_idle_cost_per_hour",
+		"Part of the hourly node price not charged to any pod.",
+		[]string{"node"}, nil)
+	lastRefreshDesc = prometheus.NewDesc(
+		"costestimation_last_refresh_timestamp_seconds",
+		"Unix time of the end of the period the exported costs were computed for.",
+		nil, nil)
+)
+
+/*
+ * Creates an Exporter with its own registry, which also holds the Go runtime and process metrics
+ */
+func New() *Exporter {
+	e := &Exporter{registry: prometheus.NewRegistry()}
+
+	e.registry.MustRegister(
+		e,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return e
+}
+
+// The registry the metrics are exported from, for registering additional metrics
+func (e *Exporter) Registry() *prometheus.Registry {
+	return e.registry
+}
+
+// Serves the metrics in the Prometheus exposition format
+func (e *Exporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{})
+}
+
+/*
+ * Replaces the exported costs with the snapshot. Pods and nodes missing from the snapshot are no longer exported
+ */
+func (e *Exporter) Update(snapshot Snapshot) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.snapshot = &snapshot
+}
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- podCostDesc
+	ch <- podWasteDesc
+	ch <- nodePriceDesc
+	ch <- nodeIdleDesc
+	ch <- lastRefreshDesc
+}
+
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.lock.RLock()
+	snapshot := e.snapshot
+	e.lock.RUnlock()
+
+	// Nothing is exported before the first refresh
+	if snapshot == nil {
+		return
+	}
+
+	for _, pod := range snapshot.Pods {
+		ch <- prometheus.MustNewConstMetric(podCostDesc, prometheus.GaugeValue, pod.CostPerHour, pod.Namespace, pod.Pod, pod.OwnerKind, pod.Owner, pod.Node)
+		ch <- prometheus.MustNewConstMetric(podWasteDesc, prometheus.GaugeValue, pod.WastePerHour, pod.Namespace, pod.Pod, pod.OwnerKind, pod.Owner, pod.Node)
+	}
+
+	for _, node := range snapshot.Nodes {
+		ch <- prometheus.MustNewConstMetric(nodePriceDesc, prometheus.GaugeValue, node.PricePerHour, node.Node)
+		ch <- prometheus.MustNewConstMetric(nodeIdleDesc, prometheus.GaugeValue, node.IdlePerHour, node.Node)
+	}
+
+	ch <- prometheus.MustNewConstMetric(lastRefreshDesc, prometheus.GaugeValue, float64(snapshot.Time.Unix()))
+}
@@ -0,0 +1,41 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(t *testing.T, e *Exporter) string {
+	recorder := httptest.NewRecorder()
+	e.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(recorder.Body)
+	assert.Nil(t, err)
+	return string(body)
+}
+
+func TestExporter(t *testing.T) {
+	e := New()
+	assert.False(t, strings.Contains(scrape(t, e), "costestimation_pod_cost_per_hour"))
+
+	e.Update(Snapshot{
+		Time:  time.Unix(1640304000, 0),
+		Pods:  []PodCost{{Namespace: "shop", Pod: "web-1", OwnerKind: "Deployment", Owner: "web", Node: "node-1", CostPerHour: 0.5, WastePerHour: 0.25}},
+		Nodes: []NodeCost{{Node: "node-1", PricePerHour: 2, IdlePerHour: 1.5}},
+	})
+
+	metrics := scrape(t, e)
+	assert.Contains(t, metrics, `costestimation_pod_cost_per_hour{namespace="shop",node="node-1",owner="web",owner_kind="Deployment",pod="web-1"} 0.5`)
+	assert.Contains(t, metrics, `costestimation_pod_waste_cost_per_hour{namespace="shop",node="node-1",owner="web",owner_kind="Deployment",pod="web-1"} 0.25`)
+	assert.Contains(t, metrics, `costestimation_node_price_per_hour{node="node-1"} 2`)
+	assert.Contains(t, metrics, `costestimation_node_idle_cost_per_hour{node="node-1"} 1.5`)
+	assert.Contains(t, metrics, `costestimation_last_refresh_timestamp_seconds 1.640304e+09`)
+
+	// Pods of a previous refresh disappear
+	e.Update(Snapshot{Time: time.Unix(1640307600, 0)})
+	assert.False(t, strings.Contains(scrape(t, e), "web-1"))
+}
@@ -0,0 +1,189 @@
+package forecast
+
+import (
+	"fmt"
+	"math"
+)
+
+// The z-score of the 95% confidence bands
+const Z_95 = 1.96
+
+// The largest number of alternations between fitting the trend and the seasonality
+const MAX_ITERATIONS = 1000
+
+/*
+ * A linear trend with an additive seasonal component, fitted to equally spaced values by least squares.
+ * The value at index i is Intercept + Slope*i + Seasonal[i % len(Seasonal)]
+ */
+type Model struct {
+	Intercept float64
+	Slope     float64
+	// Empty if the values were too few to estimate the seasonality from
+	Seasonal []float64
+	// The standard deviation of the residuals
+	Sigma float64
+	n     int
+	meanX float64
+	sxx   float64
+}
+
+// The projected values of the steps after the fitted values, and their sum
+type Projection struct {
+	Values     []float64
+	Lower      []float64
+	Upper      []float64
+	Total      float64
+	TotalLower float64
+	TotalUpper float64
+}
+
+/*
+ * Fits a model to the values. The seasonality is only estimated if seasonLength is above 1 and the values cover at least two seasons,
+ * since a single season can not be told apart from noise
+ */
+func Fit(values []float64, seasonLength int) (Model, error) {
+	n := len(values)
+	if n < 2 {
+		return Model{}, fmt.Errorf("At least two values are needed for a forecast, got %d", n)
+	}
+
+	m := Model{n: n}
+	m.fitTrend(values)
+
+	if seasonLength > 1 && n >= 2*seasonLength {
+		// Estimate the seasonal indices from the detrended values and the trend from the deseasonalized values in turn,
+		// which converges to the joint least squares fit
+		m.Seasonal = make([]float64, seasonLength)
+		deseasonalized := make([]float64, n)
+
+		for iteration := 0; iteration < MAX_ITERATIONS; iteration++ {
+			previousSlope := m.Slope
+			m.fitSeasonal(values, seasonLength)
+
+			for i, value := range values {
+				deseasonalized[i] = value - m.Seasonal[i%seasonLength]
+			}
+
+			m.fitTrend(deseasonalized)
+
+			if math.Abs(m.Slope-previousSlope) < 1e-12*(1+math.Abs(m.Slope)) {
+				break
+			}
+		}
+	}
+
+	// One degree of freedom per estimated parameter
+	parameters := 2 + len(m.Seasonal)
+	squares := 0.0
+	for i, value := range values {
+		squares += math.Pow(value-m.Predict(i), 2)
+	}
+
+	if n > parameters {
+		m.Sigma = math.Sqrt(squares / float64(n-parameters))
+	}
+
+	return m, nil
+}
+
+func (m *Model) fitTrend(values []float64) {
+	n := float64(len(values))
+	m.meanX = (n - 1) / 2
+
+	meanY := 0.0
+	for _, value := range values {
+		meanY += value / n
+	}
+
+	sxy := 0.0
+	m.sxx = 0
+	for i, value := range values {
+		dx := float64(i) - m.meanX
+		sxy += dx * (value - meanY)
+		m.sxx += dx * dx
+	}
+
+	m.Slope = sxy / m.sxx
+	m.Intercept = meanY - m.Slope*m.meanX
+}
+
+/*
+ * Sets the seasonal indices to the mean detrended value of every phase, shifted to sum to zero since the trend holds the level
+ */
+func (m *Model) fitSeasonal(values []float64, seasonLength int) {
+	counts := make([]int, seasonLength)
+	for k := range m.Seasonal {
+		m.Seasonal[k] = 0
+	}
+
+	for i, value := range values {
+		m.Seasonal[i%seasonLength] += value - m.trend(i)
+		counts[i%seasonLength]++
+	}
+
+	mean := 0.0
+	for k := range m.Seasonal {
+		m.Seasonal[k] /= float64(counts[k])
+		mean += m.Seasonal[k] / float64(seasonLength)
+	}
+
+	for k := range m.Seasonal {
+		m.Seasonal[k] -= mean
+	}
+}
+
+func (m Model) trend(i int) float64 {
+	return m.Intercept + m.Slope*float64(i)
+}
+
+// Returns the modelled value at index i, where the fitted values have the indices 0 to n-1
+func (m Model) Predict(i int) float64 {
+	value := m.trend(i)
+
+	if len(m.Seasonal) > 0 {
+		value += m.Seasonal[i%len(m.Seasonal)]
+	}
+
+	return value
+}
+
+/*
+ * Projects the steps following the fitted values with bands of z standard deviations. The bands include the noise of the values and the
+ * uncertainty of the trend. Values can not go below zero, since costs can not
+ */
+func (m Model) Project(steps int, z float64) Projection {
+	projection := Projection{
+		Values: make([]float64, steps),
+		Lower:  make([]float64, steps),
+		Upper:  make([]float64, steps),
+	}
+
+	if steps == 0 {
+		return projection
+	}
+
+	n := float64(m.n)
+	meanFutureX := 0.0
+
+	for h := 0; h < steps; h++ {
+		i := m.n + h
+		dx := float64(i) - m.meanX
+		deviation := z * m.Sigma * math.Sqrt(1+1/n+dx*dx/m.sxx)
+		value := m.Predict(i)
+
+		projection.Values[h] = math.Max(value, 0)
+		projection.Lower[h] = math.Max(value-deviation, 0)
+		projection.Upper[h] = math.Max(value+deviation, 0)
+		projection.Total += projection.Values[h]
+		meanFutureX += float64(i) / float64(steps)
+	}
+
+	// The noise of the steps is independent, while the error of the trend is shared by all steps
+	H := float64(steps)
+	dx := meanFutureX - m.meanX
+	deviation := z * m.Sigma * math.Sqrt(H+H*H*(1/n+dx*dx/m.sxx))
+	projection.TotalLower = math.Max(projection.Total-deviation, 0)
+	projection.TotalUpper = projection.Total + deviation
+
+	return projection
+}
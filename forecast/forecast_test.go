@@ -0,0 +1,69 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const epsilon = 1e-9
+
+func TestFitLinearTrend(t *testing.T) {
+	values := []float64{1, 3, 5, 7, 9}
+
+	model, err := Fit(values, 0)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1, model.Intercept, epsilon)
+	assert.InDelta(t, 2, model.Slope, epsilon)
+	assert.InDelta(t, 0, model.Sigma, epsilon)
+
+	projection := model.Project(3, Z_95)
+	assert.InDeltaSlice(t, []float64{11, 13, 15}, projection.Values, epsilon)
+	assert.InDelta(t, 39, projection.Total, epsilon)
+	assert.InDelta(t, 39, projection.TotalUpper, epsilon)
+}
+
+func TestFitSeasonality(t *testing.T) {
+	season := []float64{2, -1, -1}
+	values := make([]float64, 12)
+	for i := range values {
+		values[i] = 10 + 0.5*float64(i) + season[i%3]
+	}
+
+	model, err := Fit(values, 3)
+	assert.Nil(t, err)
+	assert.InDeltaSlice(t, season, model.Seasonal, epsilon)
+	assert.InDelta(t, 0.5, model.Slope, epsilon)
+
+	projection := model.Project(3, Z_95)
+	assert.InDeltaSlice(t, []float64{18, 15.5, 16}, projection.Values, epsilon)
+
+	// A single season is not enough to estimate the seasonality from
+	model, err = Fit(values[:5], 3)
+	assert.Nil(t, err)
+	assert.Empty(t, model.Seasonal)
+}
+
+func TestProjectBands(t *testing.T) {
+	values := []float64{10, 12, 9, 11, 10, 13, 8, 11}
+
+	model, err := Fit(values, 0)
+	assert.Nil(t, err)
+	assert.Greater(t, model.Sigma, 0.0)
+
+	projection := model.Project(4, Z_95)
+	for h := range projection.Values {
+		assert.Less(t, projection.Lower[h], projection.Values[h])
+		assert.Greater(t, projection.Upper[h], projection.Values[h])
+	}
+
+	// The bands widen further away from the fitted values
+	assert.Greater(t, projection.Upper[3]-projection.Lower[3], projection.Upper[0]-projection.Lower[0])
+	assert.Less(t, projection.TotalLower, projection.Total)
+	assert.Greater(t, projection.TotalUpper, projection.Total)
+	assert.False(t, math.IsNaN(projection.TotalUpper))
+
+	_, err = Fit([]float64{1}, 0)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"dat067/costestimation/budget"
+	"dat067/costestimation/forecast"
+	"dat067/costestimation/prometheus"
+	"dat067/costestimation/store"
+	"dat067/costestimation/timerange"
+
+	"github.com/gin-gonic/gin"
+)
+
+const week = 7 * 24 * time.Hour
+
+type ForecastResponseItem struct {
+	Deployment string    `json:"deployment"`
+	PeriodEnd  time.Time `json:"periodEnd"`
+	// The cost from the start of the period until now
+	Actual float64 `json:"actual"`
+	// The projected cost of the whole period, and its 95% confidence band
+	Forecast float64 `json:"forecast"`
+	Lower    float64 `json:"lower"`
+	Upper    float64 `json:"upper"`
+	// Whether the history was long enough to include the weekly seasonality
+	Seasonal bool `json:"seasonal"`
+	// Whether the actual cost misses the start of the period, because neither Prometheus nor the cost store has it
+	Partial bool `json:"partial"`
+}
+
+func getForecasts(c *gin.Context) {
+	forecasts, err := getForecastsOfRequest(c)
+	if err != nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, forecasts)
+}
+
+func getDeploymentForecast(c *gin.Context) {
+	forecasts, err := getForecastsOfRequest(c)
+	if err != nil {
+		return
+	}
+
+	for _, item := range forecasts {
+		if item.Deployment == c.Param("deployment") {
+			c.JSON(http.StatusOK, item)
+			return
+		}
+	}
+
+	c.JSON(http.StatusNotFound, "deployment not found")
+}
+
+/*
+ * Reads the history, resolution and period query parameters and forecasts the deployments. Errors are written to the response
+ */
+func getForecastsOfRequest(c *gin.Context) ([]ForecastResponseItem, error) {
+	now := time.Now()
+	history, err := timerange.ParseDuration(c.DefaultQuery("history", "28d"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, err
+	}
+
+	resolution, err := timerange.ParseDuration(c.DefaultQuery("resolution", "1h"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, err
+	}
+
+	periodStart, periodEnd, err := budget.Period(c.DefaultQuery("period", budget.PERIOD_MONTH), now)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, err
+	}
+
+	// The history covers at least the period so far, since the actual cost is taken from it
+	endTime := now.Truncate(resolution)
+	historyStart := endTime.Add(-history)
+	if periodStart.Before(historyStart) {
+		historyStart = periodStart
+	}
+
+	historyStart, warnings, err := timerange.Validate(historyStart, endTime, resolution, now, timeLimits)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, err
+	}
+
+	for _, warning := range warnings {
+		c.Writer.Header().Add("Warning", fmt.Sprintf("299 - %q", warning))
+	}
+
+	forecasts, err := getPeriodForecasts(metricsSource, historyStart, endTime, resolution, periodStart, periodEnd)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil, err
+	}
+
+	return forecasts, nil
+}
+
+/*
+ * Forecasts the deployments like getDeploymentForecasts. When Prometheus no longer keeps the start of the period, the actual cost of that part
+ * is read from the cost store, and the forecasts are marked partial if the store does not have it either
+ */
+func getPeriodForecasts(source prometheus.MetricsSource, historyStart time.Time, endTime time.Time, resolution time.Duration, periodStart time.Time, periodEnd time.Time) ([]ForecastResponseItem, error) {
+	if !historyStart.After(periodStart) {
+		return getDeploymentForecasts(source, historyStart, endTime, resolution, periodStart, periodEnd)
+	}
+
+	// The live costs start at a step of the resolution, the costs before it are read from the store
+	if aligned := historyStart.Truncate(resolution); aligned.Before(historyStart) {
+		historyStart = aligned.Add(resolution)
+	}
+
+	if !historyStart.Before(endTime) {
+		return nil, fmt.Errorf("Prometheus keeps no full step of %s before %s to forecast from", resolution, endTime)
+	}
+
+	stored, partial, err := getStoredPeriodCosts(periodStart, historyStart)
+	if err != nil {
+		return nil, err
+	}
+
+	forecasts, err := getDeploymentForecasts(source, historyStart, endTime, resolution, periodStart, periodEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range forecasts {
+		price := stored[forecasts[i].Deployment]
+		forecasts[i].Actual += price
+		forecasts[i].Forecast += price
+		forecasts[i].Lower += price
+		forecasts[i].Upper += price
+		forecasts[i].Partial = partial
+	}
+
+	return forecasts, nil
+}
+
+/*
+ * Sums the cost of every deployment between startTime and endTime from the stored windows. Returns whether the store misses a part of the range
+ */
+func getStoredPeriodCosts(startTime time.Time, endTime time.Time) (map[string]float64, bool, error) {
+	if costStore == nil {
+		return nil, true, nil
+	}
+
+	windows, err := costStore.GetWindows(startTime, endTime)
+	if err != nil {
+		return nil, false, err
+	}
+
+	prices := make(map[string]float64)
+	for _, window := range windows {
+		for deployment, price := range window.DeploymentPrices() {
+			prices[deployment] += price
+		}
+	}
+
+	// The costs before the retention that the store does not have are gone
+	partial := len(store.Uncovered(windows, startTime, endTime)) > 0
+	return prices, partial, nil
+}
+
+/*
+ * Fits a linear trend with weekly seasonality to the cost of every deployment per resolution step between historyStart and endTime,
+ * and projects it to the end of the period
+ */
+func getDeploymentForecasts(source prometheus.MetricsSource, historyStart time.Time, endTime time.Time, resolution time.Duration, periodStart time.Time, periodEnd time.Time) ([]ForecastResponseItem, error) {
+	steps, err := getPodCostSteps(source, historyStart, endTime, resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	podDeployments := source.GetPodsToDeployment(endTime, endTime.Sub(historyStart))
+	series := make(map[string][]float64)
+	for _, deployment := range podDeployments {
+		series[deployment] = make([]float64, len(steps))
+	}
+
+	for i, step := range steps {
+		for pod, price := range step.Costs.Prices {
+			if deployment, ok := podDeployments[pod]; ok {
+				series[deployment][i] += price
+			}
+		}
+	}
+
+	seasonLength := 0
+	if week%resolution == 0 {
+		seasonLength = int(week / resolution)
+	}
+
+	futureSteps := 0
+	if periodEnd.After(endTime) {
+		futureSteps = int(math.Ceil(float64(periodEnd.Sub(endTime)) / float64(resolution)))
+	}
+
+	forecasts := make([]ForecastResponseItem, 0, len(series))
+	for deployment, values := range series {
+		model, err := forecast.Fit(values, seasonLength)
+		if err != nil {
+			return nil, err
+		}
+
+		item := ForecastResponseItem{
+			Deployment: deployment,
+			PeriodEnd:  periodEnd,
+			Seasonal:   len(model.Seasonal) > 0,
+		}
+
+		// A step ending at its time stamp belongs to the period if it ends after the period started
+		for i, step := range steps {
+			if step.Time.After(periodStart) {
+				item.Actual += values[i]
+			}
+		}
+
+		projection := model.Project(futureSteps, forecast.Z_95)
+		item.Forecast = item.Actual + projection.Total
+		item.Lower = item.Actual + projection.TotalLower
+		item.Upper = item.Actual + projection.TotalUpper
+		forecasts = append(forecasts, item)
+	}
+
+	sort.Slice(forecasts, func(i, j int) bool {
+		return forecasts[i].Deployment < forecasts[j].Deployment
+	})
+
+	return forecasts, nil
+}
@@ -1,7 +1,6 @@
 package azure
 
 import (
-	"errors"
 	"fmt"
 	"strings"
 
@@ -14,6 +13,13 @@ import (
 const LABEL_AZURE_INSTANCE_TYPE = "node.kubernetes.io/instance-type"
 const LABEL_AZURE_REGION = "topology.kubernetes.io/region"
 
+// The node pool of an AKS node, and the label older AKS versions use for it
+const LABEL_AZURE_AGENT_POOL = "kubernetes.azure.com/agentpool"
+const LABEL_AZURE_AGENT_POOL_LEGACY = "agentpool"
+
+// Nodes of the same instance type in the same region have the same price, so the price is only requested once
+var azureApi = pricing.NewCachingApi(pricing.NewApi())
+
 func GetPricedAzureNodes(c *kubernetes.Clientset) ([]kubeHelper.PricedNode, error) {
 	nodes, err := kubeHelper.GetNodes(c)
 
@@ -29,7 +35,11 @@ func GetPricedAzureNodes(c *kubernetes.Clientset) ([]kubeHelper.PricedNode, erro
 		azureRegion := labels[LABEL_AZURE_REGION]
 		operatingSystem := labels[kubeHelper.LABEL_OPERATING_SYSTEM]
 
-		azureApi := pricing.NewApi()
+		// Only Linux and Windows nodes have a price
+		if !strings.EqualFold(operatingSystem, kubeHelper.LABEL_OPERATING_SYSTEM_LINUX) && !strings.EqualFold(operatingSystem, kubeHelper.LABEL_OPERATING_SYSTEM_WINDOWS) {
+			continue
+		}
+
 		response, err := azureApi.Query(pricing.QueryFilter{
 			ArmSkuName:    azureInstanceType,
 			ArmRegionName: azureRegion,
@@ -42,39 +52,76 @@ func GetPricedAzureNodes(c *kubernetes.Clientset) ([]kubeHelper.PricedNode, erro
 		}
 
 		// Find price per unit of time for the Azure nodes in the Kubernetes cluster
-		for _, item := range response.Items {
-			if !strings.Contains(item.MeterName, pricing.METER_SPOT) && !strings.Contains(item.MeterName, pricing.METER_LOW_PRIORITY) {
-				if strings.ToLower(operatingSystem) == strings.ToLower(kubeHelper.LABEL_OPERATING_SYSTEM_LINUX) {
-					if !strings.Contains(item.ProductName, kubeHelper.LABEL_OPERATING_SYSTEM_WINDOWS) {
-						if err != nil {
-							return nil, errors.New(fmt.Sprintf("Invalid unit of measure returned by Azure Retail Prices API: '%s'", item.UnitOfMeasure))
-						}
-
-						pricedNodes = append(pricedNodes, kubeHelper.PricedNode{
-							Node:  node,
-							Price: item.UnitPrice,
-						})
-					}
-				} else if strings.ToLower(operatingSystem) == strings.ToLower(kubeHelper.LABEL_OPERATING_SYSTEM_WINDOWS) {
-					if strings.Contains(item.ProductName, kubeHelper.LABEL_OPERATING_SYSTEM_WINDOWS) {
-						if err != nil {
-							return nil, errors.New(fmt.Sprintf("Invalid unit of measure returned by Azure Retail Prices API: '%s'", item.UnitOfMeasure))
-						}
-
-						pricedNodes = append(pricedNodes, kubeHelper.PricedNode{
-							Node:  node,
-							Price: item.UnitPrice,
-						})
-
-					}
-				}
-			}
+		if price, ok := payAsYouGoPrice(response.Items, operatingSystem); ok {
+			pricedNodes = append(pricedNodes, kubeHelper.PricedNode{
+				Node:  node,
+				Price: price,
+			})
 		}
 	}
 
 	return pricedNodes, nil
 }
 
+/*
+ * Returns the pay as you go price per hour of the instance type in the region, for nodes running the operating system.
+ * The region is required, since without one the Retail Prices API answers with the prices of any region
+ */
+func GetSkuPrice(instanceType string, region string, operatingSystem string) (float64, error) {
+	if region == "" {
+		return 0, fmt.Errorf("No region to look up the price of the instance type '%s' in", instanceType)
+	}
+
+	response, err := azureApi.Query(pricing.QueryFilter{
+		ArmSkuName:    instanceType,
+		ArmRegionName: region,
+		CurrencyCode:  pricing.SEK,
+		PriceType:     "consumption",
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if price, ok := payAsYouGoPrice(response.Items, operatingSystem); ok {
+		return price, nil
+	}
+
+	return 0, fmt.Errorf("No price for the instance type '%s' in the region '%s'", instanceType, region)
+}
+
+/*
+ * Returns the price of the first item that is neither a spot nor a low priority price, for nodes running the operating system.
+ * Windows prices are the items whose product name mentions Windows
+ */
+func payAsYouGoPrice(items []pricing.Item, operatingSystem string) (float64, bool) {
+	windows := strings.EqualFold(operatingSystem, kubeHelper.LABEL_OPERATING_SYSTEM_WINDOWS)
+
+	for _, item := range items {
+		if strings.Contains(item.MeterName, pricing.METER_SPOT) || strings.Contains(item.MeterName, pricing.METER_LOW_PRIORITY) {
+			continue
+		}
+
+		if strings.Contains(item.ProductName, kubeHelper.LABEL_OPERATING_SYSTEM_WINDOWS) == windows {
+			return item.UnitPrice, true
+		}
+	}
+
+	return 0, false
+}
+
+/*
+ * Reads the Azure prices saved by SavePriceCache, so that prices queried before are not queried again
+ */
+func LoadPriceCache(path string) error {
+	return azureApi.Load(path)
+}
+
+// Saves the Azure prices queried so far
+func SavePriceCache(path string) error {
+	return azureApi.Save(path)
+}
+
 func PrintNodes(nodes []kubeHelper.PricedNode) {
 	for _, node := range nodes {
 		fmt.Printf("Node hostname: %s, node price per hour: %f\n", node.Node.Name, node.Price)
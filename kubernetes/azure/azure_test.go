@@ -0,0 +1,30 @@
+package azure
+
+import (
+	"testing"
+
+	kubeHelper "dat067/costestimation/kubernetes"
+	"dat067/costestimation/pricing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayAsYouGoPrice(t *testing.T) {
+	items := []pricing.Item{
+		{MeterName: "D4s v3 Spot", ProductName: "Virtual Machines DSv3 Series", UnitPrice: 0.3},
+		{MeterName: "D4s v3 Low Priority", ProductName: "Virtual Machines DSv3 Series", UnitPrice: 0.4},
+		{MeterName: "D4s v3", ProductName: "Virtual Machines DSv3 Series Windows", UnitPrice: 3},
+		{MeterName: "D4s v3", ProductName: "Virtual Machines DSv3 Series", UnitPrice: 2},
+	}
+
+	price, ok := payAsYouGoPrice(items, kubeHelper.LABEL_OPERATING_SYSTEM_LINUX)
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, price)
+
+	price, ok = payAsYouGoPrice(items, "windows")
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, price)
+
+	_, ok = payAsYouGoPrice(items[:2], kubeHelper.LABEL_OPERATING_SYSTEM_LINUX)
+	assert.False(t, ok)
+}
@@ -30,7 +30,14 @@ func CreateClientSet() (*kubernetes.Clientset, error) {
 	}
 	flag.Parse()
 
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	return NewClientSet(*kubeconfig)
+}
+
+/*
+ * Creates a client for the cluster of the kubeconfig file, or for the cluster the program runs in if the path is empty
+ */
+func NewClientSet(kubeconfig string) (*kubernetes.Clientset, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 
 	if err != nil {
 		return nil, err
@@ -48,3 +55,13 @@ func GetNodes(c *kubernetes.Clientset) ([]v1.Node, error) {
 
 	return nodeList.Items, nil
 }
+
+func GetPods(c *kubernetes.Clientset) ([]v1.Pod, error) {
+	podList, err := c.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return podList.Items, nil
+}
@@ -3,16 +3,27 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"dat067/costestimation/allocation"
+	"dat067/costestimation/anomaly"
+	"dat067/costestimation/budget"
 	"dat067/costestimation/kubernetes"
 	"dat067/costestimation/kubernetes/azure"
+	"dat067/costestimation/metrics"
 	"dat067/costestimation/models"
 
 	//"dat067/costestimation/models"
 	"dat067/costestimation/prometheus"
+	"dat067/costestimation/store"
+	"dat067/costestimation/timerange"
 	"net/http"
 
 	officialkube "k8s.io/client-go/kubernetes"
@@ -24,85 +35,406 @@ import (
 )
 
 var clientSet *officialkube.Clientset
+var metricsSource prometheus.MetricsSource
 var pricedNodes []kubernetes.PricedNode
+var idlePolicy allocation.IdlePolicy
+var sharedConfig allocation.SharedConfig
+var timeLimits timerange.Limits
+var queryConcurrency int
+var clusterQueries bool
+
+// How much memory and CPU weigh in the node price, in the order of the resources passed to the cost model. They are equally important
+var resourceBalance = []float64{1, 1}
+
+// Splits the node price between the pods on it
+var costModel models.ICostCalculator = models.GoodModel{Balance: resourceBalance}
 
 type ResponseItem struct {
 	Price          float64 `json:"price"`
 	DeploymentName string  `json:"deployment"`
 }
 
+type NamespaceResponseItem struct {
+	Namespace      string  `json:"namespace"`
+	Price          float64 `json:"price"`
+	SharedOverhead float64 `json:"sharedOverhead"`
+	Total          float64 `json:"total"`
+}
+
+type LabelResponseItem struct {
+	Labels map[string]string `json:"labels"`
+	Price  float64           `json:"price"`
+}
+
+type IdleResponse struct {
+	Policy  string         `json:"policy"`
+	Cluster float64        `json:"cluster"`
+	Nodes   []NodeIdleItem `json:"nodes"`
+}
+
+type NodeIdleItem struct {
+	Node  string  `json:"node"`
+	Price float64 `json:"price"`
+}
+
+/*
+ * The cost of every pod over a time period, and the idle cost of every node in the same period.
+ * A pod price is the sum of its CPU and memory usage cost in UsageCPU and UsageMemory, the cost of unused node capacity it is charged for
+ * in Wasted, and its share of the cluster idle cost in IdleShares
+ */
+type podCosts struct {
+	Prices      map[string]float64
+	UsageCPU    map[string]float64
+	UsageMemory map[string]float64
+	Wasted      map[string]float64
+	IdleShares  map[string]float64
+	Namespaces  map[string]string
+	Nodes       map[string]string
+	NodeCharged map[string]float64
+	NodeIdle    map[string]float64
+}
+
+// The priced usage samples of the pods on a node. Prices, WastedCosts and CPUShares have one entry per sample in Usages
+type nodeCostSamples struct {
+	Node        string
+	Usages      []prometheus.ResourceUsageSample
+	Prices      []map[string]float64
+	WastedCosts []map[string]float64
+	// The part of the usage cost of every pod that is for CPU, the rest is for memory
+	CPUShares []map[string]float64
+	Err       error
+}
+
+// The pod costs of a single resolution step, ending at Time
+type podCostStep struct {
+	Time  time.Time
+	Costs podCosts
+}
+
+func newPodCosts() podCosts {
+	return podCosts{
+		Prices:      make(map[string]float64),
+		UsageCPU:    make(map[string]float64),
+		UsageMemory: make(map[string]float64),
+		Wasted:      make(map[string]float64),
+		IdleShares:  make(map[string]float64),
+		Namespaces:  make(map[string]string),
+		Nodes:       make(map[string]string),
+		NodeCharged: make(map[string]float64),
+		NodeIdle:    make(map[string]float64),
+	}
+}
+
 func main() {
+	// Subcommands run without the server
+	if len(os.Args) > 1 && os.Args[1] == "estimate" {
+		err := runEstimateCommand(os.Args[2:])
+		if err != nil {
+			fmt.Printf("An error occured when estimating the manifests: '%v'\n", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		err := runDiffCommand(os.Args[2:])
+		if err != nil {
+			fmt.Printf("An error occured when comparing the manifests: '%v'\n", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
 	address := flag.String("url", "http://localhost:9090", "Put the address here, dummy!")
+	idlePolicyStr := flag.String("idle-policy", "node", "How the cost of unused node capacity is charged: 'node', 'separate' or 'redistribute'")
+	sharedNamespacesStr := flag.String("shared-namespaces", "", "Comma separated list of namespaces whose cost is shared by the tenant namespaces, e.g. 'kube-system,monitoring'")
+	sharedLabelsStr := flag.String("shared-labels", "", "Comma separated list of pod labels marking pods whose cost is shared by the tenant namespaces, e.g. 'team=platform'")
+	sharedPolicyStr := flag.String("shared-policy", "even", "How the shared cost is split between the tenant namespaces: 'even', 'usage' or 'weights'")
+	sharedWeightsStr := flag.String("shared-weights", "", "Comma separated list of tenant weights used by the 'weights' shared policy, e.g. 'shop=2,blog=1'")
+	storePath := flag.String("store", "", "Path of the database where computed costs are stored. Costs are always computed live if empty")
+	flag.DurationVar(&storeGranularity, "store-granularity", time.Hour, "Length of the periods the stored costs are computed for")
+	backfillStartStr := flag.String("backfill-start", "", "Start of the period to backfill the cost store with, e.g. 2021-12-01T00:00:00Z or now-30d")
+	backfillEndStr := flag.String("backfill-end", "", "End of the period to backfill the cost store with, e.g. startOfMonth. Defaults to now")
+	backfillStep := flag.Duration("backfill-step", 0, "Length of the backfilled windows. Defaults to the store granularity")
+	flag.IntVar(&timeLimits.MaxPoints, "max-points", timerange.PROMETHEUS_MAX_POINTS, "The largest number of resolution steps a request may ask for")
+	retentionStr := flag.String("retention", "15d", "How long Prometheus keeps data. Older start times are moved forward. Use 0 for no limit. "+
+		"With -thanos it defaults to the longest of -raw-retention, -5m-retention and -1h-retention instead")
+	flag.IntVar(&queryConcurrency, "concurrency", 4, "The largest number of nodes queried at the same time")
+	flag.BoolVar(&clusterQueries, "cluster-queries", false, "Query the usage and capacity of all nodes at once instead of node by node")
+	chunkPoints := flag.Int("chunk-points", prometheus.DEFAULT_CHUNK_POINTS, fmt.Sprintf("The largest number of steps queried by a single Prometheus range query, at most %d. Longer ranges are split into several queries", prometheus.MAX_POINTS_PER_QUERY))
+	var clientConfig prometheus.ClientConfig
+	flag.StringVar(&clientConfig.BearerTokenFile, "bearer-token-file", "", "File holding the bearer token sent to Prometheus")
+	flag.StringVar(&clientConfig.BasicAuthUser, "basic-auth-user", "", "User name for basic authentication against Prometheus")
+	basicAuthPasswordFile := flag.String("basic-auth-password-file", "", "File holding the password for basic authentication against Prometheus")
+	flag.StringVar(&clientConfig.CertFile, "tls-cert", "", "Client certificate presented to Prometheus")
+	flag.StringVar(&clientConfig.KeyFile, "tls-key", "", "Key of the client certificate presented to Prometheus")
+	flag.StringVar(&clientConfig.CAFile, "tls-ca", "", "CA bundle used to verify the certificate of Prometheus")
+	flag.BoolVar(&clientConfig.InsecureSkipVerify, "tls-insecure-skip-verify", false, "Do not verify the certificate of Prometheus")
+	headers := prometheus.HeaderFlag{}
+	flag.Var(headers, "prometheus-header", "A header sent to Prometheus, e.g. 'X-Scope-OrgID: team-a'. Can be given several times")
+	var downsampling prometheus.Downsampling
+	flag.BoolVar(&downsampling.Enabled, "thanos", false, "Pass max_source_resolution and partial_response to a Thanos or Mimir querier and adapt rate windows to downsampled data")
+	rawRetentionStr := flag.String("raw-retention", "14d", "How long the long-term store keeps raw data before only downsampled data is left. Use 0 if raw data is kept forever")
+	fiveMinuteRetentionStr := flag.String("5m-retention", "90d", "How long the long-term store keeps data downsampled to 5m before only data downsampled to 1h is left. Use 0 if it is kept forever")
+	oneHourRetentionStr := flag.String("1h-retention", "0", "How long the long-term store keeps data downsampled to 1h. Use 0 if it is kept forever")
+	flag.BoolVar(&downsampling.PartialResponse, "partial-response", false, "Accept partial responses from Thanos when some stores are unavailable")
+	queryConfigPath := flag.String("query-config", "", "JSON file replacing the metric names, label names, irate window or PromQL templates of the Prometheus queries")
+	recordDirectory := flag.String("record", "", "Directory to write every Prometheus query response to, for replaying in tests")
+	metricsInterval := flag.Duration("metrics-interval", 5*time.Minute, "How often the cost metrics on /metrics are recomputed, from the usage since the previous computation. Use 0 to not export costs")
+	budgetsPath := flag.String("budgets", "", "JSON file with the budgets to evaluate and the webhooks to alert, e.g. mounted from a ConfigMap")
+	budgetInterval := flag.Duration("budget-interval", time.Hour, "How often the budgets are evaluated")
+	anomalyDetection := flag.Bool("anomaly-detection", false, "Check the hourly cost of every deployment for spikes in the background")
+	anomalyWindowStr := flag.String("anomaly-window", "7d", "How many hours of history the hourly cost of a deployment is compared with")
+	detector := anomaly.DefaultDetector()
+	flag.Float64Var(&detector.Threshold, "anomaly-threshold", detector.Threshold, "How many median absolute deviations above the median hourly cost an anomaly is")
+	anomalyWebhook := flag.String("anomaly-webhook", "", "URL anomalies are posted to as Slack compatible JSON, with the contributing pods and nodes")
+	flag.Float64Var(&recommender.Headroom, "rightsizing-headroom", recommender.Headroom, "The share added on top of the usage percentile in proposed container requests, e.g. 0.15 for 15%")
+	backfillChunk := flag.Duration("backfill-chunk", 24*time.Hour, "Length of the period computed by each backfill query")
+	reportPath := flag.String("report", "", "Write a cost report to this file and exit instead of starting the server. The report is XLSX if the file ends with .xlsx and CSV otherwise")
+	reportBy := flag.String("report-by", "namespace", "What the report groups the costs by: 'namespace', 'deployment' or 'label:' followed by comma separated labels, e.g. label:team,env")
+	reportStartStr := flag.String("report-start", "startOfMonth", "Start of the reported period, e.g. 2021-12-01T00:00:00Z or now-30d")
+	reportEndStr := flag.String("report-end", "now", "End of the reported period, e.g. startOfMonth")
+	flag.StringVar(&chargebackLabel, "chargeback-label", "", "Pod label whose values are the cost centres of the monthly chargeback reports, e.g. cost-centre. Chargeback reports are not generated if empty")
+	chargebackDirectory := flag.String("chargeback-dir", "chargeback", "Directory the chargeback reports and their index are kept in")
+	chargebackBucket := flag.String("chargeback-bucket", "", "S3 compatible bucket the chargeback reports are kept in instead of the directory, e.g. s3://reports/chargeback")
+	chargebackEndpoint := flag.String("chargeback-endpoint", "https://s3.amazonaws.com", "Endpoint of the object store of the chargeback bucket, e.g. http://localhost:9000 for MinIO")
+	chargebackRegion := flag.String("chargeback-region", "us-east-1", "Region of the chargeback bucket")
 	flag.Parse()
 
+	var err error
+	idlePolicy, err = allocation.ParseIdlePolicy(*idlePolicyStr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	timeLimits.Retention, err = timerange.ParseDuration(*retentionStr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	downsampling.RawRetention, err = timerange.ParseDuration(*rawRetentionStr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	downsampling.FiveMinuteRetention, err = timerange.ParseDuration(*fiveMinuteRetentionStr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	downsampling.OneHourRetention, err = timerange.ParseDuration(*oneHourRetentionStr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	timeLimits.Retention = sourceRetention(timeLimits.Retention, isFlagSet("retention"), downsampling)
+
+	sharedConfig, err = parseSharedConfig(*sharedNamespacesStr, *sharedLabelsStr, *sharedPolicyStr, *sharedWeightsStr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	if *budgetsPath != "" {
+		budgetConfig, err = loadBudgetConfig(*budgetsPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		budgetAlerter = budget.NewAlerter(budgetConfig)
+		budgetStatuses.maxAge = *budgetInterval
+	}
+
+	if *anomalyDetection {
+		anomalyWindow, err := timerange.ParseDuration(*anomalyWindowStr)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		anomalies = newAnomalyState(anomalyWindow, detector)
+	}
+
+	if chargebackLabel != "" {
+		chargebackArchive, err = newChargebackArchive(*chargebackDirectory, *chargebackBucket, *chargebackEndpoint, *chargebackRegion)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	}
+
+	metrics.Register(costExporter.Registry())
+
 	router := gin.Default()
+	router.Use(observeRequestDuration)
 	//router.GET("/price", getDeploymentPrices)
 	router.GET("/price/:deployment", getDeploymentPrices)
+	router.GET("/price/namespace/:namespace", getNamespacePrices)
+	router.GET("/price/by-label/:label", getLabelPrices)
+	router.GET("/idle", getIdlePrices)
+	router.GET("/metrics", gin.WrapH(costExporter.Handler()))
+	router.GET("/budgets", getBudgets)
+	router.GET("/anomalies", getAnomalies)
+	router.GET("/forecast", getForecasts)
+	router.GET("/forecast/:deployment", getDeploymentForecast)
+	router.GET("/recommendations", getRecommendations)
+	router.GET("/report/namespace", getNamespaceReport)
+	router.GET("/report/deployment", getDeploymentReport)
+	router.GET("/report/by-label/:label", getLabelReport)
+	router.GET("/chargebacks", getChargebacks)
+	router.GET("/chargebacks/:id/:format", getChargeback)
+	router.POST("/consolidation", postConsolidation)
+	router.POST("/estimate", postEstimate)
+	router.GET("/healthz", getHealth)
+	router.GET("/readyz", getReadiness)
 
 	//endTime := time.Now()
 	//startTime := endTime.Add(-time.Hour)
 
-	var err error
+	if *storePath != "" {
+		costStore, err = store.Open(*storePath)
+		if err != nil {
+			fmt.Printf("An error occured when opening the cost store: '%v'\n", err)
+			os.Exit(-1)
+		}
+		defer costStore.Close()
+	}
+
 	fmt.Println("Before kubernetes clientSet")
 	clientSet, err = kubernetes.CreateClientSet()
 	fmt.Println("After kubernetes clientSet")
 	if err != nil {
-		fmt.Errorf("An error occured when creating the Kubernetes client: '%v'", err)
+		fmt.Printf("An error occured when creating the Kubernetes client: '%v'\n", err)
 		os.Exit(-1)
 	}
 	fmt.Println("Before getting price from Azure")
 	pricedNodes, err = azure.GetPricedAzureNodes(clientSet)
 	fmt.Println("After getting price from Azure")
 	if err != nil {
-		fmt.Errorf("An error occured while retrieving Azure node prices: '%v'", err)
+		fmt.Printf("An error occured while retrieving Azure node prices: '%v'\n", err)
 		os.Exit(-1)
 	}
 	fmt.Println("Before Prometheus API")
-	prometheus.CreateAPI(*address)
-	fmt.Println("After Prometheus API")
-	router.Run()
-	fmt.Println("After staring Gin router")
-}
-
-func getDeploymentPrices(c *gin.Context) {
-	wantedDeployment := c.Param("deployment")
-	// in postman URL: http://localhost:8080/price/coredns-autoscaler?startTime=2021-12-24T00:00:00.371Z&endTime=2021-12-25T00:00:00.371Z
-	endTimeStr := c.Query("endTime")
-	startTimeStr := c.Query("startTime")
-	resolutionStr := c.DefaultQuery("resolution", "None")
-	layout := "2006-01-02T15:04:05.000Z"
-
-	endTime, err := time.Parse(layout, endTimeStr)
+	clientConfig.Address = *address
+	clientConfig.Headers = headers
+	err = readClientCredentials(&clientConfig, *basicAuthPasswordFile)
 	if err != nil {
-		fmt.Print("ERROR")
-		fmt.Print(err.Error())
+		fmt.Printf("An error occured when reading the Prometheus client configuration: '%v'\n", err)
 		os.Exit(-1)
 	}
-
-	startTime, err := time.Parse(layout, startTimeStr)
+	prometheusSource, err := prometheus.NewPrometheusSourceFromConfig(clientConfig)
 	if err != nil {
-		fmt.Print("ERROR")
-		fmt.Print(err.Error())
+		fmt.Printf("An error occured when creating the Prometheus client: '%v'\n", err)
 		os.Exit(-1)
 	}
-	var pricedMap map[string]float64
-	//Abomination below
-	if resolutionStr != "None" {
-		resolution, err := time.ParseDuration(resolutionStr)
+
+	prometheusSource.SetChunkPoints(*chunkPoints)
+	prometheusSource.SetDownsampling(downsampling)
+
+	if *queryConfigPath != "" {
+		queries, err := prometheus.LoadQueries(*queryConfigPath)
+		if err != nil {
+			fmt.Printf("An error occured when loading the query configuration: '%v'\n", err)
+			os.Exit(-1)
+		}
+		prometheusSource.SetQueries(queries)
+	}
+
+	if *recordDirectory != "" {
+		err = prometheusSource.Record(*recordDirectory)
 		if err != nil {
-			fmt.Print("ERROR")
-			fmt.Print(err.Error())
+			fmt.Printf("An error occured when starting to record Prometheus responses: '%v'\n", err)
 			os.Exit(-1)
 		}
-		pricedMap, err = getDeploymentPrice(startTime, endTime, resolution)
+	}
+	metricsSource = prometheusSource
+	fmt.Println("After Prometheus API")
+	if *reportPath != "" {
+		err = writeCostReportFile(metricsSource, *reportPath, *reportBy, *reportStartStr, *reportEndStr)
 		if err != nil {
-			c.String(http.StatusInternalServerError, err.Error())
+			fmt.Printf("An error occured when writing the cost report: '%v'\n", err)
+			os.Exit(-1)
+		}
+		fmt.Printf("Wrote the cost report to %s\n", *reportPath)
+		return
+	}
+
+	if costStore != nil {
+		go runCostScheduler(metricsSource)
+	}
+
+	if anomalies != nil {
+		go runAnomalyDetector(metricsSource, anomalies, *anomalyWebhook)
+	}
+
+	if budgetAlerter != nil {
+		go runBudgetEvaluator(metricsSource, *budgetInterval)
+	}
+
+	if chargebackArchive != nil {
+		go runChargebackScheduler(metricsSource)
+	}
+
+	if *metricsInterval > 0 {
+		go runCostExporter(metricsSource, *metricsInterval)
+	}
+
+	if *backfillStartStr != "" {
+		if costStore == nil {
+			fmt.Println("A cost store is needed to backfill, see -store")
+			os.Exit(-1)
 		}
-	} else {
-		pricedMap, err = getDeploymentPriceOverPeriod(startTime, endTime)
+
+		backfillStart, err := timerange.ParseTime(*backfillStartStr, time.Now())
 		if err != nil {
-			c.String(http.StatusInternalServerError, err.Error())
+			fmt.Printf("Invalid backfill start time: '%v'\n", err)
+			os.Exit(-1)
 		}
+
+		backfillEnd := time.Now()
+		if *backfillEndStr != "" {
+			backfillEnd, err = timerange.ParseTime(*backfillEndStr, time.Now())
+			if err != nil {
+				fmt.Printf("Invalid backfill end time: '%v'\n", err)
+				os.Exit(-1)
+			}
+		}
+
+		if *backfillStep == 0 {
+			*backfillStep = storeGranularity
+		}
+
+		// Backfill in the background so the HTTP server can answer requests meanwhile
+		go func() {
+			err := runBackfill(metricsSource, backfillStart, backfillEnd, *backfillStep, *backfillChunk)
+			if err != nil {
+				fmt.Printf("The backfill failed: '%v'\n", err)
+				return
+			}
+			fmt.Println("The backfill is done")
+		}()
+	}
+	router.Run()
+	fmt.Println("After staring Gin router")
+}
+
+func getDeploymentPrices(c *gin.Context) {
+	wantedDeployment := c.Param("deployment")
+	// in postman URL: http://localhost:8080/price/coredns-autoscaler?startTime=2021-12-24T00:00:00.371Z&endTime=2021-12-25T00:00:00.371Z
+	startTime, endTime, resolution, err := parseTimeRange(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pricedMap, err := getDeploymentPrice(metricsSource, startTime, endTime, resolution)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
 	}
 
 	priceArray := make([]ResponseItem, len(pricedMap))
@@ -128,39 +460,678 @@ func getDeploymentPrices(c *gin.Context) {
 	c.JSON(http.StatusNotFound, "deployment not found")
 
 }
-func getDeploymentPriceOverPeriod(startTime time.Time, endTime time.Time) (map[string]float64, error) {
-	return getDeploymentPrice(startTime, endTime, endTime.Sub(startTime))
+
+/*
+ * Returns the cost of a tenant namespace, with the cost of the shared namespaces it is charged for as a separate line
+ * in postman URL: http://localhost:8080/price/namespace/default?startTime=2021-12-24T00:00:00.371Z&endTime=2021-12-25T00:00:00.371Z
+ */
+func getNamespacePrices(c *gin.Context) {
+	wantedNamespace := c.Param("namespace")
+	startTime, endTime, resolution, err := parseTimeRange(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	namespaceItems, err := getNamespacePrice(metricsSource, startTime, endTime, resolution)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for _, item := range namespaceItems {
+		if item.Namespace == wantedNamespace {
+			c.JSON(http.StatusOK, item)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, "namespace not found")
 }
 
-func getDeploymentPrice(startTime time.Time, endTime time.Time, resolution time.Duration) (map[string]float64, error) {
+/*
+ * Returns the pod costs grouped by the values of one or more comma separated pod labels
+ * in postman URL: http://localhost:8080/price/by-label/team,env?startTime=2021-12-24T00:00:00.371Z&endTime=2021-12-25T00:00:00.371Z
+ */
+func getLabelPrices(c *gin.Context) {
+	labels := allocation.ParseList(c.Param("label"))
+	if len(labels) == 0 {
+		c.String(http.StatusBadRequest, "no label to group by")
+		return
+	}
 
+	startTime, endTime, resolution, err := parseTimeRange(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	labelItems, err := getLabelPrice(metricsSource, startTime, endTime, resolution, labels)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, labelItems)
+}
+
+/*
+ * Returns the idle cost of every node and of the whole cluster
+ * in postman URL: http://localhost:8080/idle?startTime=2021-12-24T00:00:00.371Z&endTime=2021-12-25T00:00:00.371Z
+ */
+func getIdlePrices(c *gin.Context) {
+	startTime, endTime, resolution, err := parseTimeRange(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	costs, err := getPodCosts(metricsSource, startTime, endTime, resolution)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := IdleResponse{
+		Policy: idlePolicy.String(),
+		Nodes:  make([]NodeIdleItem, 0, len(costs.NodeIdle)),
+	}
+
+	for node, price := range costs.NodeIdle {
+		response.Cluster += price
+		response.Nodes = append(response.Nodes, NodeIdleItem{
+			Node:  node,
+			Price: price,
+		})
+	}
+
+	sort.Slice(response.Nodes, func(i, j int) bool {
+		return response.Nodes[i].Node < response.Nodes[j].Node
+	})
+
+	c.JSON(http.StatusOK, response)
+}
+
+// The process is alive as long as it answers
+func getHealth(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+/*
+ * The service is ready when the node prices are known and Prometheus answers queries
+ */
+func getReadiness(c *gin.Context) {
+	if len(pricedNodes) == 0 {
+		c.String(http.StatusServiceUnavailable, "No priced nodes")
+		return
+	}
+
+	if metricsSource == nil {
+		c.String(http.StatusServiceUnavailable, "No Prometheus client")
+		return
+	}
+
+	if checkedSource, ok := metricsSource.(prometheus.CheckedSource); ok {
+		err := checkedSource.Ping()
+		if err != nil {
+			c.String(http.StatusServiceUnavailable, fmt.Sprintf("Prometheus is not usable: %v", err))
+			return
+		}
+	}
+
+	c.String(http.StatusOK, "ok")
+}
+
+/*
+ * Records the duration of every request by route, so that the routes of e.g. every deployment name are counted together
+ */
+func observeRequestDuration(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+
+	metrics.HTTPRequestDuration.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+}
+
+/*
+ * Reads the startTime, endTime and resolution query parameters of a request, see timerange.ParseTime for the accepted formats.
+ * If no resolution is given the whole period is used as resolution. Adjustments made to the range are returned as Warning headers,
+ * and the resolution of the data the range is computed from as X-Data-Resolution header
+ */
+func parseTimeRange(c *gin.Context) (time.Time, time.Time, time.Duration, error) {
+	now := time.Now()
+	endTimeStr := c.DefaultQuery("endTime", "now")
+	startTimeStr := c.Query("startTime")
+	resolutionStr := c.DefaultQuery("resolution", "None")
+
+	endTime, err := timerange.ParseTime(endTimeStr, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+
+	startTime, err := timerange.ParseTime(startTimeStr, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+
+	resolution := endTime.Sub(startTime)
+	if resolutionStr != "None" {
+		resolution, err = timerange.ParseDuration(resolutionStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+	}
+
+	validStartTime, warnings, err := timerange.Validate(startTime, endTime, resolution, now, timeLimits)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+
+	for _, warning := range warnings {
+		c.Writer.Header().Add("Warning", fmt.Sprintf("299 - %q", warning))
+	}
+
+	// Downsampled data averages out short usage peaks, so tell the caller which data the costs are computed from
+	if downsampledSource, ok := metricsSource.(prometheus.DownsampledSource); ok {
+		c.Writer.Header().Set("X-Data-Resolution", prometheus.FormatResolution(downsampledSource.DataResolution(validStartTime)))
+	}
+
+	// A period resolution follows the clamped start time
+	if resolutionStr == "None" {
+		resolution = endTime.Sub(validStartTime)
+	}
+
+	return validStartTime, endTime, resolution, nil
+}
+
+/*
+ * Builds the shared overhead configuration from the command line flags
+ */
+func parseSharedConfig(namespaces string, labels string, policy string, weights string) (allocation.SharedConfig, error) {
+	config := allocation.SharedConfig{
+		Namespaces: allocation.ParseList(namespaces),
+		Labels:     make(map[string]string),
+	}
+
+	labelMap, err := allocation.ParseKeyValues(labels)
+	if err != nil {
+		return allocation.SharedConfig{}, err
+	}
+
+	// Match the label names exposed by kube-state-metrics
+	for name, value := range labelMap {
+		config.Labels[prometheus.SanitizeLabelName(name)] = value
+	}
+
+	config.Policy, err = allocation.ParseSharePolicy(policy)
+	if err != nil {
+		return allocation.SharedConfig{}, err
+	}
+
+	config.Weights, err = allocation.ParseWeights(weights)
+	if err != nil {
+		return allocation.SharedConfig{}, err
+	}
+
+	return config, nil
+}
+
+/*
+ * Reads the basic auth password of the Prometheus client configuration from its file
+ */
+func readClientCredentials(config *prometheus.ClientConfig, passwordFile string) error {
+	if passwordFile != "" {
+		password, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			return err
+		}
+		config.BasicAuthPassword = strings.TrimSpace(string(password))
+	}
+
+	return nil
+}
+
+/*
+ * Returns how far back the metrics source has data. A Thanos or Mimir store keeps data far longer than Prometheus, so unless -retention
+ * is given, the retention of its downsampled data is used instead of the Prometheus default
+ */
+func sourceRetention(retention time.Duration, retentionSet bool, downsampling prometheus.Downsampling) time.Duration {
+	if !downsampling.Enabled || retentionSet {
+		return retention
+	}
+
+	return downsampling.Retention()
+}
+
+// Returns whether the command line flag was given
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+func getDeploymentPriceOverPeriod(source prometheus.MetricsSource, startTime time.Time, endTime time.Time) (map[string]float64, error) {
+	return getDeploymentPrice(source, startTime, endTime, endTime.Sub(startTime))
+}
+
+func getDeploymentPrice(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, resolution time.Duration) (map[string]float64, error) {
+	if costStore != nil {
+		return getStoredDeploymentPrice(source, startTime, endTime, resolution)
+	}
+
+	return getLiveDeploymentPrice(source, startTime, endTime, resolution)
+}
+
+func getLiveDeploymentPrice(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, resolution time.Duration) (map[string]float64, error) {
 	duration := endTime.Sub(startTime)
-	podPrices := make(map[string]float64)
-	for _, node := range pricedNodes {
-		podsResourceUsages, warnings, err := prometheus.GetAvgPodResourceUsageOverTime(node.Node.Name, startTime, endTime, resolution)
+	costs, err := getPodCosts(source, startTime, endTime, resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	priceMap := groupPodPricesToDeployment(source, costs.Prices, endTime, duration)
+
+	if idlePolicy == allocation.IdleSeparate {
+		for _, idle := range costs.NodeIdle {
+			priceMap[allocation.IDLE_WORKLOAD] += idle
+		}
+	}
+
+	return priceMap, nil
+}
+
+/*
+ * Groups the pod costs by the values of the given pod labels, using kube_pod_labels
+ */
+func getLabelPrice(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, resolution time.Duration, labels []string) ([]LabelResponseItem, error) {
+	duration := endTime.Sub(startTime)
+	costs, err := getPodCosts(source, startTime, endTime, resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	podLabels, warnings, err := source.GetPodLabels(endTime, duration)
+	if warnings != nil {
+		fmt.Println(warnings)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(labels))
+	for i, label := range labels {
+		keys[i] = prometheus.SanitizeLabelName(label)
+	}
+
+	groups := allocation.GroupByLabels(costs.Prices, podLabels, keys)
+	labelItems := make([]LabelResponseItem, 0, len(groups)+1)
+	for _, group := range groups {
+		item := LabelResponseItem{
+			Labels: make(map[string]string),
+			Price:  group.Cost,
+		}
+
+		for i, label := range labels {
+			item.Labels[label] = group.Values[i]
+		}
+
+		labelItems = append(labelItems, item)
+	}
+
+	if idlePolicy == allocation.IdleSeparate {
+		idleItem := LabelResponseItem{Labels: make(map[string]string)}
+		for _, label := range labels {
+			idleItem.Labels[label] = allocation.IDLE_WORKLOAD
+		}
+
+		for _, idle := range costs.NodeIdle {
+			idleItem.Price += idle
+		}
+		labelItems = append(labelItems, idleItem)
+	}
+
+	return labelItems, nil
+}
+
+/*
+ * Groups the pod costs by tenant namespace. The cost of the shared pods is split between the tenants according to sharedConfig,
+ * and pods in the shared namespaces are not reported as tenants themselves
+ */
+func getNamespacePrice(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, resolution time.Duration) ([]NamespaceResponseItem, error) {
+	duration := endTime.Sub(startTime)
+	costs, err := getPodCosts(source, startTime, endTime, resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	podLabels := make(map[string]map[string]string)
+	if len(sharedConfig.Labels) > 0 {
+		var warnings []string
+		podLabels, warnings, err = source.GetPodLabels(endTime, duration)
 
 		if warnings != nil {
 			fmt.Println(warnings)
 		}
 
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(-1)
+			return nil, err
 		}
+	}
+
+	sharedCost := 0.0
+	tenantUsage := make(map[string]float64)
+	for pod, price := range costs.Prices {
+		namespace := costs.Namespaces[pod]
+
+		if sharedConfig.IsShared(namespace, podLabels[pod]) {
+			sharedCost += price
+			continue
+		}
+
+		tenantUsage[namespace] += price
+	}
+
+	overheads := sharedConfig.Distribute(sharedCost, tenantUsage)
+	namespaceItems := make([]NamespaceResponseItem, 0, len(tenantUsage)+1)
+	for namespace, price := range tenantUsage {
+		namespaceItems = append(namespaceItems, NamespaceResponseItem{
+			Namespace:      namespace,
+			Price:          price,
+			SharedOverhead: overheads[namespace],
+			Total:          price + overheads[namespace],
+		})
+	}
+
+	// Without tenants the shared cost is a row of its own instead of being dropped
+	if sharedCost, ok := overheads[allocation.SHARED_WORKLOAD]; ok {
+		namespaceItems = append(namespaceItems, NamespaceResponseItem{Namespace: allocation.SHARED_WORKLOAD, Price: sharedCost, Total: sharedCost})
+	}
+
+	if idlePolicy == allocation.IdleSeparate {
+		idleItem := NamespaceResponseItem{Namespace: allocation.IDLE_WORKLOAD}
+		for _, idle := range costs.NodeIdle {
+			idleItem.Price += idle
+		}
+		idleItem.Total = idleItem.Price
+		namespaceItems = append(namespaceItems, idleItem)
+	}
 
-		for _, podsResourceUsage := range podsResourceUsages {
+	sort.Slice(namespaceItems, func(i, j int) bool {
+		return namespaceItems[i].Namespace < namespaceItems[j].Namespace
+	})
 
-			tmap := getPrice(podsResourceUsage, node, resolution)
-			for k, v := range tmap {
-				podPrices[k] += v
+	return namespaceItems, nil
+}
+
+/*
+ * Calculates the cost of every pod running on the priced nodes between startTime and endTime, and how much of the node prices were left idle.
+ * The idle cost is charged according to idlePolicy
+ */
+func getPodCosts(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, resolution time.Duration) (podCosts, error) {
+	duration := endTime.Sub(startTime)
+	steps, err := getPodCostSteps(source, startTime, endTime, resolution)
+	if err != nil {
+		return podCosts{}, err
+	}
+
+	costs := newPodCosts()
+	for _, step := range steps {
+		for pod, price := range step.Costs.Prices {
+			costs.Prices[pod] += price
+			costs.UsageCPU[pod] += step.Costs.UsageCPU[pod]
+			costs.UsageMemory[pod] += step.Costs.UsageMemory[pod]
+			costs.Wasted[pod] += step.Costs.Wasted[pod]
+			costs.Namespaces[pod] = step.Costs.Namespaces[pod]
+			costs.Nodes[pod] = step.Costs.Nodes[pod]
+		}
+
+		for node, charged := range step.Costs.NodeCharged {
+			costs.NodeCharged[node] += charged
+		}
+	}
+
+	for _, node := range pricedNodes {
+		costs.NodeIdle[node.Node.Name] = allocation.NodeIdleCost(node.Price, duration.Hours(), []float64{costs.NodeCharged[node.Node.Name]})
+	}
+
+	if idlePolicy == allocation.IdleRedistribute {
+		redistributeIdleCost(costs)
+	}
+
+	return costs, nil
+}
+
+/*
+ * Calculates the cost of every pod for each resolution step between startTime and endTime.
+ * The idle cost of the steps is not redistributed, even if idlePolicy says so
+ */
+func getPodCostSteps(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, resolution time.Duration) ([]podCostStep, error) {
+	stepTimes := prometheus.StepTimes(startTime, endTime, resolution)
+	steps := make([]podCostStep, len(stepTimes))
+	stepIndex := make(map[int64]int)
+	for i, t := range stepTimes {
+		steps[i] = podCostStep{
+			Time:  t,
+			Costs: newPodCosts(),
+		}
+		stepIndex[t.Unix()] = i
+	}
+
+	nodeSamples, err := getCostSamples(source, startTime, endTime, resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, samples := range nodeSamples {
+		for j, podsResourceUsage := range samples.Usages {
+			i, ok := stepIndex[podsResourceUsage.Time.Unix()]
+			if !ok {
+				fmt.Printf("Warning: The time stamp %s is not a step between %s and %s\n", podsResourceUsage.Time, startTime, endTime)
+				continue
+			}
+
+			costs := steps[i].Costs
+			for pod, price := range samples.Prices[j] {
+				wasted := samples.WastedCosts[j][pod]
+				// The wasted cost is charged as idle cost instead
+				if idlePolicy != allocation.IdleNode {
+					price -= wasted
+					wasted = 0
+				}
+
+				usage := price - wasted
+				costs.Prices[pod] += price
+				costs.UsageCPU[pod] += usage * samples.CPUShares[j][pod]
+				costs.UsageMemory[pod] += usage * (1 - samples.CPUShares[j][pod])
+				costs.Wasted[pod] += wasted
+				costs.Nodes[pod] = samples.Node
+				costs.NodeCharged[samples.Node] += price
+			}
+
+			for pod, resourceUsage := range podsResourceUsage.ResourceUsages {
+				costs.Namespaces[pod] = resourceUsage.Namespace
+			}
+		}
+	}
+
+	for _, step := range steps {
+		for _, node := range pricedNodes {
+			step.Costs.NodeIdle[node.Node.Name] = allocation.NodeIdleCost(node.Price, resolution.Hours(), []float64{step.Costs.NodeCharged[node.Node.Name]})
+		}
+	}
+
+	return steps, nil
+}
+
+/*
+ * Prices the resource usage samples of the pods on every priced node, in the order of pricedNodes
+ */
+func getCostSamples(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, resolution time.Duration) ([]nodeCostSamples, error) {
+	clusterSource, ok := source.(prometheus.ClusterMetricsSource)
+	if clusterQueries && ok {
+		return getClusterCostSamples(clusterSource, startTime, endTime, resolution)
+	}
+
+	// Query the nodes concurrently, but merge their costs in order
+	nodeSamples := make([]nodeCostSamples, len(pricedNodes))
+	forEachConcurrently(len(pricedNodes), queryConcurrency, func(i int) {
+		nodeSamples[i] = getNodeCostSamples(source, pricedNodes[i], startTime, endTime, resolution)
+	})
+
+	for _, samples := range nodeSamples {
+		if samples.Err != nil {
+			return nil, samples.Err
+		}
+	}
+
+	return nodeSamples, nil
+}
+
+/*
+ * Queries the resource usage of the pods on a node and prices every sample
+ */
+func getNodeCostSamples(source prometheus.MetricsSource, node kubernetes.PricedNode, startTime time.Time, endTime time.Time, resolution time.Duration) nodeCostSamples {
+	samples := nodeCostSamples{Node: node.Node.Name}
+	start := time.Now()
+	defer func() {
+		metrics.NodeCostDuration.WithLabelValues(node.Node.Name).Observe(time.Since(start).Seconds())
+	}()
+
+	podsResourceUsages, warnings, err := source.GetAvgPodResourceUsageOverTime(node.Node.Name, startTime, endTime, resolution)
+
+	if warnings != nil {
+		fmt.Println(warnings)
+	}
+
+	if err != nil {
+		samples.Err = err
+		return samples
+	}
+
+	for _, podsResourceUsage := range podsResourceUsages {
+		nodeMem, _, err := source.GetMemoryNodeCapacity(node.Node.Name, podsResourceUsage.Time)
+		if err != nil {
+			samples.Err = err
+			return samples
+		}
+
+		nodeCPU, _, err := source.GetCPUNodeCapacity(node.Node.Name, podsResourceUsage.Time)
+		if err != nil {
+			samples.Err = err
+			return samples
+		}
+
+		prices, wastedCosts := getPrice(podsResourceUsage, node, nodeMem, nodeCPU, resolution)
+		samples.Usages = append(samples.Usages, podsResourceUsage)
+		samples.Prices = append(samples.Prices, prices)
+		samples.WastedCosts = append(samples.WastedCosts, wastedCosts)
+		samples.CPUShares = append(samples.CPUShares, getCPUShares(podsResourceUsage, nodeMem, nodeCPU))
+	}
+
+	return samples
+}
+
+/*
+ * Queries the resource usage and capacity of all nodes at once and prices every sample, instead of querying each node and sample by itself
+ */
+func getClusterCostSamples(source prometheus.ClusterMetricsSource, startTime time.Time, endTime time.Time, resolution time.Duration) ([]nodeCostSamples, error) {
+	usages, warnings, err := source.GetClusterPodResourceUsageOverTime(startTime, endTime, resolution)
+	if warnings != nil {
+		fmt.Println(warnings)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	capacities, warnings, err := source.GetClusterNodeCapacityOverTime(startTime, endTime, resolution)
+	if warnings != nil {
+		fmt.Println(warnings)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodeSamples := make([]nodeCostSamples, len(pricedNodes))
+	for i, node := range pricedNodes {
+		nodeSamples[i].Node = node.Node.Name
+
+		for _, podsResourceUsage := range usages[node.Node.Name] {
+			capacity, ok := prometheus.CapacityAt(capacities[node.Node.Name], podsResourceUsage.Time)
+			if !ok {
+				return nil, fmt.Errorf("The node %s has no capacity", node.Node.Name)
 			}
+
+			prices, wastedCosts := getPrice(podsResourceUsage, node, capacity.Memory, capacity.CPU, resolution)
+			nodeSamples[i].Usages = append(nodeSamples[i].Usages, podsResourceUsage)
+			nodeSamples[i].Prices = append(nodeSamples[i].Prices, prices)
+			nodeSamples[i].WastedCosts = append(nodeSamples[i].WastedCosts, wastedCosts)
+			nodeSamples[i].CPUShares = append(nodeSamples[i].CPUShares, getCPUShares(podsResourceUsage, capacity.Memory, capacity.CPU))
 		}
 	}
-	return groupPodPricesToDeployment(podPrices, endTime, duration), nil
+
+	return nodeSamples, nil
 }
 
-func groupPodPricesToDeployment(podPrices map[string]float64, endTime time.Time, duration time.Duration) map[string]float64 {
-	deploymentMap := prometheus.GetPodsToDeployment(endTime, duration)
+/*
+ * Calls f for every index from 0 to n-1, with at most workers calls running at the same time
+ */
+func forEachConcurrently(n int, workers int, f func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				f(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+}
+
+/*
+ * Adds the idle cost of all nodes to the pod prices, split between the namespaces proportionally to their usage
+ */
+func redistributeIdleCost(costs podCosts) {
+	clusterIdle := 0.0
+	for _, idle := range costs.NodeIdle {
+		clusterIdle += idle
+	}
+
+	namespaceUsage := make(map[string]float64)
+	for pod, price := range costs.Prices {
+		namespaceUsage[costs.Namespaces[pod]] += price
+	}
+
+	shares := allocation.RedistributeIdle(namespaceUsage, clusterIdle)
+	for pod, share := range allocation.SplitToPods(costs.Prices, costs.Namespaces, shares) {
+		costs.Prices[pod] += share
+		costs.IdleShares[pod] += share
+	}
+}
+
+func groupPodPricesToDeployment(source prometheus.MetricsSource, podPrices map[string]float64, endTime time.Time, duration time.Duration) map[string]float64 {
+	deploymentMap := source.GetPodsToDeployment(endTime, duration)
 	priceMap := make(map[string]float64)
 
 	for pod, deployment := range deploymentMap {
@@ -194,10 +1165,14 @@ func groupPodPricesToDeployment(podPrices map[string]float64, endTime time.Time,
 	fmt.Printf("Cost of nodes was %f. Total cost of pods was %f. \nThe pods being used in deployments amount to %f. \n", sumNode, sumPrice, sumPriceMap)
 	return priceMap
 }
-func getPrice(podsResourceUsage prometheus.ResourceUsageSample, node kubernetes.PricedNode, resolution time.Duration) map[string]float64 {
+
+/*
+ * Returns the price and the wasted cost of every pod in the sample. The wasted cost is the part of the price charged for unused node capacity
+ */
+func getPrice(podsResourceUsage prometheus.ResourceUsageSample, node kubernetes.PricedNode, nodeMem float64, nodeCPU float64, resolution time.Duration) (map[string]float64, map[string]float64) {
 	podPrices := make(map[string]float64)
+	podWastedCosts := make(map[string]float64)
 	pods := podsResourceUsage.ResourceUsages
-	t := podsResourceUsage.Time
 
 	monster := make([][]float64, len(pods))
 	index := 0
@@ -215,10 +1190,7 @@ func getPrice(podsResourceUsage prometheus.ResourceUsageSample, node kubernetes.
 
 	//TODO: We get all the pods on a node, even those not belonging to a deployment.
 	//Calculate pods' cost
-	nodeMem, _, _ := prometheus.GetMemoryNodeCapacity(node.Node.Name, t)
-	nodeCPU, _, _ := prometheus.GetCPUNodeCapacity(node.Node.Name, t)
-	costCalculator := models.GoodModel{Balance: []float64{1, 1}}
-	price, wastedCost := costCalculator.CalculateCost(
+	price, wastedCost := costModel.CalculateCost(
 		[]float64{
 			nodeMem,
 			nodeCPU},
@@ -233,6 +1205,7 @@ func getPrice(podsResourceUsage prometheus.ResourceUsageSample, node kubernetes.
 
 		totalPodPrice += price[index]
 		podPrices[pod] += price[index]
+		podWastedCosts[pod] += wastedCost[index]
 		index += 1
 	}
 
@@ -247,7 +1220,26 @@ func getPrice(podsResourceUsage prometheus.ResourceUsageSample, node kubernetes.
 	if math.Abs(totalPodPrice-resolution.Hours()*node.Price) > 1e-10 {
 		fmt.Printf("The sum of the pod prices is %f. The node price is %f\n", totalPodPrice, resolution.Hours()*node.Price)
 	}
-	return podPrices
+	return podPrices, podWastedCosts
+}
+
+/*
+ * Returns the part of the usage cost of every pod in the sample that is for CPU. The cost model charges each resource by the share of the
+ * node capacity used, weighted by resourceBalance
+ */
+func getCPUShares(podsResourceUsage prometheus.ResourceUsageSample, nodeMem float64, nodeCPU float64) map[string]float64 {
+	shares := make(map[string]float64)
+	for pod, resourceUsage := range podsResourceUsage.ResourceUsages {
+		memory, cpu := resourceBalance[0], resourceBalance[1]
+		if nodeMem > 0 && nodeCPU > 0 && (resourceUsage.MemUsage > 0 || resourceUsage.CpuUsage > 0) {
+			memory *= resourceUsage.MemUsage / nodeMem
+			cpu *= resourceUsage.CpuUsage / nodeCPU
+		}
+
+		shares[pod] = cpu / (memory + cpu)
+	}
+
+	return shares
 }
 
 func printVector(v model.Vector) {
@@ -1,11 +1,1151 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"dat067/costestimation/allocation"
+	"dat067/costestimation/anomaly"
+	"dat067/costestimation/budget"
+	"dat067/costestimation/chargeback"
+	"dat067/costestimation/consolidation"
+	"dat067/costestimation/estimate"
+	"dat067/costestimation/kubernetes"
+	"dat067/costestimation/kubernetes/azure"
+	"dat067/costestimation/prometheus"
+	"dat067/costestimation/report"
+	"dat067/costestimation/rightsizing"
+	"dat067/costestimation/store"
+	"dat067/costestimation/timerange"
+
+	"github.com/gin-gonic/gin"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const epsilon float64 = 1e-9
+
+var update = flag.Bool("update", false, "Rewrite the golden files with the current output")
+
+var testStart = time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC)
+var testEnd = testStart.Add(4 * time.Hour)
+
 func TestStuff(t *testing.T) {
 	assert.Equal(t, 100, 100)
 }
+
+/*
+ * Loads the fake cluster fixture and sets the priced nodes and idle policy used by the cost pipeline
+ */
+func setupFakeCluster(t *testing.T, policy allocation.IdlePolicy) *prometheus.FakeSource {
+	source, err := prometheus.LoadFakeSource("testdata/cluster.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pricedNodes = make([]kubernetes.PricedNode, len(source.Nodes))
+	for i, node := range source.Nodes {
+		pricedNodes[i] = kubernetes.PricedNode{
+			Node:  v1.Node{ObjectMeta: metav1.ObjectMeta{Name: node.Name}},
+			Price: node.Price,
+		}
+	}
+
+	idlePolicy = policy
+	costStore = nil
+	queryConcurrency = 3
+	return source
+}
+
+func clusterPrice(hours float64) float64 {
+	price := 0.0
+	for _, node := range pricedNodes {
+		price += node.Price * hours
+	}
+	return price
+}
+
+func sumPrices(prices map[string]float64) float64 {
+	sum := 0.0
+	for _, price := range prices {
+		sum += price
+	}
+	return sum
+}
+
+func TestPodPricesSumToNodePrice(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+
+	steps, err := getPodCostSteps(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	assert.Len(t, steps, 4)
+
+	for _, step := range steps {
+		assert.InDelta(t, 2, step.Costs.NodeCharged["node-1"], epsilon)
+		assert.InDelta(t, 1, step.Costs.NodeCharged["node-2"], epsilon)
+		assert.InDelta(t, 0, step.Costs.NodeCharged["node-3"], epsilon)
+	}
+
+	costs, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	assert.InDelta(t, 8, costs.NodeCharged["node-1"], epsilon)
+	assert.InDelta(t, 4, costs.NodeCharged["node-2"], epsilon)
+	assert.InDelta(t, 0, costs.NodeIdle["node-1"], epsilon)
+	assert.InDelta(t, 0, costs.NodeIdle["node-2"], epsilon)
+	// A node without pods is idle the whole period
+	assert.InDelta(t, 2, costs.NodeIdle["node-3"], epsilon)
+	assert.InDelta(t, 12, sumPrices(costs.Prices), epsilon)
+	assert.Equal(t, "kube-system", costs.Namespaces["coredns-7b9d-mm2lp"])
+}
+
+func TestDeploymentPrice(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+
+	costs, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+
+	deploymentPrices, err := getDeploymentPrice(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	assert.Len(t, deploymentPrices, 3)
+	assert.InDelta(t, costs.Prices["web-6d4f-x2k9p"]+costs.Prices["web-6d4f-q8m2z"], deploymentPrices["web"], epsilon)
+	assert.InDelta(t, costs.Prices["api-5c8b-7hd4k"], deploymentPrices["api"], epsilon)
+	assert.InDelta(t, costs.Prices["coredns-7b9d-mm2lp"], deploymentPrices["coredns"], epsilon)
+
+	// The job pod does not belong to a deployment
+	assert.InDelta(t, sumPrices(costs.Prices)-costs.Prices["backup-27341-vb5nr"], sumPrices(deploymentPrices), epsilon)
+}
+
+func TestDeploymentPriceOverPeriod(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+
+	deploymentPrices, err := getDeploymentPriceOverPeriod(source, testStart, testEnd)
+	assert.Nil(t, err)
+	assert.Len(t, deploymentPrices, 3)
+
+	costs, err := getPodCosts(source, testStart, testEnd, testEnd.Sub(testStart))
+	assert.Nil(t, err)
+	assert.InDelta(t, 8, costs.NodeCharged["node-1"], epsilon)
+	assert.InDelta(t, 4, costs.NodeCharged["node-2"], epsilon)
+}
+
+func TestIdleSeparate(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleSeparate)
+
+	costs, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	assert.InDelta(t, clusterPrice(4), sumPrices(costs.Prices)+sumPrices(costs.NodeIdle), epsilon)
+	assert.Greater(t, costs.NodeIdle["node-1"], 0.0)
+
+	deploymentPrices, err := getDeploymentPrice(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	assert.InDelta(t, sumPrices(costs.NodeIdle), deploymentPrices[allocation.IDLE_WORKLOAD], epsilon)
+}
+
+func TestIdleRedistribute(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleRedistribute)
+
+	costs, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	assert.InDelta(t, clusterPrice(4), sumPrices(costs.Prices), epsilon)
+
+	deploymentPrices, err := getDeploymentPrice(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	_, ok := deploymentPrices[allocation.IDLE_WORKLOAD]
+	assert.False(t, ok)
+}
+
+func TestNamespacePrice(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+	sharedConfig = allocation.SharedConfig{Namespaces: []string{"kube-system"}, Policy: allocation.ShareEven}
+	defer func() { sharedConfig = allocation.SharedConfig{} }()
+
+	costs, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+
+	namespaceItems, err := getNamespacePrice(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	assert.Len(t, namespaceItems, 2)
+	assert.Equal(t, "blog", namespaceItems[0].Namespace)
+	assert.Equal(t, "shop", namespaceItems[1].Namespace)
+
+	sharedCost := costs.Prices["coredns-7b9d-mm2lp"]
+	assert.InDelta(t, sharedCost/2, namespaceItems[0].SharedOverhead, epsilon)
+	assert.InDelta(t, sharedCost/2, namespaceItems[1].SharedOverhead, epsilon)
+	assert.InDelta(t, sumPrices(costs.Prices), namespaceItems[0].Total+namespaceItems[1].Total, epsilon)
+
+	// Without tenants the shared cost is kept as a row of its own
+	sharedConfig.Namespaces = []string{"kube-system", "shop", "blog"}
+	namespaceItems, err = getNamespacePrice(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	assert.Len(t, namespaceItems, 1)
+	assert.Equal(t, allocation.SHARED_WORKLOAD, namespaceItems[0].Namespace)
+	assert.InDelta(t, sumPrices(costs.Prices), namespaceItems[0].Total, epsilon)
+}
+
+func TestLabelPrice(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+
+	costs, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+
+	labelItems, err := getLabelPrice(source, testStart, testEnd, time.Hour, []string{"team"})
+	assert.Nil(t, err)
+	assert.Len(t, labelItems, 3)
+	assert.Equal(t, map[string]string{"team": "blog"}, labelItems[0].Labels)
+	assert.Equal(t, map[string]string{"team": "shop"}, labelItems[1].Labels)
+	assert.Equal(t, map[string]string{"team": allocation.UNLABELLED}, labelItems[2].Labels)
+	assert.InDelta(t, costs.Prices["coredns-7b9d-mm2lp"], labelItems[2].Price, epsilon)
+}
+
+/*
+ * Replays recorded Prometheus responses through the promv1 client and compares the deployment prices with the golden file.
+ * Run with -update to rewrite the golden file
+ */
+func TestGoldenReplay(t *testing.T) {
+	setupFakeCluster(t, allocation.IdleNode)
+
+	handler, err := prometheus.NewReplayHandler("testdata/recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	source, err := prometheus.NewPrometheusSource(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := make(map[string]map[string]float64)
+	output["hourly"], err = getDeploymentPrice(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	output["period"], err = getDeploymentPriceOverPeriod(source, testStart, testEnd)
+	assert.Nil(t, err)
+
+	goldenFile := "testdata/golden/deployment_prices.json"
+	if *update {
+		content, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(goldenFile, append(content, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := make(map[string]map[string]float64)
+	if err := json.Unmarshal(content, &golden); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(golden), len(output))
+	for name, prices := range golden {
+		assert.Equal(t, len(prices), len(output[name]), name)
+		for deployment, price := range prices {
+			assert.InDelta(t, price, output[name][deployment], epsilon, "%s %s", name, deployment)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	timeLimits = timerange.Limits{MaxPoints: 100, Retention: 24 * time.Hour}
+	defer func() { timeLimits = timerange.Limits{} }()
+
+	request := func(query string) (*gin.Context, *httptest.ResponseRecorder) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("GET", "/price/web?"+query, nil)
+		return c, recorder
+	}
+
+	c, _ := request("startTime=now-2h&endTime=now-1h&resolution=30m")
+	startTime, endTime, resolution, err := parseTimeRange(c)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, endTime.Sub(startTime))
+	assert.Equal(t, 30*time.Minute, resolution)
+
+	c, recorder := request("startTime=now-2d&endTime=now-1h")
+	startTime, endTime, resolution, err = parseTimeRange(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 23*time.Hour, endTime.Sub(startTime).Round(time.Minute))
+	assert.Equal(t, endTime.Sub(startTime), resolution)
+	assert.NotEmpty(t, recorder.Header().Get("Warning"))
+
+	for _, query := range []string{"startTime=now-1h&endTime=now-2h", "startTime=now-1h&endTime=now+1h", "startTime=now-10h&resolution=1m", "startTime=yesterday"} {
+		c, _ = request(query)
+		_, _, _, err = parseTimeRange(c)
+		assert.NotNil(t, err, query)
+	}
+}
+
+func TestSourceRetention(t *testing.T) {
+	day := 24 * time.Hour
+	downsampling := prometheus.Downsampling{RawRetention: 14 * day, FiveMinuteRetention: 90 * day}
+
+	// Prometheus keeps the data for -retention
+	assert.Equal(t, 15*day, sourceRetention(15*day, false, downsampling))
+
+	// Thanos keeps the data downsampled to 1h forever by default
+	downsampling.Enabled = true
+	assert.Equal(t, time.Duration(0), sourceRetention(15*day, false, downsampling))
+
+	downsampling.OneHourRetention = 365 * day
+	assert.Equal(t, 365*day, sourceRetention(15*day, false, downsampling))
+
+	// An explicit -retention wins
+	assert.Equal(t, 30*day, sourceRetention(30*day, true, downsampling))
+}
+
+func TestConcurrentNodeQueries(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+
+	concurrentCosts, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+
+	queryConcurrency = 1
+	sequentialCosts, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+
+	assert.Equal(t, len(sequentialCosts.Prices), len(concurrentCosts.Prices))
+	for pod, price := range sequentialCosts.Prices {
+		assert.InDelta(t, price, concurrentCosts.Prices[pod], epsilon, pod)
+	}
+}
+
+func TestClusterQueries(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+
+	nodeCosts, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+
+	clusterQueries = true
+	defer func() { clusterQueries = false }()
+	clusterCosts, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+
+	assert.Equal(t, len(nodeCosts.Prices), len(clusterCosts.Prices))
+	for pod, price := range nodeCosts.Prices {
+		assert.InDelta(t, price, clusterCosts.Prices[pod], epsilon, pod)
+	}
+	for node, idle := range nodeCosts.NodeIdle {
+		assert.InDelta(t, idle, clusterCosts.NodeIdle[node], epsilon, node)
+	}
+}
+
+func TestCostSnapshot(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleSeparate)
+
+	snapshot, err := getCostSnapshot(source, testStart, testStart.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, len(pricedNodes), len(snapshot.Nodes))
+
+	nodeCharged := make(map[string]float64)
+	for _, pod := range snapshot.Pods {
+		nodeCharged[pod.Node] += pod.CostPerHour
+		if pod.Pod == "web-6d4f-x2k9p" {
+			assert.Equal(t, "Deployment", pod.OwnerKind)
+			assert.Equal(t, "web", pod.Owner)
+			assert.Equal(t, "shop", pod.Namespace)
+		}
+	}
+
+	for _, node := range snapshot.Nodes {
+		assert.InDelta(t, node.PricePerHour, nodeCharged[node.Node]+node.IdlePerHour, epsilon)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func() { metricsSource = nil }()
+
+	ready := func() int {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("GET", "/readyz", nil)
+		getReadiness(c)
+		return recorder.Code
+	}
+
+	pricedNodes = nil
+	metricsSource = nil
+	assert.Equal(t, 503, ready())
+
+	metricsSource = setupFakeCluster(t, allocation.IdleNode)
+	assert.Equal(t, 200, ready())
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	source, err := prometheus.NewPrometheusSource(server.URL)
+	assert.Nil(t, err)
+	metricsSource = source
+	assert.Equal(t, 503, ready())
+	server.Close()
+}
+
+func TestEvaluateBudgets(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+	budgets := []budget.Budget{
+		{Name: "shop", Namespace: "shop", Amount: 10, Period: budget.PERIOD_DAY},
+		{Name: "web", Deployment: "web", Amount: 10, Period: budget.PERIOD_DAY},
+		{Name: "team-shop", Label: "team=shop", Amount: 10, Period: budget.PERIOD_DAY},
+	}
+
+	statuses, err := evaluateBudgets(source, budgets, testEnd.Add(30*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(statuses))
+
+	costs, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+
+	web := costs.Prices["web-6d4f-x2k9p"] + costs.Prices["web-6d4f-q8m2z"]
+	shop := web + costs.Prices["backup-27341-vb5nr"]
+	assert.InDelta(t, shop, statuses[0].Actual, epsilon)
+	assert.InDelta(t, web, statuses[1].Actual, epsilon)
+	assert.InDelta(t, shop, statuses[2].Actual, epsilon)
+
+	// Four of 24 hours have passed
+	assert.InDelta(t, 6*shop, statuses[0].Projected, epsilon)
+	assert.Equal(t, testStart.Add(24*time.Hour), statuses[0].PeriodEnd)
+}
+
+func TestStoredBudgets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	source := setupFakeCluster(t, allocation.IdleNode)
+	budgetConfig = budget.Config{Budgets: []budget.Budget{
+		{Name: "shop", Namespace: "shop", Amount: 10, Period: budget.PERIOD_DAY},
+		{Name: "web", Deployment: "web", Amount: 10, Period: budget.PERIOD_DAY},
+		{Name: "team-shop", Label: "team=shop", Amount: 10, Period: budget.PERIOD_DAY},
+	}}
+	defer func() { budgetConfig = budget.Config{} }()
+	now := testEnd.Add(30 * time.Minute)
+
+	live, err := evaluateBudgets(source, budgetConfig.Budgets, now)
+	assert.Nil(t, err)
+
+	costStore, err = store.Open(filepath.Join(t.TempDir(), "costs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		costStore.Close()
+		costStore = nil
+	}()
+
+	// The elapsed part of the period is read from the store, so Prometheus is not needed for it
+	assert.Nil(t, runBackfill(source, testStart, testEnd, time.Hour, 4*time.Hour))
+	stored, err := evaluateBudgets(failingSource{source}, budgetConfig.Budgets, now)
+	assert.Nil(t, err)
+	assert.Equal(t, len(live), len(stored))
+	for i := range live {
+		assert.InDelta(t, live[i].Actual, stored[i].Actual, epsilon)
+		assert.InDelta(t, live[i].Projected, stored[i].Projected, epsilon)
+	}
+
+	// The part the store does not have yet is computed live
+	partlyStored, err := evaluateBudgets(source, budgetConfig.Budgets, now.Add(2*time.Hour))
+	assert.Nil(t, err)
+	assert.Greater(t, partlyStored[0].Actual, stored[0].Actual)
+
+	// GET /budgets answers with the last evaluation until it is older than the evaluation interval
+	defer func(previous prometheus.MetricsSource) { metricsSource = previous }(metricsSource)
+	metricsSource = failingSource{source}
+	budgetStatuses = &budgetCache{maxAge: time.Hour}
+	defer func() { budgetStatuses = &budgetCache{maxAge: time.Hour} }()
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/budgets", nil)
+	getBudgets(c)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+
+	budgetStatuses.set(stored, time.Now())
+	recorder = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/budgets", nil)
+	getBudgets(c)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	statuses := []budget.Status{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &statuses))
+	assert.Equal(t, len(stored), len(statuses))
+	assert.InDelta(t, stored[0].Actual, statuses[0].Actual, epsilon)
+
+	budgetStatuses.set(stored, time.Now().Add(-2*time.Hour))
+	recorder = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/budgets", nil)
+	getBudgets(c)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+}
+
+func TestDeploymentForecasts(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+
+	forecasts, err := getDeploymentForecasts(source, testStart, testEnd, time.Hour, testStart, testStart.Add(8*time.Hour))
+	assert.Nil(t, err)
+
+	prices, err := getDeploymentPrice(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, len(prices), len(forecasts))
+
+	for _, item := range forecasts {
+		assert.InDelta(t, prices[item.Deployment], item.Actual, epsilon)
+		assert.GreaterOrEqual(t, item.Forecast, item.Actual)
+		assert.LessOrEqual(t, item.Lower, item.Forecast)
+		assert.GreaterOrEqual(t, item.Upper, item.Forecast)
+		assert.False(t, item.Seasonal)
+	}
+}
+
+func TestPeriodForecastsBeyondRetention(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+	january := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	february := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2022, 1, 12, 0, 0, 0, 0, time.UTC)
+
+	// Prometheus only has the month from half an hour before the second of January
+	timeLimits = timerange.Limits{Retention: 10*24*time.Hour + 30*time.Minute}
+	defer func() { timeLimits = timerange.Limits{} }()
+	historyStart := now.Add(-timeLimits.Retention)
+
+	prices, err := getDeploymentPrice(source, january, now, time.Hour)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, prices)
+
+	forecasts, err := getPeriodForecasts(source, historyStart, now, time.Hour, january, february)
+	assert.Nil(t, err)
+	assert.Equal(t, len(prices), len(forecasts))
+	for _, item := range forecasts {
+		assert.True(t, item.Partial)
+		assert.Less(t, item.Actual, prices[item.Deployment])
+	}
+
+	costStore, err = store.Open(filepath.Join(t.TempDir(), "costs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		costStore.Close()
+		costStore = nil
+	}()
+
+	// The store misses the first hours of the month
+	assert.Nil(t, runBackfill(source, january.Add(6*time.Hour), january.Add(24*time.Hour), time.Hour, 24*time.Hour))
+	forecasts, err = getPeriodForecasts(source, historyStart, now, time.Hour, january, february)
+	assert.Nil(t, err)
+	for _, item := range forecasts {
+		assert.True(t, item.Partial)
+	}
+
+	assert.Nil(t, runBackfill(source, january, january.Add(6*time.Hour), time.Hour, 24*time.Hour))
+	forecasts, err = getPeriodForecasts(source, historyStart, now, time.Hour, january, february)
+	assert.Nil(t, err)
+	assert.Equal(t, len(prices), len(forecasts))
+	for _, item := range forecasts {
+		assert.False(t, item.Partial)
+		assert.InDelta(t, prices[item.Deployment], item.Actual, epsilon)
+		assert.LessOrEqual(t, item.Lower, item.Forecast)
+		assert.GreaterOrEqual(t, item.Upper, item.Forecast)
+	}
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	setupFakeCluster(t, allocation.IdleNode)
+	usage := func(spikeHour int) []prometheus.FakeUsage {
+		samples := []prometheus.FakeUsage{{Time: testStart, CPU: 0.5, Memory: 2e9}}
+		if spikeHour > 0 {
+			spike := testStart.Add(time.Duration(spikeHour) * time.Hour)
+			samples = append(samples,
+				prometheus.FakeUsage{Time: spike, CPU: 3, Memory: 12e9},
+				prometheus.FakeUsage{Time: spike.Add(time.Hour), CPU: 0.5, Memory: 2e9})
+		}
+		return samples
+	}
+
+	source := &prometheus.FakeSource{
+		Nodes: []prometheus.FakeNode{{Name: "node-1", Price: 1, CPU: 4, Memory: 16e9}},
+		Pods: []prometheus.FakePod{
+			{Name: "web-1", Namespace: "shop", Node: "node-1", Owner: prometheus.FakeOwner{Kind: "ReplicaSet", Name: "web-1x"}, Usage: usage(10)},
+			{Name: "api-1", Namespace: "blog", Node: "node-1", Owner: prometheus.FakeOwner{Kind: "ReplicaSet", Name: "api-1x"}, Usage: usage(0)},
+		},
+		ReplicaSets: map[string]string{"web-1x": "web", "api-1x": "api"},
+	}
+	pricedNodes = []kubernetes.PricedNode{{Node: v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}, Price: 1}}
+
+	state := newAnomalyState(24*time.Hour, anomaly.Detector{Threshold: 3.5, MinIncrease: 0.2, MinHistory: 5})
+
+	found, err := state.detect(source, testStart.Add(8*time.Hour))
+	assert.Nil(t, err)
+	assert.Empty(t, found)
+
+	// The spike lasts for the step ending at the tenth hour and is found by the next check
+	found, err = state.detect(source, testStart.Add(12*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "web", found[0].Deployment)
+	assert.Equal(t, testStart.Add(10*time.Hour), found[0].Time)
+	assert.Equal(t, []string{"node-1"}, found[0].Nodes)
+	assert.Equal(t, "web-1", found[0].Pods[0].Pod)
+	assert.Equal(t, 1, len(state.anomalies))
+
+	// Hours already checked are not checked again, and the anomaly is kept
+	found, err = state.detect(source, testStart.Add(12*time.Hour))
+	assert.Nil(t, err)
+	assert.Empty(t, found)
+	assert.Equal(t, 1, len(state.anomalies))
+}
+
+func TestRightsizingRecommendations(t *testing.T) {
+	setupFakeCluster(t, allocation.IdleNode)
+	steady := []prometheus.FakeUsage{{Time: testStart, CPU: 0.2, Memory: 1e9}}
+	source := &prometheus.FakeSource{
+		Nodes: []prometheus.FakeNode{{Name: "node-1", Price: 2, CPU: 4, Memory: 16e9}, {Name: "node-2", CPU: 4, Memory: 16e9}},
+		Pods: []prometheus.FakePod{
+			{Name: "web-1", Namespace: "shop", Node: "node-1", Containers: []prometheus.FakeContainer{
+				{Name: "app", Requests: prometheus.Resources{CPU: 2, Memory: 4e9}, Limits: prometheus.Resources{CPU: 0.1, Memory: 1e9}, Usage: steady},
+			}},
+			{Name: "api-1", Namespace: "blog", Node: "node-2", Containers: []prometheus.FakeContainer{
+				{Name: "app", Requests: prometheus.Resources{CPU: 1, Memory: 2e9}, Usage: steady},
+			}},
+		},
+	}
+	pricedNodes = []kubernetes.PricedNode{{Node: v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}, Price: 2}}
+
+	recommendations, err := getRightsizingRecommendations(source, testEnd, testEnd.Sub(testStart))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(recommendations))
+
+	web := recommendations[0]
+	assert.Equal(t, "web-1", web.Pod)
+	assert.Equal(t, "node-1", web.Node)
+	assert.InDelta(t, 0.2, web.Usage.CPUP99, epsilon)
+	assert.InDelta(t, 0.23, web.Proposed.CPU, epsilon)
+	assert.Equal(t, float64(1097*rightsizing.MEBIBYTE), web.Proposed.Memory)
+	// Half of the node price goes to the CPU and half to the memory
+	expected := 2 * rightsizing.HOURS_PER_MONTH * (0.5*(2-0.23)/4 + 0.5*(4e9-web.Proposed.Memory)/16e9)
+	assert.InDelta(t, expected, web.MonthlySavings, 1e-6)
+	assert.Equal(t, "SEK", web.Currency)
+	assert.Equal(t, []string{"The proposed CPU request is above the CPU limit", "The memory usage is close to the memory limit"}, web.Notes)
+
+	// The savings of containers on nodes without a price are unknown
+	api := recommendations[1]
+	assert.Equal(t, "api-1", api.Pod)
+	assert.Equal(t, 0.0, api.MonthlySavings)
+	assert.Equal(t, 1, len(api.Notes))
+}
+
+func testNode(name string, instanceType string, cpu string, memory string, price float64) kubernetes.PricedNode {
+	return kubernetes.PricedNode{
+		Node: v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+				azure.LABEL_AZURE_INSTANCE_TYPE: instanceType,
+				"kubernetes.io/hostname":        name,
+			}},
+			Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(memory),
+				v1.ResourcePods:   resource.MustParse("30"),
+			}},
+		},
+		Price: price,
+	}
+}
+
+func testPod(name string, node string, cpu string, memory string, owner string) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", OwnerReferences: []metav1.OwnerReference{{Kind: owner, Name: "owner"}}},
+		Spec: v1.PodSpec{
+			NodeName: node,
+			Containers: []v1.Container{{Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(memory),
+			}}}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+func TestSimulateConsolidation(t *testing.T) {
+	nodes := []kubernetes.PricedNode{
+		testNode("node-1", "Standard_D4s_v3", "4", "16Gi", 2),
+		testNode("node-2", "Standard_D4s_v3", "4", "16Gi", 2),
+		testNode("node-3", "Standard_D4s_v3", "4", "16Gi", 2),
+	}
+	pods := []v1.Pod{
+		testPod("web-1", "node-1", "1", "2Gi", "ReplicaSet"),
+		testPod("web-2", "node-2", "1", "2Gi", "ReplicaSet"),
+		testPod("api-1", "node-3", "500m", "1Gi", "ReplicaSet"),
+		testPod("proxy-1", "node-1", "500m", "1Gi", "DaemonSet"),
+		testPod("proxy-2", "node-2", "500m", "1Gi", "DaemonSet"),
+	}
+	done := testPod("job-1", "node-3", "4", "16Gi", "Job")
+	done.Status.Phase = v1.PodSucceeded
+	pods = append(pods, done)
+
+	response, err := simulateConsolidation(nodes, pods, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 6.0, response.CurrentPricePerHour)
+	assert.Equal(t, map[string]int{"Standard_D4s_v3": 3}, response.CurrentNodes)
+	assert.Equal(t, rightsizing.Resources{CPU: 0.5, Memory: 1024 * 1024 * 1024}, response.NodeOverhead)
+	assert.Equal(t, map[string]int{"Standard_D4s_v3": 1}, response.ProposedNodes)
+	assert.Equal(t, []string{"shop/api-1", "shop/web-1", "shop/web-2"}, response.Plan.Nodes[0].Pods)
+	assert.InDelta(t, 4*rightsizing.HOURS_PER_MONTH, response.MonthlySavings, epsilon)
+
+	// The hostname label differs between the nodes, so it is not a label of the instance type
+	types := currentInstanceTypes(nodes)
+	assert.Equal(t, 1, len(types))
+	assert.Equal(t, map[string]string{azure.LABEL_AZURE_INSTANCE_TYPE: "Standard_D4s_v3"}, types[0].Labels)
+
+	// A cheaper candidate that fits the workload replaces the current instance type
+	candidate := consolidation.InstanceType{Name: "Standard_D2s_v3", CPU: 2, Memory: 8 * 1024 * 1024 * 1024, MaxPods: 30, Price: 0.9}
+	response, err = simulateConsolidation(nodes, pods, []consolidation.InstanceType{candidate})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"Standard_D2s_v3": 2}, response.ProposedNodes)
+	assert.InDelta(t, 1.8, response.ProposedPricePerHour, epsilon)
+
+	// The proxy pod of every node uses up the pod limit of a candidate allowing a single pod, while a candidate without a limit gets the kubelet default
+	full := consolidation.InstanceType{Name: "Standard_B4ms", CPU: 4, Memory: 16 * 1024 * 1024 * 1024, MaxPods: 1, Price: 0.1}
+	unlimited := consolidation.InstanceType{Name: "Standard_D4as_v4", CPU: 4, Memory: 16 * 1024 * 1024 * 1024, Price: 1.5}
+	response, err = simulateConsolidation(nodes, pods, []consolidation.InstanceType{full, unlimited})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"Standard_D4as_v4": 1}, response.ProposedNodes)
+	assert.Empty(t, response.Plan.Unscheduled)
+	assert.False(t, response.Infeasible)
+
+	// A pod that fits no instance type is not counted as savings
+	huge := testPod("batch-1", "node-3", "8", "16Gi", "ReplicaSet")
+	response, err = simulateConsolidation(nodes, append(pods, huge), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"shop/batch-1"}, response.Plan.Unscheduled)
+	assert.True(t, response.Infeasible)
+	assert.Less(t, response.ProposedPricePerHour, response.CurrentPricePerHour)
+	assert.Equal(t, 0.0, response.MonthlySavings)
+}
+
+func TestConsolidationNodePools(t *testing.T) {
+	pool := func(node kubernetes.PricedNode, name string, labels map[string]string) kubernetes.PricedNode {
+		node.Node.Labels[azure.LABEL_AZURE_AGENT_POOL] = name
+		for key, value := range labels {
+			node.Node.Labels[key] = value
+		}
+		return node
+	}
+	nodes := []kubernetes.PricedNode{
+		pool(testNode("node-1", "Standard_D4s_v3", "4", "16Gi", 2), "apps", nil),
+		pool(testNode("node-2", "Standard_D4s_v3", "4", "16Gi", 2), "batch", map[string]string{"workload": "batch"}),
+		pool(testNode("node-3", "Standard_D4s_v3", "4", "16Gi", 2), "batch", map[string]string{"workload": "batch"}),
+	}
+
+	// Two untainted pools of the same instance type keep their own labels
+	types := currentInstanceTypes(nodes)
+	assert.Equal(t, 2, len(types))
+	assert.Equal(t, "Standard_D4s_v3 (pool apps)", types[0].Name)
+	assert.Equal(t, "Standard_D4s_v3 (pool batch)", types[1].Name)
+	assert.Equal(t, "batch", types[1].Labels["workload"])
+	assert.Equal(t, "batch", types[1].Labels[azure.LABEL_AZURE_AGENT_POOL])
+
+	job := testPod("job-1", "node-2", "1", "2Gi", "Job")
+	job.Spec.NodeSelector = map[string]string{"workload": "batch"}
+	web := testPod("web-1", "node-1", "1", "2Gi", "ReplicaSet")
+	web.Spec.NodeSelector = map[string]string{azure.LABEL_AZURE_AGENT_POOL: "apps"}
+
+	response, err := simulateConsolidation(nodes, []v1.Pod{job, web}, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, response.Plan.Unscheduled)
+	assert.Equal(t, map[string]int{"Standard_D4s_v3 (pool apps)": 1, "Standard_D4s_v3 (pool batch)": 1}, response.ProposedNodes)
+	assert.InDelta(t, 2*rightsizing.HOURS_PER_MONTH, response.MonthlySavings, epsilon)
+}
+
+func TestPostEstimate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	manifest, err := ioutil.ReadFile("estimate/testdata/shop.yaml")
+	assert.Nil(t, err)
+
+	pricedNodes = []kubernetes.PricedNode{
+		testNode("node-1", "Standard_D4s_v3", "4", "16Gi", 2),
+		testNode("node-2", "Standard_D8s_v3", "8", "32Gi", 4),
+	}
+
+	post := func(query string) (EstimateResponse, *httptest.ResponseRecorder) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("POST", "/estimate?"+query, bytes.NewReader(manifest))
+		postEstimate(c)
+
+		response := EstimateResponse{}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return response, recorder
+	}
+
+	// A replica of web requests a quarter of the CPU and 1152Mi of the memory of node-1, and half of that of node-2 which costs twice as much
+	response, recorder := post("")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "cluster", response.Nodes)
+	assert.Equal(t, 2, len(response.Estimates))
+	perReplica := 2 * rightsizing.HOURS_PER_MONTH * (0.5*0.25 + 0.5*1152.0/(16*1024))
+	assert.InDelta(t, perReplica, response.Estimates[0].PerReplica, 1e-6)
+	assert.InDelta(t, 3*perReplica, response.MonthlyCost, 1e-6)
+	assert.Equal(t, 1, len(response.Estimates[1].Notes))
+
+	// The capacity and price of a chosen instance type are taken from a node of the type
+	response, recorder = post("sku=Standard_D8s_v3")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "Standard_D8s_v3", response.Nodes)
+	assert.InDelta(t, perReplica, response.Estimates[0].PerReplica, 1e-6)
+
+	response, recorder = post("sku=Standard_E4s_v3&cpu=4&memory=32Gi&price=3")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.InDelta(t, 3*rightsizing.HOURS_PER_MONTH*(0.5*0.25+0.5*1152.0/(32*1024)), response.Estimates[0].PerReplica, 1e-6)
+
+	_, recorder = post("sku=Standard_E4s_v3&price=3")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// Without a region the price is not looked up in whatever region the Azure prices answer with first
+	_, _, err = getEstimateNodes(nil, "Standard_E4s_v3", "4", "32Gi", "", "")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "region")
+
+	_, err = priceSheetInstanceTypes(estimate.PriceSheet{Nodes: []estimate.PriceSheetNode{{InstanceType: "Standard_E4s_v3", CPU: "4", Memory: "32Gi"}}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "region")
+}
+
+/*
+ * Compares the Markdown cost diff of the manifests in testdata/diff with the golden file. Run with -update to rewrite the golden file
+ */
+func TestCostDiff(t *testing.T) {
+	sheet, err := estimate.LoadPriceSheet("testdata/diff/prices.yaml")
+	assert.Nil(t, err)
+	nodes, err := priceSheetInstanceTypes(sheet)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(nodes))
+
+	response, err := getCostDiff("testdata/diff/before", "testdata/diff/after", nodes, "prices.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(response.Workloads))
+	assert.InDelta(t, response.After-response.Before, response.Delta, epsilon)
+
+	// The db StatefulSet selects the ssd node, the only one it can run on
+	db := response.Workloads[0]
+	assert.Equal(t, estimate.STATUS_REMOVED, db.Status)
+	assert.InDelta(t, 5*rightsizing.HOURS_PER_MONTH*(0.5*2/8+0.5*8.0/64), -db.Delta, 1e-6)
+
+	var markdown bytes.Buffer
+	assert.Nil(t, writeDiffMarkdown(&markdown, response))
+
+	goldenFile := "testdata/golden/diff.md"
+	if *update {
+		if err := ioutil.WriteFile(goldenFile, markdown.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	golden, err := ioutil.ReadFile(goldenFile)
+	assert.Nil(t, err)
+	assert.Equal(t, string(golden), markdown.String())
+}
+
+func TestCostReport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	source := setupFakeCluster(t, allocation.IdleNode)
+	metricsSource = source
+
+	costs, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+
+	// The cost of every pod is split into its CPU and memory usage and the unused node capacity it is charged for
+	for pod, price := range costs.Prices {
+		assert.InDelta(t, price, costs.UsageCPU[pod]+costs.UsageMemory[pod]+costs.Wasted[pod], epsilon)
+	}
+
+	namespaces, err := getCostReport(source, testStart, testEnd, time.Hour, REPORT_NAMESPACE, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Namespace"}, namespaces.GroupBy)
+	assert.Len(t, namespaces.Rows, 3)
+	assert.Equal(t, []string{"blog"}, namespaces.Rows[0].Group)
+	assert.InDelta(t, sumPrices(costs.Prices), namespaces.Total().Total(), epsilon)
+	assert.Greater(t, namespaces.Total().Waste, 0.0)
+	assert.Equal(t, 0.0, namespaces.Total().Idle)
+
+	deployments, err := getCostReport(source, testStart, testEnd, time.Hour, REPORT_DEPLOYMENT, nil)
+	assert.Nil(t, err)
+	assert.Len(t, deployments.Rows, 3)
+	assert.Equal(t, []string{"shop", "web"}, deployments.Rows[2].Group)
+	assert.InDelta(t, costs.Prices["web-6d4f-x2k9p"]+costs.Prices["web-6d4f-q8m2z"], deployments.Rows[2].Total(), epsilon)
+
+	// Pods only pay for their usage, and the idle cost is a row of its own
+	setupFakeCluster(t, allocation.IdleSeparate)
+	labels, err := getCostReport(source, testStart, testEnd, time.Hour, REPORT_LABEL, []string{"team"})
+	assert.Nil(t, err)
+	assert.Len(t, labels.Rows, 4)
+	assert.Equal(t, []string{allocation.UNLABELLED}, labels.Rows[2].Group)
+	idle := labels.Rows[3]
+	assert.Equal(t, []string{allocation.IDLE_WORKLOAD}, idle.Group)
+	assert.Greater(t, idle.Idle, 0.0)
+	assert.InDelta(t, clusterPrice(4), labels.Total().Total(), epsilon)
+	assert.Equal(t, 0.0, labels.Total().Waste)
+
+	// The idle cost is part of the cost of the namespaces
+	setupFakeCluster(t, allocation.IdleRedistribute)
+	namespaces, err = getCostReport(source, testStart, testEnd, time.Hour, REPORT_NAMESPACE, nil)
+	assert.Nil(t, err)
+	assert.InDelta(t, clusterPrice(4), namespaces.Total().Total(), epsilon)
+	assert.InDelta(t, idle.Idle, namespaces.Total().Idle, epsilon)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/report/namespace?startTime=2021-12-24T00:00:00Z&endTime=2021-12-24T04:00:00Z&resolution=1h&format=xlsx", nil)
+	getNamespaceReport(c)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="costs-namespace-2021-12-24-2021-12-24.xlsx"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "PK", recorder.Body.String()[:2])
+
+	recorder = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", "/report/namespace?startTime=2021-12-24T00:00:00Z&format=pdf", nil)
+	getNamespaceReport(c)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	path := filepath.Join(t.TempDir(), "report.csv")
+	assert.Nil(t, writeCostReportFile(source, path, "label:team,env", "2021-12-24T00:00:00Z", "2021-12-24T04:00:00Z"))
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "team,env,CPU cost,Memory cost,Waste,Idle share,Total,Currency,Period start,Period end\n"))
+
+	_, _, err = parseReportGroup("label:")
+	assert.NotNil(t, err)
+}
+
+func TestSharedCostReport(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+	sharedConfig = allocation.SharedConfig{Namespaces: []string{"kube-system"}, Policy: allocation.ShareEven}
+	defer func() { sharedConfig = allocation.SharedConfig{} }()
+
+	costs, err := getPodCosts(source, testStart, testEnd, time.Hour)
+	assert.Nil(t, err)
+	sharedCost := costs.Prices["coredns-7b9d-mm2lp"]
+
+	// Every tenant deployment has a line with its shared overhead, and the shared deployment has none
+	deployments, err := getCostReport(source, testStart, testEnd, time.Hour, REPORT_DEPLOYMENT, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Namespace", "Deployment", "Cost type"}, deployments.GroupBy)
+	rows := make(map[string]report.Row)
+	for _, row := range deployments.Rows {
+		assert.NotEqual(t, "kube-system", row.Group[0])
+		rows[strings.Join(row.Group, "/")] = row
+	}
+	assert.Len(t, rows, 4)
+	assert.InDelta(t, costs.Prices["api-5c8b-7hd4k"], rows["blog/api/"+REPORT_COST_USAGE].Total(), epsilon)
+	assert.InDelta(t, sharedCost/2, rows["blog/api/"+REPORT_COST_SHARED].Total(), epsilon)
+	assert.Greater(t, rows["shop/web/"+REPORT_COST_SHARED].CPU, 0.0)
+
+	labels, err := getCostReport(source, testStart, testEnd, time.Hour, REPORT_LABEL, []string{"team"})
+	assert.Nil(t, err)
+	assert.Len(t, labels.Rows, 4)
+	assert.Equal(t, []string{"blog", REPORT_COST_SHARED}, labels.Rows[0].Group)
+	assert.Equal(t, []string{"blog", REPORT_COST_USAGE}, labels.Rows[1].Group)
+	assert.InDelta(t, sharedCost/2, labels.Rows[0].Total(), epsilon)
+	assert.InDelta(t, sumPrices(costs.Prices), labels.Total().Total(), epsilon)
+
+	// Without tenants the shared cost is a line of its own
+	sharedConfig.Namespaces = []string{"kube-system", "shop", "blog"}
+	namespaces, err := getCostReport(source, testStart, testEnd, time.Hour, REPORT_NAMESPACE, nil)
+	assert.Nil(t, err)
+	assert.Len(t, namespaces.Rows, 1)
+	assert.Equal(t, []string{allocation.SHARED_WORKLOAD, REPORT_COST_SHARED}, namespaces.Rows[0].Group)
+	assert.InDelta(t, sumPrices(costs.Prices), namespaces.Total().Total(), epsilon)
+}
+
+func TestGenerateChargeback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	source := setupFakeCluster(t, allocation.IdleSeparate)
+	archive := chargeback.NewArchive(chargeback.NewDirectoryStorage(t.TempDir()))
+	now := time.Date(2022, 1, 1, 3, 0, 0, 0, time.UTC)
+
+	entry, generated, err := generateDueChargeback(source, archive, "team", now)
+	assert.Nil(t, err)
+	assert.True(t, generated)
+	assert.Equal(t, "2021-12-team", entry.ID)
+	assert.Equal(t, time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC), entry.Start)
+
+	// The idle cost of the whole month is a cost centre of its own
+	assert.InDelta(t, clusterPrice(31*24), entry.Total, 1e-6)
+
+	// The report of the month is only generated once
+	_, generated, err = generateDueChargeback(source, archive, "team", now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, generated)
+
+	get := func(path string, handler gin.HandlerFunc, params ...gin.Param) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("GET", path, nil)
+		c.Params = params
+		handler(c)
+		return recorder
+	}
+
+	chargebackArchive = nil
+	assert.Equal(t, http.StatusNotFound, get("/chargebacks", getChargebacks).Code)
+
+	chargebackArchive = archive
+	defer func() { chargebackArchive = nil }()
+
+	recorder := get("/chargebacks", getChargebacks)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	entries := []chargeback.Entry{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &entries))
+	assert.Len(t, entries, 1)
+
+	recorder = get("/chargebacks/2021-12-team/csv", getChargeback, gin.Param{Key: "id", Value: "2021-12-team"}, gin.Param{Key: "format", Value: "csv"})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "shop,")
+	assert.Contains(t, recorder.Body.String(), allocation.IDLE_WORKLOAD+",")
+
+	recorder = get("/chargebacks/2021-11-team/csv", getChargeback, gin.Param{Key: "id", Value: "2021-11-team"}, gin.Param{Key: "format", Value: "csv"})
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestGenerateChargebackBeyondRetention(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleSeparate)
+	archive := chargeback.NewArchive(chargeback.NewDirectoryStorage(t.TempDir()))
+	december := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	january := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2022, 1, 20, 0, 0, 0, 0, time.UTC)
+
+	// Prometheus no longer has the first days of December
+	timeLimits = timerange.Limits{Retention: 15 * 24 * time.Hour}
+	defer func() { timeLimits = timerange.Limits{} }()
+
+	_, generated, err := generateDueChargeback(source, archive, "team", now)
+	assert.NotNil(t, err)
+	assert.False(t, generated)
+
+	entries, err := archive.Index()
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	costStore, err = store.Open(filepath.Join(t.TempDir(), "costs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		costStore.Close()
+		costStore = nil
+	}()
+
+	// A month the store only partly covers is not archived either
+	assert.Nil(t, runBackfill(source, december.Add(24*time.Hour), january, time.Hour, 24*time.Hour))
+	_, generated, err = generateDueChargeback(source, archive, "team", now)
+	assert.NotNil(t, err)
+	assert.False(t, generated)
+
+	assert.Nil(t, runBackfill(source, december, december.Add(24*time.Hour), time.Hour, 24*time.Hour))
+	entry, generated, err := generateDueChargeback(source, archive, "team", now)
+	assert.Nil(t, err)
+	assert.True(t, generated)
+	assert.Equal(t, "2021-12-team", entry.ID)
+	assert.Equal(t, december, entry.Start)
+	assert.InDelta(t, clusterPrice(31*24), entry.Total, 1e-6)
+
+	// The stored month is charged like the live month
+	live, err := getCostReport(source, december, january, time.Hour, REPORT_LABEL, []string{"team"})
+	assert.Nil(t, err)
+	stored, err := getStoredChargebackReport(source, december, january, "team", now)
+	assert.Nil(t, err)
+	assert.Equal(t, len(live.Rows), len(stored.Rows))
+	for i := range live.Rows {
+		assert.Equal(t, live.Rows[i].Group, stored.Rows[i].Group)
+		assert.InDelta(t, live.Rows[i].Total(), stored.Rows[i].Total(), 1e-6)
+		assert.InDelta(t, live.Rows[i].CPU, stored.Rows[i].CPU, 1e-6)
+	}
+}
+
+// A source whose Prometheus is down
+type failingSource struct {
+	*prometheus.FakeSource
+}
+
+func (f failingSource) GetAvgPodResourceUsageOverTime(node string, startTime time.Time, endTime time.Time, resolution time.Duration) ([]prometheus.ResourceUsageSample, promv1.Warnings, error) {
+	return nil, nil, fmt.Errorf("Prometheus is down")
+}
+
+func TestBackfillUnalignedEnd(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+	var err error
+	costStore, err = store.Open(filepath.Join(t.TempDir(), "costs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		costStore.Close()
+		costStore = nil
+	}()
+
+	// The last chunk is 30 minutes, shorter than a step. It is left out instead of overwriting the window before it
+	end := testStart.Add(3*time.Hour + 30*time.Minute)
+	assert.Nil(t, runBackfill(source, testStart, end, time.Hour, 3*time.Hour))
+
+	windows, err := costStore.GetWindows(testStart, end)
+	assert.Nil(t, err)
+	assert.Len(t, windows, 3)
+	for i, window := range windows {
+		assert.Equal(t, testStart.Add(time.Duration(i)*time.Hour), window.Start)
+		assert.Equal(t, time.Hour, window.End.Sub(window.Start))
+	}
+
+	expected, err := computeWindows(source, testStart.Add(2*time.Hour), testStart.Add(3*time.Hour), time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, expected[0].Pods, windows[2].Pods)
+
+	assert.NotNil(t, runBackfill(source, testStart, testStart.Add(30*time.Minute), time.Hour, time.Hour))
+}
+
+func TestStoreDueWindows(t *testing.T) {
+	source := setupFakeCluster(t, allocation.IdleNode)
+	var err error
+	costStore, err = store.Open(filepath.Join(t.TempDir(), "costs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		costStore.Close()
+		costStore = nil
+	}()
+
+	storeGranularity = time.Hour
+	timeLimits = timerange.Limits{Retention: 2 * time.Hour}
+	defer func() { timeLimits = timerange.Limits{} }()
+
+	// The windows of the day before are older than the retention and skipped
+	next, err := storeDueWindows(source, testStart.Add(-24*time.Hour), testEnd)
+	assert.Nil(t, err)
+	assert.Equal(t, testEnd, next)
+
+	windows, err := costStore.GetWindows(testStart, testEnd)
+	assert.Nil(t, err)
+	assert.Len(t, windows, 2)
+	assert.Equal(t, testEnd.Add(-2*time.Hour), windows[0].Start)
+
+	// A failing window is not skipped, it is retried from the same start
+	next, err = storeDueWindows(failingSource{source}, testEnd, testEnd.Add(2*time.Hour))
+	assert.NotNil(t, err)
+	assert.Equal(t, testEnd, next)
+}
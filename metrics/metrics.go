@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The metrics costestimation exposes about itself, to find out where the time of a slow request goes
+var (
+	PrometheusQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "costestimation_prometheus_query_duration_seconds",
+		Help:    "Duration of the queries to Prometheus, by query.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 4, 8),
+	}, []string{"query"})
+	PrometheusQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "costestimation_prometheus_query_errors_total",
+		Help: "Failed queries to Prometheus, by query and error type.",
+	}, []string{"query", "type"})
+	AzurePricingDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "costestimation_azure_pricing_request_duration_seconds",
+		Help:    "Duration of the requests to the Azure Retail Prices API.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+	AzurePricingCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "costestimation_azure_pricing_cache_requests_total",
+		Help: "Lookups of Azure prices, by whether the price was cached.",
+	}, []string{"result"})
+	NodeCostDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "costestimation_node_cost_duration_seconds",
+		Help:    "Duration of querying and pricing the usage of the pods on a node.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+	}, []string{"node"})
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "costestimation_http_request_duration_seconds",
+		Help:    "Duration of the HTTP requests, by route, method and status code.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+	}, []string{"route", "method", "code"})
+)
+
+/*
+ * Registers the metrics with the registerer
+ */
+func Register(registerer prometheus.Registerer) {
+	registerer.MustRegister(
+		PrometheusQueryDuration,
+		PrometheusQueryErrors,
+		AzurePricingDuration,
+		AzurePricingCache,
+		NodeCostDuration,
+		HTTPRequestDuration,
+	)
+}
+
+/*
+ * Records the duration of a Prometheus query started at start, and the type of its error if it failed
+ */
+func ObservePrometheusQuery(query string, start time.Time, err error) {
+	PrometheusQueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		PrometheusQueryErrors.WithLabelValues(query, ErrorType(err)).Inc()
+	}
+}
+
+/*
+ * Returns the type of a Prometheus API error, e.g. timeout or bad_data, and "other" for other errors than timeouts not returned by the API
+ */
+func ErrorType(err error) string {
+	var apiError *promv1.Error
+
+	if errors.As(err, &apiError) {
+		return string(apiError.Type)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return string(promv1.ErrTimeout)
+	}
+
+	return "other"
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorType(t *testing.T) {
+	assert.Equal(t, "bad_data", ErrorType(&promv1.Error{Type: promv1.ErrBadData}))
+	assert.Equal(t, "timeout", ErrorType(fmt.Errorf("query: %w", context.DeadlineExceeded)))
+	assert.Equal(t, "other", ErrorType(errors.New("connection refused")))
+}
+
+func TestObservePrometheusQuery(t *testing.T) {
+	ObservePrometheusQuery("podLabels", time.Now(), nil)
+	ObservePrometheusQuery("podLabels", time.Now(), &promv1.Error{Type: promv1.ErrTimeout})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(PrometheusQueryErrors.WithLabelValues("podLabels", "timeout")))
+	assert.Equal(t, 1, testutil.CollectAndCount(PrometheusQueryDuration))
+}
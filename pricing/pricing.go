@@ -7,8 +7,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
+
+	"dat067/costestimation/metrics"
 )
 
 const AZURE_HOST = "https://prices.azure.com/api/retail/prices"
@@ -136,40 +140,40 @@ func (q *QueryFilter) String() (string, error) {
 	params.Add("currencyCode", currency)
 
 	if q.ArmRegionName != "" {
-		builder.WriteString(fmt.Sprintf("armRegionName eq '%s' and ", q.ArmRegionName))
+		builder.WriteString(fmt.Sprintf("armRegionName eq %s and ", odataString(q.ArmRegionName)))
 	}
 	if q.ArmSkuName != "" {
-		builder.WriteString(fmt.Sprintf("armSkuName eq '%s' and ", q.ArmSkuName))
+		builder.WriteString(fmt.Sprintf("armSkuName eq %s and ", odataString(q.ArmSkuName)))
 	}
 	if q.Location != "" {
-		builder.WriteString(fmt.Sprintf("location eq '%s' and ", q.Location))
+		builder.WriteString(fmt.Sprintf("location eq %s and ", odataString(q.Location)))
 	}
 	if q.MeterId != "" {
-		builder.WriteString(fmt.Sprintf("meterId eq '%s' and ", q.MeterId))
+		builder.WriteString(fmt.Sprintf("meterId eq %s and ", odataString(q.MeterId)))
 	}
 	if q.PriceType != "" {
-		builder.WriteString(fmt.Sprintf("priceType eq '%s' and ", q.PriceType))
+		builder.WriteString(fmt.Sprintf("priceType eq %s and ", odataString(q.PriceType)))
 	}
 	if q.ProductId != "" {
-		builder.WriteString(fmt.Sprintf("productId eq '%s' and ", q.ProductId))
+		builder.WriteString(fmt.Sprintf("productId eq %s and ", odataString(q.ProductId)))
 	}
 	if q.ProductName != "" {
-		builder.WriteString(fmt.Sprintf("productName eq '%s' and ", q.ProductName))
+		builder.WriteString(fmt.Sprintf("productName eq %s and ", odataString(q.ProductName)))
 	}
 	if q.ServiceFamily != "" {
-		builder.WriteString(fmt.Sprintf("serviceFamily eq '%s' and ", q.ServiceFamily))
+		builder.WriteString(fmt.Sprintf("serviceFamily eq %s and ", odataString(q.ServiceFamily)))
 	}
 	if q.ServiceId != "" {
-		builder.WriteString(fmt.Sprintf("serviceId eq '%s' and ", q.ServiceId))
+		builder.WriteString(fmt.Sprintf("serviceId eq %s and ", odataString(q.ServiceId)))
 	}
 	if q.ServiceName != "" {
-		builder.WriteString(fmt.Sprintf("serviceName eq '%s' and ", q.ServiceName))
+		builder.WriteString(fmt.Sprintf("serviceName eq %s and ", odataString(q.ServiceName)))
 	}
 	if q.SkuId != "" {
-		builder.WriteString(fmt.Sprintf("skuId eq '%s' and ", q.SkuId))
+		builder.WriteString(fmt.Sprintf("skuId eq %s and ", odataString(q.SkuId)))
 	}
 	if q.SkuName != "" {
-		builder.WriteString(fmt.Sprintf("skuName eq '%s'", q.SkuName))
+		builder.WriteString(fmt.Sprintf("skuName eq %s", odataString(q.SkuName)))
 	}
 
 	finalFilterString := builder.String()
@@ -185,6 +189,11 @@ func (q *QueryFilter) String() (string, error) {
 	return params.Encode(), nil
 }
 
+// Quotes the value as an OData string literal, in which a quote is written as two quotes
+func odataString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
 type CostApi interface {
 	Query(q QueryFilter) (QueryResponse, error)
 }
@@ -203,7 +212,9 @@ func (a *AzureCostApi) Query(q QueryFilter) (QueryResponse, error) {
 	}
 
 	queryUrl := fmt.Sprintf("%s?%s", AZURE_HOST, filterString)
+	start := time.Now()
 	res, err := http.Get(queryUrl)
+	metrics.AzurePricingDuration.Observe(time.Since(start).Seconds())
 
 	if err != nil {
 		return QueryResponse{}, err
@@ -226,6 +237,92 @@ func NewApi() CostApi {
 	return &AzureCostApi{}
 }
 
+// A CostApi remembering the responses of another CostApi, since the prices of a SKU rarely change
+type CachingCostApi struct {
+	api       CostApi
+	lock      sync.Mutex
+	responses map[string]QueryResponse
+}
+
+func NewCachingApi(api CostApi) *CachingCostApi {
+	return &CachingCostApi{api: api, responses: make(map[string]QueryResponse)}
+}
+
+/*
+ * Returns the cached response to the same query, or queries the underlying CostApi. Failed queries are not cached
+ */
+func (c *CachingCostApi) Query(q QueryFilter) (QueryResponse, error) {
+	key, err := q.String()
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if response, ok := c.responses[key]; ok {
+		metrics.AzurePricingCache.WithLabelValues("hit").Inc()
+		return response, nil
+	}
+
+	metrics.AzurePricingCache.WithLabelValues("miss").Inc()
+	response, err := c.api.Query(q)
+
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	c.responses[key] = response
+	return response, nil
+}
+
+/*
+ * Adds the responses saved by Save to the cache, so that the prices can be used without querying the underlying CostApi again.
+ * A missing file is an empty cache
+ */
+func (c *CachingCostApi) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	responses := make(map[string]QueryResponse)
+
+	if err := json.Unmarshal(data, &responses); err != nil {
+		return fmt.Errorf("Could not parse the price cache %s: %v", path, err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, response := range responses {
+		c.responses[key] = response
+	}
+
+	return nil
+}
+
+/*
+ * Writes the cached responses to the file
+ */
+func (c *CachingCostApi) Save(path string) error {
+	c.lock.Lock()
+	data, err := json.MarshalIndent(c.responses, "", "  ")
+	c.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
 func ParseUnit(s string) (Unit, error) {
 	trimmedString := strings.ToLower(strings.ReplaceAll(s, " ", ""))
 
@@ -2,6 +2,8 @@ package pricing
 
 import (
 	"fmt"
+	"net/url"
+	"path/filepath"
 	"testing"
 )
 
@@ -62,3 +64,82 @@ func TestUnitString(t *testing.T) {
 		}
 	}
 }
+
+type countingApi struct {
+	queries int
+}
+
+func (c *countingApi) Query(q QueryFilter) (QueryResponse, error) {
+	c.queries++
+	return QueryResponse{Items: []Item{{ArmSkuName: q.ArmSkuName}}}, nil
+}
+
+func TestCachingApi(t *testing.T) {
+	counter := &countingApi{}
+	api := NewCachingApi(counter)
+
+	for _, sku := range []string{"Standard_D2s_v3", "Standard_D2s_v3", "Standard_D4s_v3"} {
+		response, err := api.Query(QueryFilter{ArmSkuName: sku, ArmRegionName: "swedencentral"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.Items[0].ArmSkuName != sku {
+			t.Errorf("Expected the price of %s, got the price of %s", sku, response.Items[0].ArmSkuName)
+		}
+	}
+
+	if counter.queries != 2 {
+		t.Errorf("Expected 2 queries to the Azure API, got %d", counter.queries)
+	}
+}
+
+func TestCachingApiFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	counter := &countingApi{}
+	api := NewCachingApi(counter)
+
+	if err := api.Load(path); err != nil {
+		t.Fatalf("A missing cache file should be an empty cache, got %v", err)
+	}
+
+	if _, err := api.Query(QueryFilter{ArmSkuName: "Standard_D2s_v3", ArmRegionName: "swedencentral"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := api.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewCachingApi(counter)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := loaded.Query(QueryFilter{ArmSkuName: "Standard_D2s_v3", ArmRegionName: "swedencentral"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if counter.queries != 1 || response.Items[0].ArmSkuName != "Standard_D2s_v3" {
+		t.Errorf("Expected the saved price of Standard_D2s_v3 after 1 query, got %v after %d queries", response.Items, counter.queries)
+	}
+}
+
+func TestQueryFilterQuotes(t *testing.T) {
+	filter := QueryFilter{ArmSkuName: "Standard_D2s_v3' or armSkuName ne '", ArmRegionName: "swedencentral", CurrencyCode: SEK}
+	query, err := filter.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "armRegionName eq 'swedencentral' and armSkuName eq 'Standard_D2s_v3'' or armSkuName ne '''"
+	if values.Get("$filter") != expected {
+		t.Errorf("Expected the filter %s, got %s", expected, values.Get("$filter"))
+	}
+}
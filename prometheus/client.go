@@ -0,0 +1,178 @@
+package prometheus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// How to connect to the Prometheus server
+type ClientConfig struct {
+	Address string
+	// File holding a bearer token sent with every request. The file is read for every request so that rotated tokens are picked up
+	BearerTokenFile   string
+	BasicAuthUser     string
+	BasicAuthPassword string
+	// Client certificate and key used for mutual TLS
+	CertFile string
+	KeyFile  string
+	// CA bundle used to verify the server certificate instead of the system certificates
+	CAFile             string
+	InsecureSkipVerify bool
+	// Headers sent with every request, e.g. X-Scope-OrgID for Cortex and Mimir tenants
+	Headers map[string]string
+}
+
+// A repeatable command line flag collecting the headers sent to Prometheus, see ParseHeader
+type HeaderFlag map[string]string
+
+func (h HeaderFlag) String() string {
+	headers := make([]string, 0, len(h))
+
+	for name, value := range h {
+		headers = append(headers, name+": "+value)
+	}
+
+	sort.Strings(headers)
+	return strings.Join(headers, ", ")
+}
+
+func (h HeaderFlag) Set(s string) error {
+	name, value, err := ParseHeader(s)
+
+	if err != nil {
+		return err
+	}
+
+	h[name] = value
+	return nil
+}
+
+/*
+ * Parses a header given as "Name: value" or "Name=value". Header names contain neither, so only the first separator splits
+ * and the value may contain commas, colons and equals signs, e.g. "Authorization: Basic dXNlcjpwYXNz=="
+ */
+func ParseHeader(s string) (string, string, error) {
+	separator := strings.IndexAny(s, ":=")
+
+	if separator < 0 || strings.TrimSpace(s[:separator]) == "" {
+		return "", "", fmt.Errorf("Invalid header '%s', use 'Name: value' or 'Name=value'", s)
+	}
+
+	return strings.TrimSpace(s[:separator]), strings.TrimSpace(s[separator+1:]), nil
+}
+
+/*
+ * Creates a MetricsSource querying the Prometheus server described by the configuration
+ */
+func NewPrometheusSourceFromConfig(config ClientConfig) (*PrometheusSource, error) {
+	roundTripper, err := NewRoundTripper(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := api.NewClient(api.Config{
+		Address:      config.Address,
+		RoundTripper: roundTripper,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSource(promv1.NewAPI(client)), nil
+}
+
+/*
+ * Creates the round tripper adding the TLS settings, credentials and headers of the configuration to every request
+ */
+func NewRoundTripper(config ClientConfig) (http.RoundTripper, error) {
+	if config.BearerTokenFile != "" && config.BasicAuthUser != "" {
+		return nil, fmt.Errorf("Only one of bearer token and basic auth can be used")
+	}
+
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("Both a client certificate and a key are needed for client certificate authentication")
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+
+	if config.CAFile != "" {
+		caBundle, err := ioutil.ReadFile(config.CAFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("No certificates found in the CA bundle %s", config.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := api.DefaultRoundTripper.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &authRoundTripper{config: config, next: transport}, nil
+}
+
+type authRoundTripper struct {
+	config ClientConfig
+	next   http.RoundTripper
+}
+
+func (a *authRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	// A round tripper must not modify the request it is given
+	request = request.Clone(request.Context())
+
+	for name, value := range a.config.Headers {
+		request.Header.Set(name, value)
+	}
+
+	if params := queryParamsFromContext(request.Context()); params != nil {
+		query := request.URL.Query()
+
+		for name, values := range params {
+			query[name] = values
+		}
+
+		request.URL.RawQuery = query.Encode()
+	}
+
+	if a.config.BearerTokenFile != "" {
+		token, err := ioutil.ReadFile(a.config.BearerTokenFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the bearer token: %v", err)
+		}
+
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	if a.config.BasicAuthUser != "" {
+		request.SetBasicAuth(a.config.BasicAuthUser, a.config.BasicAuthPassword)
+	}
+
+	return a.next.RoundTrip(request)
+}
@@ -0,0 +1,97 @@
+package prometheus
+
+import (
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Scope-OrgID") != "team-a" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"node":"node-1"},"value":[1640304000,"4"]}]}}`))
+	}))
+	defer server.Close()
+
+	directory := t.TempDir()
+	caFile := filepath.Join(directory, "ca.pem")
+	tokenFile := filepath.Join(directory, "token")
+	assert.Nil(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600))
+
+	config := ClientConfig{
+		Address:         server.URL,
+		BearerTokenFile: tokenFile,
+		CAFile:          caFile,
+		Headers:         map[string]string{"X-Scope-OrgID": "team-a"},
+	}
+
+	source, err := NewPrometheusSourceFromConfig(config)
+	assert.Nil(t, err)
+
+	capacity, _, err := source.GetCPUNodeCapacity("node-1", time.Unix(1640304000, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, 4.0, capacity)
+
+	// Without the CA bundle the server certificate is not trusted
+	config.CAFile = ""
+	source, err = NewPrometheusSourceFromConfig(config)
+	assert.Nil(t, err)
+
+	_, _, err = source.GetCPUNodeCapacity("node-1", time.Unix(1640304000, 0))
+	assert.NotNil(t, err)
+
+	// Without the tenant header the request is refused
+	config.CAFile = caFile
+	config.Headers = nil
+	source, err = NewPrometheusSourceFromConfig(config)
+	assert.Nil(t, err)
+
+	_, _, err = source.GetCPUNodeCapacity("node-1", time.Unix(1640304000, 0))
+	assert.NotNil(t, err)
+}
+
+func TestInvalidClientConfig(t *testing.T) {
+	_, err := NewRoundTripper(ClientConfig{BearerTokenFile: "token", BasicAuthUser: "user"})
+	assert.NotNil(t, err)
+
+	_, err = NewRoundTripper(ClientConfig{CertFile: "client.pem"})
+	assert.NotNil(t, err)
+
+	_, err = NewRoundTripper(ClientConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.NotNil(t, err)
+}
+
+func TestHeaderFlag(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	headers := HeaderFlag{}
+	flags.Var(headers, "prometheus-header", "")
+
+	err := flags.Parse([]string{
+		"-prometheus-header", "X-Scope-OrgID: team-a,team-b",
+		"-prometheus-header", "Authorization=Basic dXNlcjpwYXNz==",
+		"-prometheus-header", "X-Forwarded-For: 10.0.0.1",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, HeaderFlag{
+		"X-Scope-OrgID":   "team-a,team-b",
+		"Authorization":   "Basic dXNlcjpwYXNz==",
+		"X-Forwarded-For": "10.0.0.1",
+	}, headers)
+
+	_, _, err = ParseHeader("X-Scope-OrgID")
+	assert.NotNil(t, err)
+
+	_, _, err = ParseHeader(": team-a")
+	assert.NotNil(t, err)
+}
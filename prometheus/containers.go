@@ -0,0 +1,163 @@
+package prometheus
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// A container of a pod
+type Container struct {
+	Namespace string
+	Pod       string
+	Name      string
+}
+
+// CPU in cores and memory in bytes
+type Resources struct {
+	CPU    float64
+	Memory float64
+}
+
+// The requests and limits of a container and the node its pod ran on. Resources without a request or limit are zero
+type ContainerSpec struct {
+	Node     string
+	Requests Resources
+	Limits   Resources
+}
+
+// A MetricsSource that can also query the usage, requests and limits of single containers
+type ContainerSource interface {
+	GetContainerUsageQuantile(quantile float64, t time.Time, duration time.Duration) (map[Container]Resources, promv1.Warnings, error)
+	GetContainerSpecs(t time.Time, duration time.Duration) (map[Container]ContainerSpec, promv1.Warnings, error)
+}
+
+var _ ContainerSource = (*PrometheusSource)(nil)
+
+// The step of the subqueries the usage quantiles are computed from, unless the data is downsampled to a coarser resolution
+const CONTAINER_USAGE_STEP = time.Minute
+
+/*
+ * Returns the quantile, e.g. 0.95, of the CPU and memory usage of every container during the duration before t.
+ * Memory usage is the working set, which is what the kubelet compares with the memory limit
+ */
+func (p *PrometheusSource) GetContainerUsageQuantile(quantile float64, t time.Time, duration time.Duration) (map[Container]Resources, promv1.Warnings, error) {
+	oldest := t.Add(-duration)
+	step := CONTAINER_USAGE_STEP
+	if resolution := p.DataResolution(oldest); resolution > step {
+		step = resolution
+	}
+
+	data := QueryData{
+		Duration:   model.Duration(duration).String(),
+		Resolution: model.Duration(step).String(),
+		Quantile:   strconv.FormatFloat(quantile, 'f', -1, 64),
+	}
+
+	resultMap := make(map[Container]Resources)
+	warnings := promv1.Warnings{}
+
+	for _, name := range []string{QUERY_CONTAINER_CPU_QUANTILE, QUERY_CONTAINER_MEMORY_QUANTILE} {
+		query, err := p.render(name, data, oldest)
+
+		if err != nil {
+			return nil, warnings, err
+		}
+
+		result, queryWarnings, err := p.query(name, query, t, oldest)
+		warnings = append(warnings, queryWarnings...)
+
+		if err != nil {
+			return nil, warnings, err
+		}
+
+		vector, ok := result.(model.Vector)
+
+		if !ok {
+			return nil, warnings, fmt.Errorf("Container usage query did not return a Vector.")
+		}
+
+		for _, sample := range vector {
+			container := p.queries.containerOf(sample.Metric)
+			usage := resultMap[container]
+
+			if name == QUERY_CONTAINER_CPU_QUANTILE {
+				usage.CPU = float64(sample.Value)
+			} else {
+				usage.Memory = float64(sample.Value)
+			}
+
+			resultMap[container] = usage
+		}
+	}
+
+	return resultMap, warnings, nil
+}
+
+/*
+ * Returns the largest CPU and memory requests and limits of every container during the duration before t, from kube-state-metrics
+ */
+func (p *PrometheusSource) GetContainerSpecs(t time.Time, duration time.Duration) (map[Container]ContainerSpec, promv1.Warnings, error) {
+	oldest := t.Add(-duration)
+	data := QueryData{Duration: model.Duration(duration).String()}
+
+	resultMap := make(map[Container]ContainerSpec)
+	warnings := promv1.Warnings{}
+
+	for _, name := range []string{QUERY_CONTAINER_REQUESTS, QUERY_CONTAINER_LIMITS} {
+		query, err := p.render(name, data, oldest)
+
+		if err != nil {
+			return nil, warnings, err
+		}
+
+		result, queryWarnings, err := p.query(name, query, t, oldest)
+		warnings = append(warnings, queryWarnings...)
+
+		if err != nil {
+			return nil, warnings, err
+		}
+
+		vector, ok := result.(model.Vector)
+
+		if !ok {
+			return nil, warnings, fmt.Errorf("Container resource query did not return a Vector.")
+		}
+
+		for _, sample := range vector {
+			container := p.queries.containerOf(sample.Metric)
+			spec := resultMap[container]
+			if node := p.queries.labelOf(sample.Metric, "node"); node != "" {
+				spec.Node = node
+			}
+
+			resources := &spec.Requests
+			if name == QUERY_CONTAINER_LIMITS {
+				resources = &spec.Limits
+			}
+
+			switch p.queries.labelOf(sample.Metric, "resource") {
+			case "cpu":
+				resources.CPU = float64(sample.Value)
+			case "memory":
+				resources.Memory = float64(sample.Value)
+			}
+
+			resultMap[container] = spec
+		}
+	}
+
+	return resultMap, warnings, nil
+}
+
+// Returns the container a series of a container query is about, read with the configured label names
+func (q *Queries) containerOf(metric model.Metric) Container {
+	return Container{
+		Namespace: q.labelOf(metric, "namespace"),
+		Pod:       q.labelOf(metric, "pod"),
+		Name:      q.labelOf(metric, "container"),
+	}
+}
@@ -0,0 +1,99 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerQueries(t *testing.T) {
+	queries := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		query := r.Form.Get("query")
+		queries = append(queries, query)
+
+		switch {
+		case strings.Contains(query, "container_cpu_usage_seconds_total"):
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"namespace":"shop","pod":"web-1","container":"app"},"value":[1640304000,"0.25"]}]}}`))
+		case strings.Contains(query, "container_memory_working_set_bytes"):
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"namespace":"shop","pod":"web-1","container":"app"},"value":[1640304000,"1000"]}]}}`))
+		case strings.Contains(query, "kube_pod_container_resource_requests"):
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` +
+				`{"metric":{"namespace":"shop","pod":"web-1","container":"app","node":"node-1","resource":"cpu"},"value":[1640304000,"1"]},` +
+				`{"metric":{"namespace":"shop","pod":"web-1","container":"app","node":"node-1","resource":"memory"},"value":[1640304000,"4000"]}]}}`))
+		default:
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"namespace":"shop","pod":"web-1","container":"app","node":"node-1","resource":"memory"},"value":[1640304000,"8000"]}]}}`))
+		}
+	}))
+	defer server.Close()
+
+	source, err := NewPrometheusSource(server.URL)
+	assert.Nil(t, err)
+
+	app := Container{Namespace: "shop", Pod: "web-1", Name: "app"}
+	usage, _, err := source.GetContainerUsageQuantile(0.95, time.Unix(1640304000, 0), 7*24*time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, map[Container]Resources{app: {CPU: 0.25, Memory: 1000}}, usage)
+	assert.True(t, strings.HasPrefix(queries[0], "quantile_over_time(0.95, "))
+	assert.True(t, strings.HasSuffix(queries[0], "[1w:1m])"))
+
+	specs, _, err := source.GetContainerSpecs(time.Unix(1640304000, 0), 7*24*time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, ContainerSpec{Node: "node-1", Requests: Resources{CPU: 1, Memory: 4000}, Limits: Resources{Memory: 8000}}, specs[app])
+}
+
+func TestRemappedContainerQueries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		query := r.Form.Get("query")
+
+		switch {
+		case strings.Contains(query, "container_cpu_usage_seconds_total") && strings.Contains(query, "sum by (kubernetes_namespace, pod_name, container_name)"):
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"kubernetes_namespace":"shop","pod_name":"web-1","container_name":"app"},"value":[1640304000,"0.25"]}]}}`))
+		case strings.Contains(query, "container_memory_working_set_bytes"):
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"kubernetes_namespace":"shop","pod_name":"web-1","container_name":"app"},"value":[1640304000,"1000"]}]}}`))
+		case strings.Contains(query, "kube_pod_container_resource_requests{res=~'cpu|memory'}"):
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` +
+				`{"metric":{"kubernetes_namespace":"shop","pod_name":"web-1","container_name":"app","kubernetes_node":"node-1","res":"cpu"},"value":[1640304000,"1"]},` +
+				`{"metric":{"kubernetes_namespace":"shop","pod_name":"web-1","container_name":"app","kubernetes_node":"node-1","res":"memory"},"value":[1640304000,"4000"]}]}}`))
+		case strings.Contains(query, "kube_pod_container_resource_limits{res=~'cpu|memory'}"):
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"kubernetes_namespace":"shop","pod_name":"web-1","container_name":"app","kubernetes_node":"node-1","res":"memory"},"value":[1640304000,"8000"]}]}}`))
+		default:
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+		}
+	}))
+	defer server.Close()
+
+	queries, err := NewQueries(QueryConfig{Labels: map[string]string{
+		"node":      "kubernetes_node",
+		"namespace": "kubernetes_namespace",
+		"pod":       "pod_name",
+		"container": "container_name",
+		"resource":  "res",
+	}})
+	assert.Nil(t, err)
+
+	source, err := NewPrometheusSource(server.URL)
+	assert.Nil(t, err)
+	source.SetQueries(queries)
+
+	app := Container{Namespace: "shop", Pod: "web-1", Name: "app"}
+	usage, _, err := source.GetContainerUsageQuantile(0.95, time.Unix(1640304000, 0), 7*24*time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, map[Container]Resources{app: {CPU: 0.25, Memory: 1000}}, usage)
+
+	specs, _, err := source.GetContainerSpecs(time.Unix(1640304000, 0), 7*24*time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, map[Container]ContainerSpec{app: {Node: "node-1", Requests: Resources{CPU: 1, Memory: 4000}, Limits: Resources{Memory: 8000}}}, specs)
+}
+
+func TestQuantileOf(t *testing.T) {
+	assert.Equal(t, 3.0, quantileOf([]float64{4, 1, 3, 2, 5}, 0.5))
+	assert.InDelta(t, 4.8, quantileOf([]float64{1, 2, 3, 4, 5}, 0.95), 1e-9)
+	assert.Equal(t, 7.0, quantileOf([]float64{7}, 0.99))
+}
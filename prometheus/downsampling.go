@@ -0,0 +1,143 @@
+package prometheus
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+/*
+ * The retention of the downsampled data of a Thanos (or Mimir) long-term store. Thanos keeps raw data for RawRetention,
+ * data downsampled to 5m for FiveMinuteRetention and data downsampled to 1h for OneHourRetention
+ */
+type Downsampling struct {
+	// Pass max_source_resolution and partial_response with every query
+	Enabled bool
+	// Zero if raw data is kept forever
+	RawRetention time.Duration
+	// Zero if data downsampled to 5m is kept forever
+	FiveMinuteRetention time.Duration
+	// Zero if data downsampled to 1h is kept forever, the default of Thanos
+	OneHourRetention time.Duration
+	// Let Thanos answer with the data of the available stores when some stores fail
+	PartialResponse bool
+}
+
+// A MetricsSource whose data resolution depends on the age of the data
+type DownsampledSource interface {
+	DataResolution(oldest time.Time) time.Duration
+}
+
+var _ DownsampledSource = (*PrometheusSource)(nil)
+
+/*
+ * Returns the resolution of the data available at the oldest time, zero for raw data
+ */
+func (d Downsampling) Resolution(oldest time.Time, now time.Time) time.Duration {
+	age := now.Sub(oldest)
+
+	if !d.Enabled || d.RawRetention == 0 || age <= d.RawRetention {
+		return 0
+	}
+
+	if d.FiveMinuteRetention == 0 || age <= d.FiveMinuteRetention {
+		return 5 * time.Minute
+	}
+
+	return time.Hour
+}
+
+/*
+ * Returns how long the store keeps any data at all, the longest of the retentions, or zero if some data is kept forever
+ */
+func (d Downsampling) Retention() time.Duration {
+	if d.RawRetention == 0 || d.FiveMinuteRetention == 0 || d.OneHourRetention == 0 {
+		return 0
+	}
+
+	retention := d.RawRetention
+	if d.FiveMinuteRetention > retention {
+		retention = d.FiveMinuteRetention
+	}
+	if d.OneHourRetention > retention {
+		retention = d.OneHourRetention
+	}
+
+	return retention
+}
+
+/*
+ * Returns the resolution the way it is reported to users, "raw" or e.g. "5m"
+ */
+func FormatResolution(resolution time.Duration) string {
+	if resolution == 0 {
+		return "raw"
+	}
+
+	return model.Duration(resolution).String()
+}
+
+/*
+ * Sets the downsampling of the queried long-term store. Queries reaching data older than the raw retention
+ * ask for downsampled data and use rate windows spanning several downsampled samples
+ */
+func (p *PrometheusSource) SetDownsampling(downsampling Downsampling) {
+	p.downsampling = downsampling
+}
+
+/*
+ * Returns the resolution of the data a query reading samples back to the oldest time gets
+ */
+func (p *PrometheusSource) DataResolution(oldest time.Time) time.Duration {
+	return p.downsampling.Resolution(oldest, time.Now())
+}
+
+/*
+ * Returns the Thanos query parameters of a query reading samples back to the oldest time
+ */
+func (p *PrometheusSource) queryParams(oldest time.Time) url.Values {
+	if !p.downsampling.Enabled {
+		return nil
+	}
+
+	params := url.Values{}
+	params.Set("max_source_resolution", model.Duration(p.DataResolution(oldest)).String())
+
+	if p.downsampling.PartialResponse {
+		params.Set("partial_response", "true")
+	} else {
+		params.Set("partial_response", "false")
+	}
+
+	return params
+}
+
+/*
+ * Renders a query reading samples back to the oldest time. Rate windows span four samples of downsampled data,
+ * since a rate needs at least two samples and the configured window is meant for raw data
+ */
+func (p *PrometheusSource) render(name string, data QueryData, oldest time.Time) (string, error) {
+	if resolution := p.DataResolution(oldest); resolution > 0 {
+		data.RateWindow = model.Duration(4 * resolution).String()
+	}
+
+	return p.queries.render(name, data)
+}
+
+type queryParamsKey struct{}
+
+// Returns a context making the client round tripper add the parameters to the request
+func withQueryParams(ctx context.Context, params url.Values) context.Context {
+	if params == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, queryParamsKey{}, params)
+}
+
+func queryParamsFromContext(ctx context.Context) url.Values {
+	params, _ := ctx.Value(queryParamsKey{}).(url.Values)
+	return params
+}
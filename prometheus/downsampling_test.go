@@ -0,0 +1,73 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownsamplingResolution(t *testing.T) {
+	now := time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC)
+	downsampling := Downsampling{Enabled: true, RawRetention: 14 * 24 * time.Hour, FiveMinuteRetention: 90 * 24 * time.Hour}
+
+	assert.Equal(t, time.Duration(0), downsampling.Resolution(now.Add(-24*time.Hour), now))
+	assert.Equal(t, 5*time.Minute, downsampling.Resolution(now.Add(-30*24*time.Hour), now))
+	assert.Equal(t, time.Hour, downsampling.Resolution(now.Add(-100*24*time.Hour), now))
+
+	downsampling.FiveMinuteRetention = 0
+	assert.Equal(t, 5*time.Minute, downsampling.Resolution(now.Add(-100*24*time.Hour), now))
+
+	downsampling.Enabled = false
+	assert.Equal(t, time.Duration(0), downsampling.Resolution(now.Add(-100*24*time.Hour), now))
+
+	assert.Equal(t, "raw", FormatResolution(0))
+	assert.Equal(t, "5m", FormatResolution(5*time.Minute))
+}
+
+func TestDownsamplingRetention(t *testing.T) {
+	downsampling := Downsampling{Enabled: true, RawRetention: 14 * 24 * time.Hour, FiveMinuteRetention: 90 * 24 * time.Hour}
+	assert.Equal(t, time.Duration(0), downsampling.Retention())
+
+	downsampling.OneHourRetention = 365 * 24 * time.Hour
+	assert.Equal(t, 365*24*time.Hour, downsampling.Retention())
+
+	downsampling.OneHourRetention = 30 * 24 * time.Hour
+	assert.Equal(t, 90*24*time.Hour, downsampling.Retention())
+
+	downsampling.RawRetention = 0
+	assert.Equal(t, time.Duration(0), downsampling.Retention())
+}
+
+func TestDownsampledQueries(t *testing.T) {
+	var queries, resolutions, partialResponses []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		queries = append(queries, r.Form.Get("query"))
+		resolutions = append(resolutions, r.Form.Get("max_source_resolution"))
+		partialResponses = append(partialResponses, r.Form.Get("partial_response"))
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+	}))
+	defer server.Close()
+
+	source, err := NewPrometheusSource(server.URL)
+	assert.Nil(t, err)
+	source.SetDownsampling(Downsampling{Enabled: true, RawRetention: 14 * 24 * time.Hour, PartialResponse: true})
+
+	end := time.Now().Truncate(time.Hour)
+	_, _, err = source.GetClusterPodResourceUsageOverTime(end.Add(-2*time.Hour), end, time.Hour)
+	assert.Nil(t, err)
+
+	end = end.Add(-30 * 24 * time.Hour)
+	_, _, err = source.GetClusterPodResourceUsageOverTime(end.Add(-2*time.Hour), end, time.Hour)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"0s", "0s", "5m", "5m"}, resolutions)
+	assert.Equal(t, []string{"true", "true", "true", "true"}, partialResponses)
+	assert.True(t, strings.Contains(queries[0], "[5m]"))
+	assert.True(t, strings.Contains(queries[2], "[20m]"))
+}
@@ -0,0 +1,325 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// A node of a fake cluster, with its capacity in cores and bytes and its price per hour
+type FakeNode struct {
+	Name   string  `json:"name"`
+	Price  float64 `json:"price"`
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+}
+
+type FakeOwner struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// A pod of a fake cluster and its usage. Every usage sample lasts until the next one
+type FakePod struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Node      string            `json:"node"`
+	Owner     FakeOwner         `json:"owner"`
+	Labels    map[string]string `json:"labels"`
+	Usage     []FakeUsage       `json:"usage"`
+	// The containers of the pod, only used by the container queries
+	Containers []FakeContainer `json:"containers"`
+}
+
+// A container of a fake pod with its requests, limits and usage. Every usage sample lasts until the next one
+type FakeContainer struct {
+	Name     string      `json:"name"`
+	Requests Resources   `json:"requests"`
+	Limits   Resources   `json:"limits"`
+	Usage    []FakeUsage `json:"usage"`
+}
+
+type FakeUsage struct {
+	Time   time.Time `json:"time"`
+	CPU    float64   `json:"cpu"`
+	Memory float64   `json:"memory"`
+}
+
+// A MetricsSource answering from an in-memory cluster instead of a Prometheus server, used for testing
+type FakeSource struct {
+	Nodes []FakeNode `json:"nodes"`
+	Pods  []FakePod  `json:"pods"`
+	// Replica set name as key and the owning deployment as value
+	ReplicaSets map[string]string `json:"replicaSets"`
+}
+
+var _ ClusterMetricsSource = (*FakeSource)(nil)
+var _ ContainerSource = (*FakeSource)(nil)
+
+/*
+ * Reads a fake cluster from a JSON fixture file
+ */
+func LoadFakeSource(path string) (*FakeSource, error) {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	source := &FakeSource{}
+	err = json.Unmarshal(content, source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range source.Pods {
+		sort.Slice(pod.Usage, func(i, j int) bool {
+			return pod.Usage[i].Time.Before(pod.Usage[j].Time)
+		})
+
+		for _, container := range pod.Containers {
+			sort.Slice(container.Usage, func(i, j int) bool {
+				return container.Usage[i].Time.Before(container.Usage[j].Time)
+			})
+		}
+	}
+
+	return source, nil
+}
+
+func (f *FakeSource) GetAvgPodResourceUsageOverTime(node string, startTime time.Time, endTime time.Time, resolution time.Duration) ([]ResourceUsageSample, promv1.Warnings, error) {
+	samples := []ResourceUsageSample{}
+
+	for _, t := range StepTimes(startTime, endTime, resolution) {
+		resourceUsages := make(map[string]ResourceUsage)
+
+		for _, pod := range f.Pods {
+			if pod.Node != node {
+				continue
+			}
+
+			usage, ok := pod.usageAt(t)
+
+			if !ok {
+				continue
+			}
+
+			resourceUsages[pod.Name] = ResourceUsage{
+				Namespace: pod.Namespace,
+				CpuUsage:  usage.CPU,
+				MemUsage:  usage.Memory,
+			}
+		}
+
+		// Prometheus does not return time stamps without any series
+		if len(resourceUsages) == 0 {
+			continue
+		}
+
+		samples = append(samples, ResourceUsageSample{
+			Time:           t,
+			ResourceUsages: resourceUsages,
+		})
+	}
+
+	return samples, nil, nil
+}
+
+func (f *FakeSource) GetClusterPodResourceUsageOverTime(startTime time.Time, endTime time.Time, resolution time.Duration) (map[string][]ResourceUsageSample, promv1.Warnings, error) {
+	resultMap := make(map[string][]ResourceUsageSample)
+
+	for _, node := range f.Nodes {
+		samples, _, _ := f.GetAvgPodResourceUsageOverTime(node.Name, startTime, endTime, resolution)
+
+		if len(samples) > 0 {
+			resultMap[node.Name] = samples
+		}
+	}
+
+	return resultMap, nil, nil
+}
+
+func (f *FakeSource) GetClusterNodeCapacityOverTime(startTime time.Time, endTime time.Time, resolution time.Duration) (map[string][]NodeCapacity, promv1.Warnings, error) {
+	resultMap := make(map[string][]NodeCapacity)
+
+	for _, node := range f.Nodes {
+		for _, t := range StepTimes(startTime, endTime, resolution) {
+			resultMap[node.Name] = append(resultMap[node.Name], NodeCapacity{
+				Time:   t,
+				CPU:    node.CPU,
+				Memory: node.Memory,
+			})
+		}
+	}
+
+	return resultMap, nil, nil
+}
+
+func (f *FakeSource) GetCPUNodeCapacity(node string, t time.Time) (float64, promv1.Warnings, error) {
+	fakeNode, err := f.node(node)
+	return fakeNode.CPU, nil, err
+}
+
+func (f *FakeSource) GetMemoryNodeCapacity(node string, t time.Time) (float64, promv1.Warnings, error) {
+	fakeNode, err := f.node(node)
+	return fakeNode.Memory, nil, err
+}
+
+func (f *FakeSource) GetPodsToDeployment(t time.Time, duration time.Duration) map[string]string {
+	resultMap := make(map[string]string)
+
+	for _, pod := range f.Pods {
+		if pod.Owner.Kind != "ReplicaSet" {
+			continue
+		}
+
+		deployment, ok := f.ReplicaSets[pod.Owner.Name]
+
+		if ok {
+			resultMap[pod.Name] = deployment
+		}
+	}
+
+	return resultMap
+}
+
+func (f *FakeSource) GetPodOwners(t time.Time, duration time.Duration) (map[string]PodOwner, promv1.Warnings, error) {
+	resultMap := make(map[string]PodOwner)
+
+	for _, pod := range f.Pods {
+		owner := PodOwner{Kind: pod.Owner.Kind, Name: pod.Owner.Name}
+
+		if owner.Kind == "" {
+			owner.Kind = "<none>"
+		}
+
+		if deployment, ok := f.ReplicaSets[owner.Name]; ok && owner.Kind == "ReplicaSet" {
+			owner = PodOwner{Kind: "Deployment", Name: deployment}
+		}
+
+		resultMap[pod.Name] = owner
+	}
+
+	return resultMap, nil, nil
+}
+
+func (f *FakeSource) GetPodLabels(t time.Time, duration time.Duration) (map[string]map[string]string, promv1.Warnings, error) {
+	resultMap := make(map[string]map[string]string)
+
+	for _, pod := range f.Pods {
+		podLabels := make(map[string]string)
+
+		for name, value := range pod.Labels {
+			podLabels[SanitizeLabelName(name)] = value
+		}
+
+		resultMap[pod.Name] = podLabels
+	}
+
+	return resultMap, nil, nil
+}
+
+/*
+ * Returns the quantile of the usage of every container, sampled every CONTAINER_USAGE_STEP during the duration before t
+ * and interpolated like quantile_over_time
+ */
+func (f *FakeSource) GetContainerUsageQuantile(quantile float64, t time.Time, duration time.Duration) (map[Container]Resources, promv1.Warnings, error) {
+	resultMap := make(map[Container]Resources)
+	steps := StepTimes(t.Add(-duration), t, CONTAINER_USAGE_STEP)
+
+	for _, pod := range f.Pods {
+		for _, container := range pod.Containers {
+			cpu := []float64{}
+			memory := []float64{}
+
+			for _, step := range steps {
+				if usage, ok := usageAt(container.Usage, step); ok {
+					cpu = append(cpu, usage.CPU)
+					memory = append(memory, usage.Memory)
+				}
+			}
+
+			if len(cpu) == 0 {
+				continue
+			}
+
+			resultMap[Container{Namespace: pod.Namespace, Pod: pod.Name, Name: container.Name}] = Resources{
+				CPU:    quantileOf(cpu, quantile),
+				Memory: quantileOf(memory, quantile),
+			}
+		}
+	}
+
+	return resultMap, nil, nil
+}
+
+func (f *FakeSource) GetContainerSpecs(t time.Time, duration time.Duration) (map[Container]ContainerSpec, promv1.Warnings, error) {
+	resultMap := make(map[Container]ContainerSpec)
+
+	for _, pod := range f.Pods {
+		for _, container := range pod.Containers {
+			resultMap[Container{Namespace: pod.Namespace, Pod: pod.Name, Name: container.Name}] = ContainerSpec{
+				Node:     pod.Node,
+				Requests: container.Requests,
+				Limits:   container.Limits,
+			}
+		}
+	}
+
+	return resultMap, nil, nil
+}
+
+/*
+ * Returns the quantile of the values, interpolating between the closest values like quantile_over_time
+ */
+func quantileOf(values []float64, quantile float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	rank := quantile * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+
+	weight := rank - float64(lower)
+	return sorted[lower]*(1-weight) + sorted[lower+1]*weight
+}
+
+func (f *FakeSource) node(name string) (FakeNode, error) {
+	for _, node := range f.Nodes {
+		if node.Name == name {
+			return node, nil
+		}
+	}
+
+	return FakeNode{}, fmt.Errorf("The node %s does not exist", name)
+}
+
+/*
+ * Returns the latest usage sample at or before t
+ */
+func (p FakePod) usageAt(t time.Time) (FakeUsage, bool) {
+	return usageAt(p.Usage, t)
+}
+
+func usageAt(samples []FakeUsage, t time.Time) (FakeUsage, bool) {
+	found := false
+	usage := FakeUsage{}
+
+	for _, sample := range samples {
+		if sample.Time.After(t) {
+			break
+		}
+
+		usage = sample
+		found = true
+	}
+
+	return usage, found
+}
@@ -3,12 +3,13 @@ package prometheus
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
-	//Might not be needed after proper error handling
-	"os"
+	"dat067/costestimation/metrics"
 
-	"github.com/prometheus/client_golang/api"
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 )
@@ -19,27 +20,206 @@ type ResourceUsageSample struct {
 }
 
 type ResourceUsage struct {
-	CpuUsage float64
-	MemUsage float64
+	Namespace string
+	CpuUsage  float64
+	MemUsage  float64
 }
 
-var localAPI promv1.API
+// kube-state-metrics exposes the Kubernetes labels of a pod as Prometheus labels with this prefix
+const POD_LABEL_PREFIX = "label_"
+
+var invalidLabelCharacters = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// The usage, capacity and ownership metrics the cost pipeline is computed from
+type MetricsSource interface {
+	GetAvgPodResourceUsageOverTime(node string, startTime time.Time, endTime time.Time, resolution time.Duration) ([]ResourceUsageSample, promv1.Warnings, error)
+	GetCPUNodeCapacity(node string, t time.Time) (float64, promv1.Warnings, error)
+	GetMemoryNodeCapacity(node string, t time.Time) (float64, promv1.Warnings, error)
+	GetPodsToDeployment(t time.Time, duration time.Duration) map[string]string
+	GetPodLabels(t time.Time, duration time.Duration) (map[string]map[string]string, promv1.Warnings, error)
+	GetPodOwners(t time.Time, duration time.Duration) (map[string]PodOwner, promv1.Warnings, error)
+}
+
+// The controller owning a pod. Pods owned by a ReplicaSet of a Deployment are owned by the Deployment
+type PodOwner struct {
+	Kind string
+	Name string
+}
+
+// A MetricsSource that can also query the usage and capacity of all nodes at once
+type ClusterMetricsSource interface {
+	MetricsSource
+	GetClusterPodResourceUsageOverTime(startTime time.Time, endTime time.Time, resolution time.Duration) (map[string][]ResourceUsageSample, promv1.Warnings, error)
+	GetClusterNodeCapacityOverTime(startTime time.Time, endTime time.Time, resolution time.Duration) (map[string][]NodeCapacity, promv1.Warnings, error)
+}
+
+// The CPU capacity in cores and memory capacity in bytes of a node at a point in time
+type NodeCapacity struct {
+	Time   time.Time
+	CPU    float64
+	Memory float64
+}
+
+// A MetricsSource that can check whether it is usable
+type CheckedSource interface {
+	Ping() error
+}
+
+var _ ClusterMetricsSource = (*PrometheusSource)(nil)
+var _ CheckedSource = (*PrometheusSource)(nil)
+
+// A MetricsSource querying a Prometheus server
+type PrometheusSource struct {
+	api promv1.API
+	// The largest number of steps queried by a single range query
+	chunkPoints  int
+	queries      *Queries
+	downsampling Downsampling
+}
+
+// Prometheus refuses range queries returning more than 11000 points per series
+const MAX_POINTS_PER_QUERY = 11000
+
+// The number of steps queried by a single range query unless SetChunkPoints says otherwise. Well below MAX_POINTS_PER_QUERY,
+// so that the longest ranges a request may ask for are split into queries that stay below the query timeout
+const DEFAULT_CHUNK_POINTS = 1000
 
 func ImportantFunction() int {
 	return 3
 }
 
-func CreateAPI(address string) promv1.API {
-	client, err := api.NewClient(api.Config{
-		Address: address,
-	})
+/*
+ * Creates a MetricsSource querying the Prometheus server at the address
+ */
+func NewPrometheusSource(address string) (*PrometheusSource, error) {
+	return NewPrometheusSourceFromConfig(ClientConfig{Address: address})
+}
+
+func NewSource(api promv1.API) *PrometheusSource {
+	return &PrometheusSource{api: api, chunkPoints: DEFAULT_CHUNK_POINTS, queries: DefaultQueries()}
+}
+
+/*
+ * Replaces the metric names, label names and query templates the queries are built from
+ */
+func (p *PrometheusSource) SetQueries(queries *Queries) {
+	p.queries = queries
+}
+
+/*
+ * Sets the largest number of steps queried by a single range query. Longer ranges are split into several queries.
+ * Smaller chunks keep each query below the query timeout
+ */
+func (p *PrometheusSource) SetChunkPoints(points int) {
+	if points < 1 || points > MAX_POINTS_PER_QUERY {
+		points = MAX_POINTS_PER_QUERY
+	}
+
+	p.chunkPoints = points
+}
+
+/*
+ * Performs a range query in chunks of at most chunkPoints steps and merges the resulting series
+ */
+func (p *PrometheusSource) queryRange(name string, query string, t promv1.Range) (model.Matrix, promv1.Warnings, error) {
+	matrices := []model.Matrix{}
+	var warnings promv1.Warnings
+	// The chunks ask for the same data resolution as the rate windows of the query are chosen for
+	ctx := withQueryParams(context.Background(), p.queryParams(t.Start.Add(-t.Step)))
+
+	for _, chunk := range SplitRange(t, p.chunkPoints) {
+		start := time.Now()
+		result, chunkWarnings, err := queryOverTime(ctx, query, p.api, chunk)
+		metrics.ObservePrometheusQuery(name, start, err)
+		warnings = append(warnings, chunkWarnings...)
+
+		if err != nil {
+			return nil, warnings, err
+		}
+
+		matrix, ok := result.(model.Matrix)
+
+		if !ok {
+			return nil, warnings, fmt.Errorf("The range query '%s' did not return a Matrix.", query)
+		}
+
+		matrices = append(matrices, matrix)
+	}
+
+	return mergeMatrices(matrices), warnings, nil
+}
+
+/*
+ * Splits the range into consecutive ranges of at most maxPoints steps each. The steps of the chunks are the same as the steps of the whole range
+ */
+func SplitRange(t promv1.Range, maxPoints int) []promv1.Range {
+	if maxPoints < 1 || t.Step <= 0 {
+		return []promv1.Range{t}
+	}
+
+	chunks := []promv1.Range{}
+	chunkLength := time.Duration(maxPoints-1) * t.Step
+
+	for start := t.Start; !start.After(t.End); start = start.Add(chunkLength + t.Step) {
+		end := start.Add(chunkLength)
+
+		if end.After(t.End) {
+			end = t.End
+		}
+
+		chunks = append(chunks, promv1.Range{Start: start, End: end, Step: t.Step})
+	}
+
+	return chunks
+}
+
+/*
+ * Joins the series of several matrices, so that every metric has a single series with the values of all matrices in order
+ */
+func mergeMatrices(matrices []model.Matrix) model.Matrix {
+	if len(matrices) == 1 {
+		return matrices[0]
+	}
+
+	merged := model.Matrix{}
+	streams := make(map[model.Fingerprint]*model.SampleStream)
+
+	for _, matrix := range matrices {
+		for _, sampleStream := range matrix {
+			fingerprint := sampleStream.Metric.Fingerprint()
+			stream, ok := streams[fingerprint]
+
+			if !ok {
+				stream = &model.SampleStream{Metric: sampleStream.Metric}
+				streams[fingerprint] = stream
+				merged = append(merged, stream)
+			}
+
+			stream.Values = append(stream.Values, sampleStream.Values...)
+		}
+	}
+
+	for _, stream := range merged {
+		sort.Slice(stream.Values, func(i, j int) bool {
+			return stream.Values[i].Timestamp.Before(stream.Values[j].Timestamp)
+		})
+	}
+
+	return merged
+}
+
+/*
+ * Makes the source write every query response to the directory, see NewRecordingAPI
+ */
+func (p *PrometheusSource) Record(directory string) error {
+	recordingAPI, err := NewRecordingAPI(p.api, directory)
 
 	if err != nil {
-		fmt.Printf("An error occured when creating the client: %v\n", err)
-		os.Exit(1)
+		return err
 	}
-	localAPI = promv1.NewAPI(client)
-	return localAPI
+
+	p.api = recordingAPI
+	return nil
 }
 
 /*
@@ -47,32 +227,52 @@ func CreateAPI(address string) promv1.API {
  * Performs a Prometheus query over the specified time range t
  */
 func QueryOverTime(query string, api promv1.API, t promv1.Range) (model.Value, promv1.Warnings, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	return queryOverTime(context.Background(), query, api, t)
+}
+
+func Query(query string, api promv1.API, t time.Time) (model.Value, promv1.Warnings, error) {
+	return queryAt(context.Background(), query, api, t)
+}
+
+func queryOverTime(ctx context.Context, query string, api promv1.API, t promv1.Range) (model.Value, promv1.Warnings, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	return api.QueryRange(ctx, query, t)
 }
 
-func Query(query string, api promv1.API, t time.Time) (model.Value, promv1.Warnings, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func queryAt(ctx context.Context, query string, api promv1.API, t time.Time) (model.Value, promv1.Warnings, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	return api.Query(ctx, query, t)
 }
 
+/*
+ * Performs an instant query reading samples back to the oldest time
+ */
+func (p *PrometheusSource) query(name string, query string, t time.Time, oldest time.Time) (model.Value, promv1.Warnings, error) {
+	start := time.Now()
+	result, warnings, err := queryAt(withQueryParams(context.Background(), p.queryParams(oldest)), query, p.api, t)
+	metrics.ObservePrometheusQuery(name, start, err)
+	return result, warnings, err
+}
+
 /*
  * Authors: Jessica Barai, Erik Wahlberger
  * Calculates the average CPU usage (in cores) over the specified resolution duration, and returns the average values between startTime and endTime
  */
-func GetAvgPodCpuUsageOverTime(node string, startTime time.Time, endTime time.Time, resolution time.Duration) (model.Matrix, promv1.Warnings, error) {
-	strBuilder := fmt.Sprintf("avg_over_time(sum by (pod) (irate(container_cpu_usage_seconds_total{instance = '%s', container != '', container != 'POD', pod != ''}[5m]))[%s:])", node, resolution)
+func (p *PrometheusSource) GetAvgPodCpuUsageOverTime(node string, startTime time.Time, endTime time.Time, resolution time.Duration) (model.Matrix, promv1.Warnings, error) {
+	strBuilder, err := p.render(QUERY_POD_CPU_USAGE, QueryData{Node: node, Resolution: resolution.String()}, startTime.Add(-resolution))
+
+	if err != nil {
+		return nil, nil, err
+	}
 
 	t := promv1.Range{Start: startTime, End: endTime, Step: resolution}
-	result, warnings, err := QueryOverTime(strBuilder, localAPI, t)
+	matrix, warnings, err := p.queryRange(QUERY_POD_CPU_USAGE, strBuilder, t)
 
 	if err != nil {
 		return nil, warnings, err
 	}
-
-	matrix := result.(model.Matrix)
 	/*
 		returnMap := make(map[string][]model.SamplePair)
 
@@ -93,18 +293,20 @@ func GetAvgPodCpuUsageOverTime(node string, startTime time.Time, endTime time.Ti
  * Authors: Jessica Barai, Erik Wahlberger
  * Calculates the average RAM usage (in bytes) over the specified resolution duration, and returns the average values between startTime and endTime
  */
-func GetAvgPodMemUsageOverTime(node string, startTime time.Time, endTime time.Time, resolution time.Duration) (model.Matrix, promv1.Warnings, error) {
-	strBuilder := fmt.Sprintf("avg_over_time(sum by (pod) (container_memory_usage_bytes{instance = '%s', container != '', container != 'POD', pod != ''})[%s:])", node, resolution)
+func (p *PrometheusSource) GetAvgPodMemUsageOverTime(node string, startTime time.Time, endTime time.Time, resolution time.Duration) (model.Matrix, promv1.Warnings, error) {
+	strBuilder, err := p.render(QUERY_POD_MEMORY_USAGE, QueryData{Node: node, Resolution: resolution.String()}, startTime.Add(-resolution))
+
+	if err != nil {
+		return nil, nil, err
+	}
 
 	t := promv1.Range{Start: startTime, End: endTime, Step: resolution}
-	result, warnings, err := QueryOverTime(strBuilder, localAPI, t)
+	matrix, warnings, err := p.queryRange(QUERY_POD_MEMORY_USAGE, strBuilder, t)
 
 	if err != nil {
 		return nil, warnings, err
 	}
 
-	matrix := result.(model.Matrix)
-
 	/*
 		returnMap := make(map[string][]model.SamplePair)
 
@@ -149,16 +351,40 @@ func matrixToVectorMap(matrix model.Matrix) (map[time.Time]model.Vector, error)
 	return vectorMap, nil
 }
 
-func GetAvgPodResourceUsageOverTime(node string, startTime time.Time, endTime time.Time, resolution time.Duration) ([]ResourceUsageSample, promv1.Warnings, error) {
-	//TODO: Is startTime before endTime?
+/*
+ * Returns the time stamps of the samples GetAvgPodResourceUsageOverTime returns between startTime and endTime
+ */
+func StepTimes(startTime time.Time, endTime time.Time, resolution time.Duration) []time.Time {
+	times := []time.Time{}
+
+	if resolution <= 0 {
+		return times
+	}
+
+	if endTime.Sub(startTime) >= resolution {
+		startTime = startTime.Add(resolution)
+	}
+
+	for t := startTime; !t.After(endTime); t = t.Add(resolution) {
+		times = append(times, t)
+	}
+
+	return times
+}
+
+func (p *PrometheusSource) GetAvgPodResourceUsageOverTime(node string, startTime time.Time, endTime time.Time, resolution time.Duration) ([]ResourceUsageSample, promv1.Warnings, error) {
+	if endTime.Before(startTime) {
+		return nil, nil, fmt.Errorf("The start time %s is after the end time %s", startTime, endTime)
+	}
+
 	duration := endTime.Sub(startTime)
 
 	if duration >= resolution {
 		startTime = startTime.Add(resolution)
 	}
 
-	cpuUsages, cpuWarnings, cpuErrors := GetAvgPodCpuUsageOverTime(node, startTime, endTime, resolution)
-	memUsages, memWarnings, memErrors := GetAvgPodMemUsageOverTime(node, startTime, endTime, resolution)
+	cpuUsages, cpuWarnings, cpuErrors := p.GetAvgPodCpuUsageOverTime(node, startTime, endTime, resolution)
+	memUsages, memWarnings, memErrors := p.GetAvgPodMemUsageOverTime(node, startTime, endTime, resolution)
 
 	var warnings []string
 
@@ -178,18 +404,26 @@ func GetAvgPodResourceUsageOverTime(node string, startTime time.Time, endTime ti
 		warnings = append(warnings, memWarnings...)
 	}
 
+	podsResourceUsages, err := p.queries.combineUsageMatrices(cpuUsages, memUsages)
+	return podsResourceUsages, warnings, err
+}
+
+/*
+ * Joins the CPU and memory usage series of the pods into one resource usage sample per time stamp
+ */
+func (q *Queries) combineUsageMatrices(cpuUsages model.Matrix, memUsages model.Matrix) ([]ResourceUsageSample, error) {
 	//fmt.Println("Cpu query:")
 	cpuUsageVectorMap, err := matrixToVectorMap(cpuUsages)
 
 	if err != nil {
-		return nil, warnings, err
+		return nil, err
 	}
 
 	//fmt.Println("Mem query:")
 	memUsageVectorMap, err := matrixToVectorMap(memUsages)
 
 	if err != nil {
-		return nil, warnings, err
+		return nil, err
 	}
 
 	podsResourceUsages := []ResourceUsageSample{}
@@ -202,10 +436,10 @@ func GetAvgPodResourceUsageOverTime(node string, startTime time.Time, endTime ti
 			continue
 		}
 
-		resourceUsages, err := getCombinedResourceUsage(cpuVector, memVector)
+		resourceUsages, err := q.getCombinedResourceUsage(cpuVector, memVector)
 
 		if err != nil {
-			return nil, warnings, err
+			return nil, err
 		}
 
 		podsInstantaneousUsage := ResourceUsageSample{
@@ -216,58 +450,258 @@ func GetAvgPodResourceUsageOverTime(node string, startTime time.Time, endTime ti
 		podsResourceUsages = append(podsResourceUsages, podsInstantaneousUsage)
 	}
 
-	return podsResourceUsages, warnings, nil
+	return podsResourceUsages, nil
+}
+
+/*
+ * Calculates the average CPU and RAM usage of every pod in the cluster with one query per resource, and splits the result by node.
+ * Returns a map with the node as key and the same samples as GetAvgPodResourceUsageOverTime returns for the node as value
+ */
+func (p *PrometheusSource) GetClusterPodResourceUsageOverTime(startTime time.Time, endTime time.Time, resolution time.Duration) (map[string][]ResourceUsageSample, promv1.Warnings, error) {
+	if endTime.Before(startTime) {
+		return nil, nil, fmt.Errorf("The start time %s is after the end time %s", startTime, endTime)
+	}
+
+	if endTime.Sub(startTime) >= resolution {
+		startTime = startTime.Add(resolution)
+	}
+
+	t := promv1.Range{Start: startTime, End: endTime, Step: resolution}
+	cpuQuery, err := p.render(QUERY_CLUSTER_POD_CPU_USAGE, QueryData{Resolution: resolution.String()}, startTime.Add(-resolution))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	memQuery, err := p.render(QUERY_CLUSTER_POD_MEMORY_USAGE, QueryData{Resolution: resolution.String()}, startTime.Add(-resolution))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cpuUsages, warnings, err := p.queryRange(QUERY_CLUSTER_POD_CPU_USAGE, cpuQuery, t)
+
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	memUsages, memWarnings, err := p.queryRange(QUERY_CLUSTER_POD_MEMORY_USAGE, memQuery, t)
+	warnings = append(warnings, memWarnings...)
+
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	nodeLabel := model.LabelName(p.queries.Label("cadvisor_node"))
+	cpuByNode := splitMatrixByLabel(cpuUsages, nodeLabel)
+	memByNode := splitMatrixByLabel(memUsages, nodeLabel)
+	resultMap := make(map[string][]ResourceUsageSample)
+
+	for node, nodeCpuUsages := range cpuByNode {
+		podsResourceUsages, err := p.queries.combineUsageMatrices(nodeCpuUsages, memByNode[node])
+
+		if err != nil {
+			return nil, warnings, err
+		}
+
+		resultMap[node] = podsResourceUsages
+	}
+
+	return resultMap, warnings, nil
+}
+
+/*
+ * Queries the CPU and memory capacity of every node with a single range query, at the same time stamps as GetClusterPodResourceUsageOverTime.
+ * Returns a map with the node as key and the capacities ordered by time as value
+ */
+func (p *PrometheusSource) GetClusterNodeCapacityOverTime(startTime time.Time, endTime time.Time, resolution time.Duration) (map[string][]NodeCapacity, promv1.Warnings, error) {
+	if endTime.Sub(startTime) >= resolution {
+		startTime = startTime.Add(resolution)
+	}
+
+	t := promv1.Range{Start: startTime, End: endTime, Step: resolution}
+	query, err := p.render(QUERY_CLUSTER_NODE_CAPACITY, QueryData{}, startTime)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	capacities, warnings, err := p.queryRange(QUERY_CLUSTER_NODE_CAPACITY, query, t)
+
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	resultMap := make(map[string]map[int64]*NodeCapacity)
+
+	for _, sampleStream := range capacities {
+		node := p.queries.labelOf(sampleStream.Metric, "node")
+		resource := p.queries.labelOf(sampleStream.Metric, "resource")
+		nodeCapacities, ok := resultMap[node]
+
+		if !ok {
+			nodeCapacities = make(map[int64]*NodeCapacity)
+			resultMap[node] = nodeCapacities
+		}
+
+		for _, samplePair := range sampleStream.Values {
+			capacity, ok := nodeCapacities[samplePair.Timestamp.Unix()]
+
+			if !ok {
+				capacity = &NodeCapacity{Time: samplePair.Timestamp.Time()}
+				nodeCapacities[samplePair.Timestamp.Unix()] = capacity
+			}
+
+			if resource == "cpu" {
+				capacity.CPU = float64(samplePair.Value)
+			} else {
+				capacity.Memory = float64(samplePair.Value)
+			}
+		}
+	}
+
+	return sortCapacities(resultMap), warnings, nil
+}
+
+func sortCapacities(capacityMap map[string]map[int64]*NodeCapacity) map[string][]NodeCapacity {
+	resultMap := make(map[string][]NodeCapacity)
+
+	for node, nodeCapacities := range capacityMap {
+		sorted := make([]NodeCapacity, 0, len(nodeCapacities))
+
+		for _, capacity := range nodeCapacities {
+			sorted = append(sorted, *capacity)
+		}
+
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Time.Before(sorted[j].Time)
+		})
+
+		resultMap[node] = sorted
+	}
+
+	return resultMap
+}
+
+/*
+ * Returns the latest capacity at or before t. If there is none, the earliest capacity is used instead
+ */
+func CapacityAt(capacities []NodeCapacity, t time.Time) (NodeCapacity, bool) {
+	if len(capacities) == 0 {
+		return NodeCapacity{}, false
+	}
+
+	capacity := capacities[0]
+
+	for _, c := range capacities {
+		if c.Time.After(t) {
+			break
+		}
+
+		capacity = c
+	}
+
+	return capacity, true
+}
+
+/*
+ * Splits the series of a matrix by the value of a label
+ */
+func splitMatrixByLabel(matrix model.Matrix, label model.LabelName) map[string]model.Matrix {
+	resultMap := make(map[string]model.Matrix)
+
+	for _, sampleStream := range matrix {
+		value := string(sampleStream.Metric[label])
+		resultMap[value] = append(resultMap[value], sampleStream)
+	}
+
+	return resultMap
 }
 
 // Gets available CPU capacity
-func GetCPUNodeCapacity(node string, t time.Time) (float64, promv1.Warnings, error) {
-	strBuilder := fmt.Sprintf("kube_node_status_capacity{resource='cpu', node='%s'}", node)
-	result, warnings, err := Query(strBuilder, localAPI, t)
-	vector := result.(model.Vector)
-	sample := vector[0]
-	valueField := sample.Value
-	value := float64(valueField)
-	return value, warnings, err
+func (p *PrometheusSource) GetCPUNodeCapacity(node string, t time.Time) (float64, promv1.Warnings, error) {
+	return p.getNodeResourceValue(QUERY_NODE_CAPACITY, "cpu", node, t)
 }
 
-func GetMemoryNodeCapacity(node string, t time.Time) (float64, promv1.Warnings, error) {
-	strBuilder := fmt.Sprintf("kube_node_status_capacity{resource='memory', node='%s'}", node)
-	result, warnings, err := Query(strBuilder, localAPI, t)
-	vector := result.(model.Vector)
-	sample := vector[0]
-	valueField := sample.Value
-	value := float64(valueField)
-	return value, warnings, err
+func (p *PrometheusSource) GetMemoryNodeCapacity(node string, t time.Time) (float64, promv1.Warnings, error) {
+	return p.getNodeResourceValue(QUERY_NODE_CAPACITY, "memory", node, t)
 }
 
-func GetCPUNodeUsage(t time.Time, node string) (float64, promv1.Warnings, error) {
-	return getNodeResourceUsageQuery("cpu", node, t)
+func (p *PrometheusSource) GetCPUNodeUsage(t time.Time, node string) (float64, promv1.Warnings, error) {
+	return p.getNodeResourceUsageQuery("cpu", node, t)
 }
 
-func GetMemoryNodeUsage(t time.Time, node string) (float64, promv1.Warnings, error) {
-	return getNodeResourceUsageQuery("memory", node, t)
+func (p *PrometheusSource) GetMemoryNodeUsage(t time.Time, node string) (float64, promv1.Warnings, error) {
+	return p.getNodeResourceUsageQuery("memory", node, t)
 }
 
-func getNodeResourceUsageQuery(resource string, node string, t time.Time) (float64, promv1.Warnings, error) {
-	resourceUsageQuery := fmt.Sprintf("kube_node_status_capacity{resource='%s', node='%s'} - avg_over_time(kube_node_status_allocatable{resource='%s', node='%s'}[1h])", resource, node, resource, node)
-	result, warnings, err := Query(resourceUsageQuery, localAPI, t)
-	vector := result.(model.Vector)
-	sample := vector[0]
-	valueField := sample.Value
-	value := float64(valueField)
-	return value, warnings, err
+func (p *PrometheusSource) getNodeResourceUsageQuery(resource string, node string, t time.Time) (float64, promv1.Warnings, error) {
+	return p.getNodeResourceValue(QUERY_NODE_RESOURCE_USAGE, resource, node, t)
+}
+
+/*
+ * Renders a query of a single resource of a node and returns the value of the resulting sample
+ */
+func (p *PrometheusSource) getNodeResourceValue(name string, resource string, node string, t time.Time) (float64, promv1.Warnings, error) {
+	query, err := p.render(name, QueryData{Node: node, Resource: resource}, t)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return p.getSingleValue(name, query, t)
+}
+
+/*
+ * Checks that Prometheus answers queries
+ */
+func (p *PrometheusSource) Ping() error {
+	now := time.Now()
+	_, _, err := p.query("ping", "vector(1)", now, now)
+	return err
+}
+
+/*
+ * Performs a query expected to return a single sample and returns the value of the sample
+ */
+func (p *PrometheusSource) getSingleValue(name string, query string, t time.Time) (float64, promv1.Warnings, error) {
+	result, warnings, err := p.query(name, query, t, t)
+
+	if err != nil {
+		return 0, warnings, err
+	}
+
+	vector, ok := result.(model.Vector)
+
+	if !ok {
+		return 0, warnings, fmt.Errorf("The query '%s' did not return a Vector.", query)
+	}
+
+	if len(vector) == 0 {
+		return 0, warnings, fmt.Errorf("The query '%s' returned empty result.", query)
+	}
+
+	return float64(vector[0].Value), warnings, nil
 }
 
 /*
  * Author: Erik Wahlberger
  * Retrieves a map of pod-CPU usage key-value pairs. CPU usage is given in the amount of CPU cores being used by each respective pod
  */
-func GetPodsCPUUsage(node string, startTime time.Time, endTime time.Time) (model.Vector, promv1.Warnings, error) {
-	//TODO: Check that endTime is after startTime, and that startTime is before time.Now()
+func (p *PrometheusSource) GetPodsCPUUsage(node string, startTime time.Time, endTime time.Time) (model.Vector, promv1.Warnings, error) {
+	if !startTime.Before(endTime) || startTime.After(time.Now()) {
+		return nil, nil, fmt.Errorf("Invalid time range between %s and %s", startTime, endTime)
+	}
+
 	duration := endTime.Sub(startTime)
 
-	resourceUsageQuery := fmt.Sprintf("avg_over_time(sum by (pod) (irate(container_cpu_usage_seconds_total{instance = '%s', container != '', container != 'POD', pod != ''}[5m]))[%s:])", node, duration)
-	result, warnings, err := Query(resourceUsageQuery, localAPI, endTime)
+	resourceUsageQuery, err := p.render(QUERY_POD_CPU_USAGE, QueryData{Node: node, Resolution: duration.String()}, startTime)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, warnings, err := p.query(QUERY_POD_CPU_USAGE, resourceUsageQuery, endTime, startTime)
 
 	if warnings != nil {
 		fmt.Println("Warnings when querying pod CPU usage: ", warnings)
@@ -295,12 +729,20 @@ func GetPodsCPUUsage(node string, startTime time.Time, endTime time.Time) (model
  * Author: Erik Wahlberger
  * Retrieves a map of pod-RAM usage key-value pairs. RAM usage is given in bytes being used by each respective pod
  */
-func GetPodsMemoryUsage(node string, startTime time.Time, endTime time.Time) (model.Vector, promv1.Warnings, error) {
-	//TODO: Check that endTime is after startTime, and that startTime is before time.Now()
+func (p *PrometheusSource) GetPodsMemoryUsage(node string, startTime time.Time, endTime time.Time) (model.Vector, promv1.Warnings, error) {
+	if !startTime.Before(endTime) || startTime.After(time.Now()) {
+		return nil, nil, fmt.Errorf("Invalid time range between %s and %s", startTime, endTime)
+	}
+
 	duration := endTime.Sub(startTime)
 
-	resourceUsageQuery := fmt.Sprintf("avg_over_time(sum by (pod) (container_memory_usage_bytes{instance='%s', container != '', container != 'POD', pod != ''})[%s:])", node, duration)
-	result, warnings, err := Query(resourceUsageQuery, localAPI, endTime)
+	resourceUsageQuery, err := p.render(QUERY_POD_MEMORY_USAGE, QueryData{Node: node, Resolution: duration.String()}, startTime)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, warnings, err := p.query(QUERY_POD_MEMORY_USAGE, resourceUsageQuery, endTime, startTime)
 
 	if err != nil {
 		return nil, warnings, err
@@ -319,22 +761,36 @@ func GetPodsMemoryUsage(node string, startTime time.Time, endTime time.Time) (mo
 	return vector, warnings, nil
 }
 
-func vectorToPodMap(vector model.Vector) map[string]float64 {
+func (q *Queries) vectorToPodMap(vector model.Vector) map[string]float64 {
 	usageMap := make(map[string]float64)
 
 	for _, sample := range vector {
-		labelSet := model.LabelSet(sample.Metric)
-		pod := string(labelSet["pod"])
+		pod := q.labelOf(sample.Metric, "pod")
 		usageMap[pod] = float64(sample.Value)
 	}
 
 	return usageMap
 }
 
-func getCombinedResourceUsage(cpuVector model.Vector, memVector model.Vector) (map[string]ResourceUsage, error) {
+/*
+ * Returns a map with pod as key and the namespace of the pod as value
+ */
+func (q *Queries) vectorToNamespaceMap(vector model.Vector) map[string]string {
+	namespaceMap := make(map[string]string)
+
+	for _, sample := range vector {
+		pod := q.labelOf(sample.Metric, "pod")
+		namespaceMap[pod] = q.labelOf(sample.Metric, "namespace")
+	}
+
+	return namespaceMap
+}
+
+func (q *Queries) getCombinedResourceUsage(cpuVector model.Vector, memVector model.Vector) (map[string]ResourceUsage, error) {
 	resources := make(map[string]ResourceUsage)
-	cpuUsages := vectorToPodMap(cpuVector)
-	memUsages := vectorToPodMap(memVector)
+	cpuUsages := q.vectorToPodMap(cpuVector)
+	memUsages := q.vectorToPodMap(memVector)
+	namespaces := q.vectorToNamespaceMap(cpuVector)
 
 	for pod, cpuValue := range cpuUsages {
 		memValue, ok := memUsages[pod]
@@ -345,17 +801,18 @@ func getCombinedResourceUsage(cpuVector model.Vector, memVector model.Vector) (m
 		}
 
 		resources[pod] = ResourceUsage{
-			CpuUsage: cpuValue,
-			MemUsage: memValue,
+			Namespace: namespaces[pod],
+			CpuUsage:  cpuValue,
+			MemUsage:  memValue,
 		}
 	}
 
 	return resources, nil
 }
 
-func GetPodsResourceUsage(node string, startTime time.Time, endTime time.Time) (ResourceUsageSample, promv1.Warnings, error) {
-	cpuUsages, cpuWarnings, cpuErrors := GetPodsCPUUsage(node, startTime, endTime)
-	memUsages, memWarnings, memErrors := GetPodsMemoryUsage(node, startTime, endTime)
+func (p *PrometheusSource) GetPodsResourceUsage(node string, startTime time.Time, endTime time.Time) (ResourceUsageSample, promv1.Warnings, error) {
+	cpuUsages, cpuWarnings, cpuErrors := p.GetPodsCPUUsage(node, startTime, endTime)
+	memUsages, memWarnings, memErrors := p.GetPodsMemoryUsage(node, startTime, endTime)
 
 	var warnings []string
 
@@ -375,7 +832,7 @@ func GetPodsResourceUsage(node string, startTime time.Time, endTime time.Time) (
 		warnings = append(warnings, memWarnings...)
 	}
 
-	resourceUsages, err := getCombinedResourceUsage(cpuUsages, memUsages)
+	resourceUsages, err := p.queries.getCombinedResourceUsage(cpuUsages, memUsages)
 
 	podsResourceUsage := ResourceUsageSample{
 		Time:           cpuUsages[0].Timestamp.Time(),
@@ -389,10 +846,16 @@ func GetPodsResourceUsage(node string, startTime time.Time, endTime time.Time) (
  *Returns map with replicaset as key and deployment it belong to as value
  *
  */
-func getReplicasetToDeployment(t time.Time, duration time.Duration) (map[string]string, promv1.Warnings, error) {
+func (p *PrometheusSource) getReplicasetToDeployment(t time.Time, duration time.Duration) (map[string]string, promv1.Warnings, error) {
 	//creat query that gets all pods in cluster
 
-	result, warnings, err := Query(fmt.Sprintf("count_over_time(kube_replicaset_owner{owner_kind='Deployment'}[%s])", duration), localAPI, t)
+	query, err := p.render(QUERY_REPLICASET_OWNERS, QueryData{Duration: duration.String()}, t.Add(-duration))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, warnings, err := p.query(QUERY_REPLICASET_OWNERS, query, t, t.Add(-duration))
 	vector, ok := result.(model.Vector)
 
 	if !ok {
@@ -405,9 +868,8 @@ func getReplicasetToDeployment(t time.Time, duration time.Duration) (map[string]
 	resultMap := make(map[string]string)
 
 	for _, sample := range vector {
-		labelSet := model.LabelSet(sample.Metric)
-		replicaSet := string(labelSet["replicaset"])
-		deployment := string(labelSet["owner_name"])
+		replicaSet := p.queries.labelOf(sample.Metric, "replicaset")
+		deployment := p.queries.labelOf(sample.Metric, "owner_name")
 
 		//fmt.Printf("Pod %s is running on node %s and is currently using %.6f cores of CPU.\n", pod, node, float64(sample.Value))
 		resultMap[replicaSet] = deployment
@@ -421,10 +883,16 @@ func getReplicasetToDeployment(t time.Time, duration time.Duration) (map[string]
 *Returns a map with pod as key and which replicaset it belongs to as value
 *
  */
-func getPodsToReplicaset(t time.Time, duration time.Duration) (map[string]string, promv1.Warnings, error) {
+func (p *PrometheusSource) getPodsToReplicaset(t time.Time, duration time.Duration) (map[string]string, promv1.Warnings, error) {
 	//creat query that gets all pods in cluster
 
-	result, warnings, err := Query(fmt.Sprintf("count_over_time(kube_pod_owner{owner_kind='ReplicaSet'}[%s])", duration), localAPI, t)
+	query, err := p.render(QUERY_POD_OWNERS, QueryData{Duration: duration.String()}, t.Add(-duration))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, warnings, err := p.query(QUERY_POD_OWNERS, query, t, t.Add(-duration))
 
 	vector, ok := result.(model.Vector)
 
@@ -438,9 +906,8 @@ func getPodsToReplicaset(t time.Time, duration time.Duration) (map[string]string
 	resultMap := make(map[string]string)
 
 	for _, sample := range vector {
-		labelSet := model.LabelSet(sample.Metric)
-		pod := string(labelSet["pod"])
-		replicaset := string(labelSet["owner_name"])
+		pod := p.queries.labelOf(sample.Metric, "pod")
+		replicaset := p.queries.labelOf(sample.Metric, "owner_name")
 
 		//fmt.Printf("Pod %s is running on node %s and is currently using %.6f cores of CPU.\n", pod, node, float64(sample.Value))
 		resultMap[pod] = replicaset
@@ -454,18 +921,18 @@ func getPodsToReplicaset(t time.Time, duration time.Duration) (map[string]string
 *Returns map with keys as pod and value as deployment
 *Gives out which deployment each pod belongs to
  */
-func GetPodsToDeployment(t time.Time, duration time.Duration) map[string]string {
+func (p *PrometheusSource) GetPodsToDeployment(t time.Time, duration time.Duration) map[string]string {
 	repTodep := make(map[string]string)
 	podsToRep := make(map[string]string)
 	resultMap := make(map[string]string)
-	podsToRep, warnings, err := getPodsToReplicaset(t, duration)
+	podsToRep, warnings, err := p.getPodsToReplicaset(t, duration)
 	if warnings != nil {
 		println("kube_pod_owner warning")
 	}
 	if err != nil {
 		println("kube_pod_owner error")
 	}
-	repTodep, warnings, err = getReplicasetToDeployment(t, duration)
+	repTodep, warnings, err = p.getReplicasetToDeployment(t, duration)
 	if warnings != nil {
 		println("kube_pod_owner warning")
 	}
@@ -486,13 +953,122 @@ func GetPodsToDeployment(t time.Time, duration time.Duration) map[string]string
 
 }
 
+/*
+*Returns a map with pod as key and the owner of the pod as value. Pods of a ReplicaSet owned by a Deployment get the Deployment as owner,
+*pods without an owner get the kind "<none>" like in kube_pod_owner
+ */
+func (p *PrometheusSource) GetPodOwners(t time.Time, duration time.Duration) (map[string]PodOwner, promv1.Warnings, error) {
+	query, err := p.render(QUERY_ALL_POD_OWNERS, QueryData{Duration: duration.String()}, t.Add(-duration))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, warnings, err := p.query(QUERY_ALL_POD_OWNERS, query, t, t.Add(-duration))
+
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	vector, ok := result.(model.Vector)
+
+	if !ok {
+		return nil, warnings, fmt.Errorf("Pod owner query did not return a Vector.")
+	}
+
+	resultMap := make(map[string]PodOwner)
+	hasReplicaSets := false
+
+	for _, sample := range vector {
+		owner := PodOwner{Kind: p.queries.labelOf(sample.Metric, "owner_kind"), Name: p.queries.labelOf(sample.Metric, "owner_name")}
+		resultMap[p.queries.labelOf(sample.Metric, "pod")] = owner
+		hasReplicaSets = hasReplicaSets || owner.Kind == "ReplicaSet"
+	}
+
+	if !hasReplicaSets {
+		return resultMap, warnings, nil
+	}
+
+	replicaSets, replicaSetWarnings, err := p.getReplicasetToDeployment(t, duration)
+	warnings = append(warnings, replicaSetWarnings...)
+
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	for pod, owner := range resultMap {
+		if deployment, ok := replicaSets[owner.Name]; ok && owner.Kind == "ReplicaSet" {
+			resultMap[pod] = PodOwner{Kind: "Deployment", Name: deployment}
+		}
+	}
+
+	return resultMap, warnings, nil
+}
+
+/*
+*Returns a map with pod as key and the labels of the pod as value, taken from kube_pod_labels.
+*The label names are sanitized the way kube-state-metrics does it, see SanitizeLabelName
+ */
+func (p *PrometheusSource) GetPodLabels(t time.Time, duration time.Duration) (map[string]map[string]string, promv1.Warnings, error) {
+	query, err := p.render(QUERY_POD_LABELS, QueryData{Duration: duration.String()}, t.Add(-duration))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, warnings, err := p.query(QUERY_POD_LABELS, query, t, t.Add(-duration))
+
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	vector, ok := result.(model.Vector)
+
+	if !ok {
+		return nil, nil, fmt.Errorf("Pod labels query did not return a Vector.")
+	}
+
+	resultMap := make(map[string]map[string]string)
+
+	for _, sample := range vector {
+		labelSet := model.LabelSet(sample.Metric)
+		pod := p.queries.labelOf(sample.Metric, "pod")
+		podLabels, ok := resultMap[pod]
+
+		if !ok {
+			podLabels = make(map[string]string)
+			resultMap[pod] = podLabels
+		}
+
+		for name, value := range labelSet {
+			if strings.HasPrefix(string(name), POD_LABEL_PREFIX) {
+				podLabels[strings.TrimPrefix(string(name), POD_LABEL_PREFIX)] = string(value)
+			}
+		}
+	}
+
+	return resultMap, warnings, nil
+}
+
+/*
+*Converts a Kubernetes label name, e.g. app.kubernetes.io/name, to the name used by kube-state-metrics without the "label_" prefix, e.g. app_kubernetes_io_name
+ */
+func SanitizeLabelName(name string) string {
+	return invalidLabelCharacters.ReplaceAllString(name, "_")
+}
+
 /*
 *Returns a string slice with all pods in a specific node. Take in node as argument
 *
  */
-func GetPodsOfNode(t time.Time, node string, duration time.Duration) ([]string, promv1.Warnings, error) {
-	strBuilder := fmt.Sprintf("count_over_time(kube_pod_info{node='%s'}[%s])", node, duration)
-	result, warnings, err := Query(strBuilder, localAPI, t)
+func (p *PrometheusSource) GetPodsOfNode(t time.Time, node string, duration time.Duration) ([]string, promv1.Warnings, error) {
+	strBuilder, err := p.render(QUERY_PODS_OF_NODE, QueryData{Node: node, Duration: duration.String()}, t.Add(-duration))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, warnings, err := p.query(QUERY_PODS_OF_NODE, strBuilder, t, t.Add(-duration))
 
 	vector, ok := result.(model.Vector)
 
@@ -507,16 +1083,10 @@ func GetPodsOfNode(t time.Time, node string, duration time.Duration) ([]string,
 	a := []string{}
 
 	for _, sample := range vector {
-		labelSet := model.LabelSet(sample.Metric)
-		//node := string(labelSet["node"])
-		pod := string(labelSet["pod"])
+		pod := p.queries.labelOf(sample.Metric, "pod")
 
 		//fmt.Printf("Pod %s is running on node %s and is currently using %.6f cores of CPU.\n", pod, node, float64(sample.Value))
 		a = append(a, pod)
 	}
 	return a, warnings, err
 }
-
-type prometheusInterface interface {
-	GetCPUNodeCapacity(node string) (float64, promv1.Warnings, error)
-}
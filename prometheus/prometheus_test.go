@@ -1,8 +1,15 @@
 package prometheus
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -11,3 +18,177 @@ func TestImportantFunction(t *testing.T) {
 	v := ImportantFunction()
 	assert.Equal(t, v, 3)
 }
+
+func TestSanitizeLabelName(t *testing.T) {
+	assert.Equal(t, "app_kubernetes_io_name", SanitizeLabelName("app.kubernetes.io/name"))
+	assert.Equal(t, "team", SanitizeLabelName("team"))
+}
+
+func TestStepTimes(t *testing.T) {
+	start := time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC)
+
+	times := StepTimes(start, start.Add(3*time.Hour), time.Hour)
+	assert.Equal(t, []time.Time{start.Add(time.Hour), start.Add(2 * time.Hour), start.Add(3 * time.Hour)}, times)
+
+	times = StepTimes(start, start.Add(30*time.Minute), time.Hour)
+	assert.Equal(t, []time.Time{start}, times)
+
+	assert.Empty(t, StepTimes(start, start.Add(time.Hour), 0))
+}
+
+func TestGetCPUNodeCapacity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if strings.Contains(r.Form.Get("query"), "node-1") {
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"node":"node-1"},"value":[1640304000,"4"]}]}}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer server.Close()
+
+	source, err := NewPrometheusSource(server.URL)
+	assert.Nil(t, err)
+
+	capacity, _, err := source.GetCPUNodeCapacity("node-1", time.Unix(1640304000, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, 4.0, capacity)
+
+	_, _, err = source.GetCPUNodeCapacity("node-2", time.Unix(1640304000, 0))
+	assert.NotNil(t, err)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"node":"node-1"},"value":[1640304000.371,"4"]}]}}`))
+	}))
+	defer server.Close()
+
+	directory := t.TempDir()
+	source, err := NewPrometheusSource(server.URL)
+	assert.Nil(t, err)
+	assert.Nil(t, source.Record(directory))
+
+	queryTime := time.Unix(1640304000, 371000000)
+	capacity, _, err := source.GetCPUNodeCapacity("node-1", queryTime)
+	assert.Nil(t, err)
+	assert.Equal(t, 4.0, capacity)
+
+	handler, err := NewReplayHandler(directory)
+	assert.Nil(t, err)
+	replayServer := httptest.NewServer(handler)
+	defer replayServer.Close()
+
+	replaySource, err := NewPrometheusSource(replayServer.URL)
+	assert.Nil(t, err)
+
+	capacity, _, err = replaySource.GetCPUNodeCapacity("node-1", queryTime)
+	assert.Nil(t, err)
+	assert.Equal(t, 4.0, capacity)
+
+	// Only the recorded time stamp is replayed
+	_, _, err = replaySource.GetCPUNodeCapacity("node-1", queryTime.Add(time.Second))
+	assert.NotNil(t, err)
+}
+
+func TestSplitRange(t *testing.T) {
+	start := time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC)
+	r := promv1.Range{Start: start, End: start.Add(9 * time.Hour), Step: time.Hour}
+
+	chunks := SplitRange(r, 4)
+	assert.Equal(t, []promv1.Range{
+		{Start: start, End: start.Add(3 * time.Hour), Step: time.Hour},
+		{Start: start.Add(4 * time.Hour), End: start.Add(7 * time.Hour), Step: time.Hour},
+		{Start: start.Add(8 * time.Hour), End: start.Add(9 * time.Hour), Step: time.Hour},
+	}, chunks)
+
+	assert.Equal(t, []promv1.Range{r}, SplitRange(r, MAX_POINTS_PER_QUERY))
+}
+
+func TestChunkedRangeQuery(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests++
+		start, _ := strconv.ParseFloat(r.Form.Get("start"), 64)
+		end, _ := strconv.ParseFloat(r.Form.Get("end"), 64)
+		values := []string{}
+		for ts := start; ts <= end; ts += 3600 {
+			values = append(values, fmt.Sprintf(`[%.0f,"%.0f"]`, ts, ts))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"namespace":"shop","pod":"web-1"},"values":[%s]}]}}`, strings.Join(values, ","))
+	}))
+	defer server.Close()
+
+	source, err := NewPrometheusSource(server.URL)
+	assert.Nil(t, err)
+	source.SetChunkPoints(2)
+
+	start := time.Unix(1640304000, 0)
+	matrix, _, err := source.GetAvgPodCpuUsageOverTime("node-1", start, start.Add(4*time.Hour), time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, requests)
+	assert.Len(t, matrix, 1)
+	assert.Len(t, matrix[0].Values, 5)
+
+	for i, value := range matrix[0].Values {
+		assert.True(t, value.Timestamp.Time().Equal(start.Add(time.Duration(i)*time.Hour)))
+	}
+
+	// By default the longest range a request may ask for is split as well, its 11001 steps into chunks of 1000
+	source, err = NewPrometheusSource(server.URL)
+	assert.Nil(t, err)
+	requests = 0
+	_, _, err = source.GetAvgPodCpuUsageOverTime("node-1", start, start.Add(MAX_POINTS_PER_QUERY*time.Hour), time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 12, requests)
+}
+
+func TestClusterQueries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests++
+		query := r.Form.Get("query")
+		switch {
+		case strings.Contains(query, "kube_node_status_capacity"):
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{"node":"node-1","resource":"cpu"},"values":[[1640307600,"4"],[1640311200,"4"]]},
+				{"metric":{"node":"node-1","resource":"memory"},"values":[[1640307600,"16"],[1640311200,"32"]]},
+				{"metric":{"node":"node-2","resource":"cpu"},"values":[[1640307600,"2"],[1640311200,"2"]]},
+				{"metric":{"node":"node-2","resource":"memory"},"values":[[1640307600,"8"],[1640311200,"8"]]}]}}`))
+		default:
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{"instance":"node-1","namespace":"shop","pod":"web-1"},"values":[[1640307600,"1"],[1640311200,"2"]]},
+				{"metric":{"instance":"node-2","namespace":"shop","pod":"web-2"},"values":[[1640307600,"0.5"],[1640311200,"0.5"]]}]}}`))
+		}
+	}))
+	defer server.Close()
+
+	source, err := NewPrometheusSource(server.URL)
+	assert.Nil(t, err)
+
+	start := time.Unix(1640304000, 0)
+	usages, _, err := source.GetClusterPodResourceUsageOverTime(start, start.Add(2*time.Hour), time.Hour)
+	assert.Nil(t, err)
+	assert.Len(t, usages, 2)
+	assert.Len(t, usages["node-1"], 2)
+	assert.Len(t, usages["node-1"][0].ResourceUsages, 1)
+	assert.Equal(t, "shop", usages["node-2"][0].ResourceUsages["web-2"].Namespace)
+
+	capacities, _, err := source.GetClusterNodeCapacityOverTime(start, start.Add(2*time.Hour), time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, requests)
+
+	capacity, ok := CapacityAt(capacities["node-1"], start.Add(90*time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, 4.0, capacity.CPU)
+	assert.Equal(t, 16.0, capacity.Memory)
+
+	capacity, ok = CapacityAt(capacities["node-1"], start.Add(2*time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, 32.0, capacity.Memory)
+
+	_, ok = CapacityAt(capacities["node-3"], start)
+	assert.False(t, ok)
+}
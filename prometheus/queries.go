@@ -0,0 +1,237 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// The names of the queries that can be replaced in a query configuration
+const (
+	QUERY_POD_CPU_USAGE             = "podCpuUsage"
+	QUERY_POD_MEMORY_USAGE          = "podMemoryUsage"
+	QUERY_CLUSTER_POD_CPU_USAGE     = "clusterPodCpuUsage"
+	QUERY_CLUSTER_POD_MEMORY_USAGE  = "clusterPodMemoryUsage"
+	QUERY_CLUSTER_NODE_CAPACITY     = "clusterNodeCapacity"
+	QUERY_NODE_CAPACITY             = "nodeCapacity"
+	QUERY_NODE_RESOURCE_USAGE       = "nodeResourceUsage"
+	QUERY_REPLICASET_OWNERS         = "replicasetOwners"
+	QUERY_POD_OWNERS                = "podOwners"
+	QUERY_ALL_POD_OWNERS            = "allPodOwners"
+	QUERY_POD_LABELS                = "podLabels"
+	QUERY_PODS_OF_NODE              = "podsOfNode"
+	QUERY_CONTAINER_CPU_QUANTILE    = "containerCpuQuantile"
+	QUERY_CONTAINER_MEMORY_QUANTILE = "containerMemoryQuantile"
+	QUERY_CONTAINER_REQUESTS        = "containerRequests"
+	QUERY_CONTAINER_LIMITS          = "containerLimits"
+)
+
+/*
+ * The metric names, label names and PromQL templates the queries are built from.
+ * Every template is a Go text/template executed with a QueryData value, so a template can refer to e.g. {{.Metrics.cpu_usage}} or {{.Labels.cadvisor_node}}.
+ * The results are read with the configured label names: namespace and pod for the usage queries, node and resource for the capacity query,
+ * replicaset, owner_kind, owner_name and pod for the owner queries, cadvisor_node for the cluster usage queries, namespace, pod and container
+ * for the container usage queries and additionally node and resource for the container request and limit queries
+ */
+type QueryConfig struct {
+	// The window of the irate in the CPU usage queries, e.g. 5m. Should be at least four times the scrape interval
+	RateWindow string            `json:"rateWindow"`
+	Labels     map[string]string `json:"labels"`
+	Metrics    map[string]string `json:"metrics"`
+	Templates  map[string]string `json:"templates"`
+}
+
+// The values a query template is executed with
+type QueryData struct {
+	Node       string
+	Resource   string
+	Resolution string
+	Duration   string
+	// The quantile of the quantile_over_time queries, e.g. 0.95
+	Quantile string
+	// Defaults to the configured rate window
+	RateWindow string
+	Labels     map[string]string
+	Metrics    map[string]string
+}
+
+// A parsed QueryConfig
+type Queries struct {
+	config    QueryConfig
+	templates map[string]*template.Template
+}
+
+/*
+ * Returns the configuration producing the queries used by default. The node label of cAdvisor is called instance,
+ * since the kubelet is scraped with the node name as target, while kube-state-metrics calls it node
+ */
+func DefaultQueryConfig() QueryConfig {
+	return QueryConfig{
+		RateWindow: "5m",
+		Labels: map[string]string{
+			"cadvisor_node": "instance",
+			"node":          "node",
+			"namespace":     "namespace",
+			"pod":           "pod",
+			"container":     "container",
+			"resource":      "resource",
+			"replicaset":    "replicaset",
+			"owner_kind":    "owner_kind",
+			"owner_name":    "owner_name",
+		},
+		Metrics: map[string]string{
+			"cpu_usage":          "container_cpu_usage_seconds_total",
+			"memory_usage":       "container_memory_usage_bytes",
+			"node_capacity":      "kube_node_status_capacity",
+			"node_allocatable":   "kube_node_status_allocatable",
+			"replicaset_owner":   "kube_replicaset_owner",
+			"pod_owner":          "kube_pod_owner",
+			"pod_labels":         "kube_pod_labels",
+			"pod_info":           "kube_pod_info",
+			"memory_working_set": "container_memory_working_set_bytes",
+			"container_requests": "kube_pod_container_resource_requests",
+			"container_limits":   "kube_pod_container_resource_limits",
+		},
+		// A '{' directly followed by an action is written as '{ {{-', since '{{{' does not parse
+		Templates: map[string]string{
+			QUERY_POD_CPU_USAGE:             "avg_over_time(sum by ({{.Labels.namespace}}, {{.Labels.pod}}) (irate({{.Metrics.cpu_usage}}{ {{- .Labels.cadvisor_node}} = '{{.Node}}', {{.Labels.container}} != '', {{.Labels.container}} != 'POD', {{.Labels.pod}} != ''}[{{.RateWindow}}]))[{{.Resolution}}:])",
+			QUERY_POD_MEMORY_USAGE:          "avg_over_time(sum by ({{.Labels.namespace}}, {{.Labels.pod}}) ({{.Metrics.memory_usage}}{ {{- .Labels.cadvisor_node}} = '{{.Node}}', {{.Labels.container}} != '', {{.Labels.container}} != 'POD', {{.Labels.pod}} != ''})[{{.Resolution}}:])",
+			QUERY_CLUSTER_POD_CPU_USAGE:     "avg_over_time(sum by ({{.Labels.cadvisor_node}}, {{.Labels.namespace}}, {{.Labels.pod}}) (irate({{.Metrics.cpu_usage}}{ {{- .Labels.container}} != '', {{.Labels.container}} != 'POD', {{.Labels.pod}} != ''}[{{.RateWindow}}]))[{{.Resolution}}:])",
+			QUERY_CLUSTER_POD_MEMORY_USAGE:  "avg_over_time(sum by ({{.Labels.cadvisor_node}}, {{.Labels.namespace}}, {{.Labels.pod}}) ({{.Metrics.memory_usage}}{ {{- .Labels.container}} != '', {{.Labels.container}} != 'POD', {{.Labels.pod}} != ''})[{{.Resolution}}:])",
+			QUERY_CLUSTER_NODE_CAPACITY:     "max by ({{.Labels.node}}, {{.Labels.resource}}) ({{.Metrics.node_capacity}}{ {{- .Labels.resource}}=~'cpu|memory'})",
+			QUERY_NODE_CAPACITY:             "{{.Metrics.node_capacity}}{ {{- .Labels.resource}}='{{.Resource}}', {{.Labels.node}}='{{.Node}}'}",
+			QUERY_NODE_RESOURCE_USAGE:       "{{.Metrics.node_capacity}}{ {{- .Labels.resource}}='{{.Resource}}', {{.Labels.node}}='{{.Node}}'} - avg_over_time({{.Metrics.node_allocatable}}{ {{- .Labels.resource}}='{{.Resource}}', {{.Labels.node}}='{{.Node}}'}[1h])",
+			QUERY_REPLICASET_OWNERS:         "count_over_time({{.Metrics.replicaset_owner}}{ {{- .Labels.owner_kind}}='Deployment'}[{{.Duration}}])",
+			QUERY_POD_OWNERS:                "count_over_time({{.Metrics.pod_owner}}{ {{- .Labels.owner_kind}}='ReplicaSet'}[{{.Duration}}])",
+			QUERY_ALL_POD_OWNERS:            "count_over_time({{.Metrics.pod_owner}}[{{.Duration}}])",
+			QUERY_POD_LABELS:                "max_over_time({{.Metrics.pod_labels}}[{{.Duration}}])",
+			QUERY_PODS_OF_NODE:              "count_over_time({{.Metrics.pod_info}}{ {{- .Labels.node}}='{{.Node}}'}[{{.Duration}}])",
+			QUERY_CONTAINER_CPU_QUANTILE:    "quantile_over_time({{.Quantile}}, sum by ({{.Labels.namespace}}, {{.Labels.pod}}, {{.Labels.container}}) (irate({{.Metrics.cpu_usage}}{ {{- .Labels.container}} != '', {{.Labels.container}} != 'POD', {{.Labels.pod}} != ''}[{{.RateWindow}}]))[{{.Duration}}:{{.Resolution}}])",
+			QUERY_CONTAINER_MEMORY_QUANTILE: "quantile_over_time({{.Quantile}}, sum by ({{.Labels.namespace}}, {{.Labels.pod}}, {{.Labels.container}}) ({{.Metrics.memory_working_set}}{ {{- .Labels.container}} != '', {{.Labels.container}} != 'POD', {{.Labels.pod}} != ''})[{{.Duration}}:{{.Resolution}}])",
+			QUERY_CONTAINER_REQUESTS:        "max by ({{.Labels.namespace}}, {{.Labels.pod}}, {{.Labels.container}}, {{.Labels.node}}, {{.Labels.resource}}) (max_over_time({{.Metrics.container_requests}}{ {{- .Labels.resource}}=~'cpu|memory'}[{{.Duration}}]))",
+			QUERY_CONTAINER_LIMITS:          "max by ({{.Labels.namespace}}, {{.Labels.pod}}, {{.Labels.container}}, {{.Labels.node}}, {{.Labels.resource}}) (max_over_time({{.Metrics.container_limits}}{ {{- .Labels.resource}}=~'cpu|memory'}[{{.Duration}}]))",
+		},
+	}
+}
+
+/*
+ * Returns the default queries
+ */
+func DefaultQueries() *Queries {
+	queries, err := NewQueries(QueryConfig{})
+
+	if err != nil {
+		panic(err)
+	}
+
+	return queries
+}
+
+/*
+ * Parses the templates of the configuration. Rate window, labels, metrics and templates missing from the configuration are taken from DefaultQueryConfig
+ */
+func NewQueries(config QueryConfig) (*Queries, error) {
+	merged := DefaultQueryConfig()
+
+	if config.RateWindow != "" {
+		merged.RateWindow = config.RateWindow
+	}
+
+	for name, label := range config.Labels {
+		merged.Labels[name] = label
+	}
+
+	for name, metric := range config.Metrics {
+		merged.Metrics[name] = metric
+	}
+
+	for name, text := range config.Templates {
+		if _, ok := merged.Templates[name]; !ok {
+			return nil, fmt.Errorf("Unknown query '%s'", name)
+		}
+
+		merged.Templates[name] = text
+	}
+
+	queries := &Queries{config: merged, templates: make(map[string]*template.Template)}
+
+	for name, text := range merged.Templates {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid template for query '%s': %v", name, err)
+		}
+
+		queries.templates[name] = tmpl
+	}
+
+	// Render every query once, so that references to unknown labels or metrics are found at startup
+	for name := range queries.templates {
+		_, err := queries.render(name, QueryData{Node: "node", Resource: "cpu", Resolution: time.Hour.String(), Duration: time.Hour.String(), Quantile: "0.95"})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return queries, nil
+}
+
+/*
+ * Reads a JSON query configuration from the path, see QueryConfig
+ */
+func LoadQueries(path string) (*Queries, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var config QueryConfig
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("Could not parse the query configuration %s: %v", path, err)
+	}
+
+	return NewQueries(config)
+}
+
+// Returns the configured name of the label, e.g. the label holding the node name in the cAdvisor metrics for cadvisor_node
+func (q *Queries) Label(name string) string {
+	return q.config.Labels[name]
+}
+
+// Returns the value of the configured label in the metric of a query result, e.g. the pod name for pod
+func (q *Queries) labelOf(metric model.Metric, name string) string {
+	return string(metric[model.LabelName(q.Label(name))])
+}
+
+/*
+ * Executes the template of the query with the data, filling in the configured rate window, labels and metrics
+ */
+func (q *Queries) render(name string, data QueryData) (string, error) {
+	tmpl, ok := q.templates[name]
+
+	if !ok {
+		return "", fmt.Errorf("Unknown query '%s'", name)
+	}
+
+	if data.RateWindow == "" {
+		data.RateWindow = q.config.RateWindow
+	}
+	data.Labels = q.config.Labels
+	data.Metrics = q.config.Metrics
+
+	var builder strings.Builder
+
+	if err := tmpl.Execute(&builder, data); err != nil {
+		return "", fmt.Errorf("Could not render query '%s': %v", name, err)
+	}
+
+	return builder.String(), nil
+}
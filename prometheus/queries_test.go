@@ -0,0 +1,141 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultQueries(t *testing.T) {
+	queries := DefaultQueries()
+
+	query, err := queries.render(QUERY_POD_CPU_USAGE, QueryData{Node: "node-1", Resolution: time.Hour.String()})
+	assert.Nil(t, err)
+	assert.Equal(t, "avg_over_time(sum by (namespace, pod) (irate(container_cpu_usage_seconds_total{instance = 'node-1', container != '', container != 'POD', pod != ''}[5m]))[1h0m0s:])", query)
+
+	query, err = queries.render(QUERY_PODS_OF_NODE, QueryData{Node: "node-1", Duration: time.Hour.String()})
+	assert.Nil(t, err)
+	assert.Equal(t, "count_over_time(kube_pod_info{node='node-1'}[1h0m0s])", query)
+}
+
+func TestConfiguredQueries(t *testing.T) {
+	queries, err := NewQueries(QueryConfig{
+		RateWindow: "2m",
+		Labels:     map[string]string{"cadvisor_node": "node"},
+		Metrics:    map[string]string{"node_capacity": "kube_node_status_allocatable"},
+		Templates:  map[string]string{QUERY_POD_LABELS: "max_over_time({{.Metrics.pod_labels}}{namespace!='kube-system'}[{{.Duration}}])"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "node", queries.Label("cadvisor_node"))
+
+	query, err := queries.render(QUERY_CLUSTER_POD_CPU_USAGE, QueryData{Resolution: "1h0m0s"})
+	assert.Nil(t, err)
+	assert.Equal(t, "avg_over_time(sum by (node, namespace, pod) (irate(container_cpu_usage_seconds_total{container != '', container != 'POD', pod != ''}[2m]))[1h0m0s:])", query)
+
+	query, err = queries.render(QUERY_NODE_CAPACITY, QueryData{Node: "node-1", Resource: "cpu"})
+	assert.Nil(t, err)
+	assert.Equal(t, "kube_node_status_allocatable{resource='cpu', node='node-1'}", query)
+
+	query, err = queries.render(QUERY_POD_LABELS, QueryData{Duration: "1h0m0s"})
+	assert.Nil(t, err)
+	assert.Equal(t, "max_over_time(kube_pod_labels{namespace!='kube-system'}[1h0m0s])", query)
+}
+
+func TestInvalidQueries(t *testing.T) {
+	_, err := NewQueries(QueryConfig{Templates: map[string]string{"unknown": "up"}})
+	assert.NotNil(t, err)
+
+	_, err = NewQueries(QueryConfig{Templates: map[string]string{QUERY_POD_LABELS: "{{.Metrics.unknown}}"}})
+	assert.NotNil(t, err)
+
+	_, err = NewQueries(QueryConfig{Templates: map[string]string{QUERY_POD_LABELS: "{{.Metrics"}})
+	assert.NotNil(t, err)
+}
+
+/*
+ * A Prometheus server whose series only carry relabelled names, e.g. pod_name instead of pod, queried with a configuration mapping the labels
+ */
+func TestRemappedLabels(t *testing.T) {
+	series := func(resultType string, results ...string) []byte {
+		return []byte(`{"status":"success","data":{"resultType":"` + resultType + `","result":[` + strings.Join(results, ",") + `]}}`)
+	}
+	queries := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		query := r.Form.Get("query")
+		queries = append(queries, query)
+
+		switch {
+		case strings.Contains(query, "container_cpu_usage_seconds_total"):
+			w.Write(series("matrix",
+				`{"metric":{"kubernetes_node":"node-1","kubernetes_namespace":"shop","pod_name":"web-1"},"values":[[1640307600,"0.5"]]}`,
+				`{"metric":{"kubernetes_node":"node-1","kubernetes_namespace":"kube-system","pod_name":"coredns-1"},"values":[[1640307600,"0.1"]]}`))
+		case strings.Contains(query, "container_memory_usage_bytes"):
+			w.Write(series("matrix",
+				`{"metric":{"kubernetes_node":"node-1","kubernetes_namespace":"shop","pod_name":"web-1"},"values":[[1640307600,"2000"]]}`,
+				`{"metric":{"kubernetes_node":"node-1","kubernetes_namespace":"kube-system","pod_name":"coredns-1"},"values":[[1640307600,"1000"]]}`))
+		case strings.Contains(query, "kube_node_status_capacity"):
+			w.Write(series("matrix",
+				`{"metric":{"kubernetes_node":"node-1","res":"cpu"},"values":[[1640307600,"4"]]}`,
+				`{"metric":{"kubernetes_node":"node-1","res":"memory"},"values":[[1640307600,"8000"]]}`))
+		case strings.Contains(query, "kube_replicaset_owner{kind='Deployment'}"):
+			w.Write(series("vector", `{"metric":{"rs":"web-6d4f","kind":"Deployment","owner":"web"},"value":[1640307600,"1"]}`))
+		case strings.Contains(query, "kube_pod_owner{kind='ReplicaSet'}"):
+			w.Write(series("vector", `{"metric":{"pod_name":"web-1","kind":"ReplicaSet","owner":"web-6d4f"},"value":[1640307600,"1"]}`))
+		case strings.Contains(query, "kube_pod_owner"):
+			w.Write(series("vector",
+				`{"metric":{"pod_name":"web-1","kind":"ReplicaSet","owner":"web-6d4f"},"value":[1640307600,"1"]}`,
+				`{"metric":{"pod_name":"backup-1","kind":"Job","owner":"backup"},"value":[1640307600,"1"]}`))
+		case strings.Contains(query, "kube_pod_labels"):
+			w.Write(series("vector", `{"metric":{"pod_name":"web-1","label_team":"shop"},"value":[1640307600,"1"]}`))
+		default:
+			w.Write(series("vector"))
+		}
+	}))
+	defer server.Close()
+
+	remapped, err := NewQueries(QueryConfig{Labels: map[string]string{
+		"cadvisor_node": "kubernetes_node",
+		"node":          "kubernetes_node",
+		"namespace":     "kubernetes_namespace",
+		"pod":           "pod_name",
+		"resource":      "res",
+		"replicaset":    "rs",
+		"owner_kind":    "kind",
+		"owner_name":    "owner",
+	}})
+	assert.Nil(t, err)
+
+	source, err := NewPrometheusSource(server.URL)
+	assert.Nil(t, err)
+	source.SetQueries(remapped)
+
+	start := time.Unix(1640304000, 0)
+	end := start.Add(time.Hour)
+
+	usages, _, err := source.GetClusterPodResourceUsageOverTime(start, end, time.Hour)
+	assert.Nil(t, err)
+	assert.Len(t, usages["node-1"], 1)
+	assert.Equal(t, map[string]ResourceUsage{
+		"web-1":     {Namespace: "shop", CpuUsage: 0.5, MemUsage: 2000},
+		"coredns-1": {Namespace: "kube-system", CpuUsage: 0.1, MemUsage: 1000},
+	}, usages["node-1"][0].ResourceUsages)
+	assert.Contains(t, queries[0], "sum by (kubernetes_node, kubernetes_namespace, pod_name)")
+
+	capacities, _, err := source.GetClusterNodeCapacityOverTime(start, end, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, []NodeCapacity{{Time: end, CPU: 4, Memory: 8000}}, capacities["node-1"])
+
+	owners, _, err := source.GetPodOwners(end, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]PodOwner{"web-1": {Kind: "Deployment", Name: "web"}, "backup-1": {Kind: "Job", Name: "backup"}}, owners)
+	assert.Equal(t, map[string]string{"web-1": "web"}, source.GetPodsToDeployment(end, time.Hour))
+
+	labels, _, err := source.GetPodLabels(end, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]map[string]string{"web-1": {"team": "shop"}}, labels)
+}
@@ -0,0 +1,228 @@
+package prometheus
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// A Prometheus response saved to disk by the recording API
+type Recording struct {
+	Query    string          `json:"query"`
+	Time     int64           `json:"time,omitempty"`
+	Start    int64           `json:"start,omitempty"`
+	End      int64           `json:"end,omitempty"`
+	Step     int64           `json:"step,omitempty"`
+	Response json.RawMessage `json:"response"`
+}
+
+// The body of a Prometheus HTTP API response
+type apiResponse struct {
+	Status    string       `json:"status"`
+	Data      responseData `json:"data"`
+	Warnings  []string     `json:"warnings,omitempty"`
+	ErrorType string       `json:"errorType,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+type responseData struct {
+	ResultType string      `json:"resultType"`
+	Result     model.Value `json:"result"`
+}
+
+// A promv1.API that writes the responses of all instant and range queries to a directory
+type recordingAPI struct {
+	promv1.API
+	directory string
+}
+
+/*
+ * Wraps the API so that every response to Query and QueryOverTime is written to the directory, keyed by query and time range.
+ * The recordings can be served by NewReplayHandler
+ */
+func NewRecordingAPI(api promv1.API, directory string) (promv1.API, error) {
+	err := os.MkdirAll(directory, 0755)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingAPI{API: api, directory: directory}, nil
+}
+
+func (r *recordingAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, promv1.Warnings, error) {
+	value, warnings, err := r.API.Query(ctx, query, ts)
+
+	if err != nil {
+		return value, warnings, err
+	}
+
+	recording := Recording{
+		Query: query,
+		Time:  toMillis(ts),
+	}
+
+	return value, warnings, r.write(recording, value, warnings)
+}
+
+func (r *recordingAPI) QueryRange(ctx context.Context, query string, t promv1.Range) (model.Value, promv1.Warnings, error) {
+	value, warnings, err := r.API.QueryRange(ctx, query, t)
+
+	if err != nil {
+		return value, warnings, err
+	}
+
+	recording := Recording{
+		Query: query,
+		Start: toMillis(t.Start),
+		End:   toMillis(t.End),
+		Step:  t.Step.Milliseconds(),
+	}
+
+	return value, warnings, r.write(recording, value, warnings)
+}
+
+func (r *recordingAPI) write(recording Recording, value model.Value, warnings promv1.Warnings) error {
+	response, err := json.Marshal(apiResponse{
+		Status: "success",
+		Data: responseData{
+			ResultType: value.Type().String(),
+			Result:     value,
+		},
+		Warnings: warnings,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	recording.Response = response
+	content, err := json.MarshalIndent(recording, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(r.directory, recording.key()+".json"), content, 0644)
+}
+
+/*
+ * Returns an HTTP handler answering /api/v1/query and /api/v1/query_range requests with the recordings in the directory.
+ * Queries without a recording are answered with an error
+ */
+func NewReplayHandler(directory string) (http.Handler, error) {
+	files, err := filepath.Glob(filepath.Join(directory, "*.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := make(map[string]Recording)
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		recording := Recording{}
+
+		if err := json.Unmarshal(content, &recording); err != nil {
+			return nil, fmt.Errorf("Invalid recording %s: %v", file, err)
+		}
+
+		recordings[recording.key()] = recording
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		recording, err := parseRecordingRequest(req)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(apiResponse{Status: "error", ErrorType: "bad_data", Error: err.Error()})
+			return
+		}
+
+		replayed, ok := recordings[recording.key()]
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(apiResponse{Status: "error", ErrorType: "not_found", Error: fmt.Sprintf("No recording of the query '%s'", recording.Query)})
+			return
+		}
+
+		w.Write(replayed.Response)
+	}), nil
+}
+
+/*
+ * Reads the query and time range of a Prometheus HTTP API request the way the promv1 client sends them
+ */
+func parseRecordingRequest(req *http.Request) (Recording, error) {
+	err := req.ParseForm()
+
+	if err != nil {
+		return Recording{}, err
+	}
+
+	recording := Recording{Query: req.Form.Get("query")}
+
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/api/v1/query"):
+		recording.Time, err = parseSeconds(req.Form.Get("time"))
+	case strings.HasSuffix(req.URL.Path, "/api/v1/query_range"):
+		if recording.Start, err = parseSeconds(req.Form.Get("start")); err != nil {
+			return Recording{}, err
+		}
+
+		if recording.End, err = parseSeconds(req.Form.Get("end")); err != nil {
+			return Recording{}, err
+		}
+
+		recording.Step, err = parseSeconds(req.Form.Get("step"))
+	default:
+		err = fmt.Errorf("Unsupported path %s", req.URL.Path)
+	}
+
+	return recording, err
+}
+
+// Recordings are keyed by a hash of the query and its time range, since queries are too long for file names
+func (r Recording) key() string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d\x00%d", r.Query, r.Time, r.Start, r.End, r.Step)))
+	return hex.EncodeToString(hash[:])
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Parses seconds with an optional fraction, e.g. 1640304000.371, to milliseconds
+func parseSeconds(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	seconds, err := strconv.ParseFloat(s, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time '%s'", s)
+	}
+
+	return int64(math.Round(seconds * 1000)), nil
+}
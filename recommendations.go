@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"dat067/costestimation/pricing"
+	"dat067/costestimation/prometheus"
+	"dat067/costestimation/rightsizing"
+	"dat067/costestimation/timerange"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Memory usage above this share of the memory limit risks the container being killed
+const MEMORY_LIMIT_MARGIN = 0.9
+
+var recommender = rightsizing.DefaultRecommender()
+
+type RecommendationResponseItem struct {
+	Namespace string                `json:"namespace"`
+	Pod       string                `json:"pod"`
+	Container string                `json:"container"`
+	Node      string                `json:"node"`
+	Usage     rightsizing.Usage     `json:"usage"`
+	Requests  rightsizing.Resources `json:"requests"`
+	Limits    rightsizing.Resources `json:"limits"`
+	Proposed  rightsizing.Resources `json:"proposed"`
+	// How much less the proposed requests cost per month than the current ones, negative if the container requests too little
+	MonthlySavings float64  `json:"monthlySavings"`
+	Currency       string   `json:"currency"`
+	Notes          []string `json:"notes,omitempty"`
+}
+
+/*
+ * Returns a request proposal for every container, from its usage during the window query parameter before now, optionally only for the namespace query parameter
+ */
+func getRecommendations(c *gin.Context) {
+	window, err := timerange.ParseDuration(c.DefaultQuery("window", "7d"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	recommendations, err := getRightsizingRecommendations(metricsSource, time.Now(), window)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	namespace := c.Query("namespace")
+	result := []RecommendationResponseItem{}
+	for _, item := range recommendations {
+		if namespace == "" || item.Namespace == namespace {
+			result = append(result, item)
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+/*
+ * Compares the 95th and 99th percentile of the usage of every container during the window before endTime with its requests and limits,
+ * proposes new requests and prices the difference with the node price and the cost model. Sorted by the largest savings first
+ */
+func getRightsizingRecommendations(source prometheus.MetricsSource, endTime time.Time, window time.Duration) ([]RecommendationResponseItem, error) {
+	containerSource, ok := source.(prometheus.ContainerSource)
+	if !ok {
+		return nil, fmt.Errorf("The metrics source can not query the usage of containers")
+	}
+
+	currency, err := pricing.SEK.String()
+	if err != nil {
+		return nil, err
+	}
+
+	specs, warnings, err := containerSource.GetContainerSpecs(endTime, window)
+	if warnings != nil {
+		fmt.Println(warnings)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	p95, warnings, err := containerSource.GetContainerUsageQuantile(0.95, endTime, window)
+	if warnings != nil {
+		fmt.Println(warnings)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	p99, warnings, err := containerSource.GetContainerUsageQuantile(0.99, endTime, window)
+	if warnings != nil {
+		fmt.Println(warnings)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]rightsizing.Node)
+	recommendations := []RecommendationResponseItem{}
+
+	for container, usage95 := range p95 {
+		usage99 := p99[container]
+		spec := specs[container]
+
+		item := RecommendationResponseItem{
+			Namespace: container.Namespace,
+			Pod:       container.Pod,
+			Container: container.Name,
+			Node:      spec.Node,
+			Usage: rightsizing.Usage{
+				CPUP95:    usage95.CPU,
+				CPUP99:    usage99.CPU,
+				MemoryP95: usage95.Memory,
+				MemoryP99: usage99.Memory,
+			},
+			Requests: rightsizing.Resources{CPU: spec.Requests.CPU, Memory: spec.Requests.Memory},
+			Limits:   rightsizing.Resources{CPU: spec.Limits.CPU, Memory: spec.Limits.Memory},
+			Currency: currency,
+		}
+		item.Proposed = recommender.Propose(item.Usage)
+
+		if item.Limits.CPU > 0 && item.Proposed.CPU > item.Limits.CPU {
+			item.Notes = append(item.Notes, "The proposed CPU request is above the CPU limit")
+		}
+
+		if item.Limits.Memory > 0 && item.Usage.MemoryP99 > MEMORY_LIMIT_MARGIN*item.Limits.Memory {
+			item.Notes = append(item.Notes, "The memory usage is close to the memory limit")
+		}
+
+		node, ok := nodes[spec.Node]
+		if !ok {
+			node, err = getRightsizingNode(source, spec.Node, endTime)
+			if err != nil {
+				return nil, err
+			}
+			nodes[spec.Node] = node
+		}
+
+		if node.Price == 0 {
+			item.Notes = append(item.Notes, fmt.Sprintf("The node '%s' has no price, the savings are unknown", spec.Node))
+		} else {
+			item.MonthlySavings = rightsizing.MonthlySavings(costModel, node, item.Requests, item.Proposed)
+		}
+
+		recommendations = append(recommendations, item)
+	}
+
+	sort.Slice(recommendations, func(i, j int) bool {
+		if recommendations[i].MonthlySavings != recommendations[j].MonthlySavings {
+			return recommendations[i].MonthlySavings > recommendations[j].MonthlySavings
+		}
+		if recommendations[i].Pod != recommendations[j].Pod {
+			return recommendations[i].Pod < recommendations[j].Pod
+		}
+		return recommendations[i].Container < recommendations[j].Container
+	})
+
+	return recommendations, nil
+}
+
+/*
+ * Returns the capacity and price of the node. Nodes that are not priced, e.g. because they are gone, have a zero price
+ */
+func getRightsizingNode(source prometheus.MetricsSource, name string, t time.Time) (rightsizing.Node, error) {
+	for _, pricedNode := range pricedNodes {
+		if pricedNode.Node.Name != name {
+			continue
+		}
+
+		cpu, _, err := source.GetCPUNodeCapacity(name, t)
+		if err != nil {
+			return rightsizing.Node{}, err
+		}
+
+		memory, _, err := source.GetMemoryNodeCapacity(name, t)
+		if err != nil {
+			return rightsizing.Node{}, err
+		}
+
+		return rightsizing.Node{CPU: cpu, Memory: memory, Price: pricedNode.Price}, nil
+	}
+
+	return rightsizing.Node{}, nil
+}
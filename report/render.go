@@ -0,0 +1,90 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+const FORMAT_JSON = "json"
+const FORMAT_HTML = "html"
+
+type jsonRow struct {
+	Group []string `json:"group"`
+	Cost
+	Total float64 `json:"total"`
+}
+
+type jsonReport struct {
+	GroupBy  []string  `json:"groupBy"`
+	Currency string    `json:"currency"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Rows     []jsonRow `json:"rows"`
+	Total    jsonRow   `json:"total"`
+}
+
+/*
+ * Writes the report as a JSON object with the rows and their total
+ */
+func (r *Report) WriteJSON(w io.Writer) error {
+	out := jsonReport{
+		GroupBy:  r.GroupBy,
+		Currency: r.Currency,
+		Start:    r.Start,
+		End:      r.End,
+		Rows:     make([]jsonRow, len(r.Rows)),
+		Total:    jsonRow{Group: []string{}, Cost: r.Total(), Total: r.Total().Total()},
+	}
+
+	for i, row := range r.Rows {
+		out.Rows[i] = jsonRow{Group: row.Group, Cost: row.Cost, Total: row.Total()}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"cost": func(value float64) string { return fmt.Sprintf("%.2f", value) },
+	"date": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; }
+td.cost { text-align: right; }
+tfoot td { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{date .Report.Start}} to {{date .Report.End}} UTC, in {{.Report.Currency}}</p>
+<table>
+<thead><tr>{{range .Report.Header}}{{if not (eq . "Currency" "Period start" "Period end")}}<th>{{.}}</th>{{end}}{{end}}</tr></thead>
+<tbody>
+{{range .Report.Rows}}<tr>{{range .Group}}<td>{{.}}</td>{{end}}<td class="cost">{{cost .CPU}}</td><td class="cost">{{cost .Memory}}</td><td class="cost">{{cost .Waste}}</td><td class="cost">{{cost .Idle}}</td><td class="cost">{{cost .Total}}</td></tr>
+{{end}}</tbody>
+<tfoot><tr><td colspan="{{len .Report.GroupBy}}">Total</td><td class="cost">{{cost .Total.CPU}}</td><td class="cost">{{cost .Total.Memory}}</td><td class="cost">{{cost .Total.Waste}}</td><td class="cost">{{cost .Total.Idle}}</td><td class="cost">{{cost .Total.Total}}</td></tr></tfoot>
+</table>
+</body>
+</html>
+`))
+
+/*
+ * Writes the report as a standalone HTML page with a table of the rows and their total, e.g. for mailing to the cost centres
+ */
+func (r *Report) WriteHTML(w io.Writer, title string) error {
+	return htmlTemplate.Execute(w, struct {
+		Title  string
+		Report *Report
+		Total  Cost
+	}{title, r, r.Total()})
+}
@@ -0,0 +1,161 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const FORMAT_CSV = "csv"
+const FORMAT_XLSX = "xlsx"
+
+// The cost of a group of pods, split into what its CPU and memory usage cost, the unused node capacity it was charged for and its share of the idle cost
+type Cost struct {
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+	Waste  float64 `json:"waste"`
+	Idle   float64 `json:"idle"`
+}
+
+func (c Cost) Total() float64 {
+	return c.CPU + c.Memory + c.Waste + c.Idle
+}
+
+// The cost of the pods sharing the same values of the grouped by columns, e.g. a namespace
+type Row struct {
+	Group []string `json:"group"`
+	Cost
+}
+
+/*
+ * The cost of every group over a period. GroupBy names the columns of the group values, e.g. "Namespace" or the grouped labels
+ */
+type Report struct {
+	GroupBy  []string
+	Rows     []Row
+	Currency string
+	Start    time.Time
+	End      time.Time
+	index    map[string]int
+}
+
+func New(groupBy []string, currency string, start time.Time, end time.Time) *Report {
+	return &Report{GroupBy: groupBy, Rows: []Row{}, Currency: currency, Start: start, End: end}
+}
+
+/*
+ * Adds the cost to the row of the group, creating the row if the group has none yet
+ */
+func (r *Report) Add(group []string, cost Cost) {
+	if r.index == nil {
+		r.reindex()
+	}
+
+	key := strings.Join(group, "\x00")
+	i, ok := r.index[key]
+	if !ok {
+		i = len(r.Rows)
+		r.index[key] = i
+		r.Rows = append(r.Rows, Row{Group: group})
+	}
+
+	row := &r.Rows[i]
+	row.CPU += cost.CPU
+	row.Memory += cost.Memory
+	row.Waste += cost.Waste
+	row.Idle += cost.Idle
+}
+
+// Sorts the rows by their group values
+func (r *Report) Sort() {
+	sort.SliceStable(r.Rows, func(i, j int) bool {
+		return strings.Join(r.Rows[i].Group, "\x00") < strings.Join(r.Rows[j].Group, "\x00")
+	})
+
+	r.reindex()
+}
+
+func (r *Report) reindex() {
+	r.index = make(map[string]int)
+	for i, row := range r.Rows {
+		r.index[strings.Join(row.Group, "\x00")] = i
+	}
+}
+
+// Returns the sum of the costs of all rows
+func (r *Report) Total() Cost {
+	total := Cost{}
+	for _, row := range r.Rows {
+		total.CPU += row.CPU
+		total.Memory += row.Memory
+		total.Waste += row.Waste
+		total.Idle += row.Idle
+	}
+	return total
+}
+
+// The column headers, the group columns first
+func (r *Report) Header() []string {
+	header := append([]string{}, r.GroupBy...)
+	return append(header, "CPU cost", "Memory cost", "Waste", "Idle share", "Total", "Currency", "Period start", "Period end")
+}
+
+/*
+ * Writes the report in the format, "csv", "xlsx", "json" or "html"
+ */
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FORMAT_CSV:
+		return r.WriteCSV(w)
+	case FORMAT_XLSX:
+		return r.WriteXLSX(w)
+	case FORMAT_JSON:
+		return r.WriteJSON(w)
+	case FORMAT_HTML:
+		return r.WriteHTML(w, "Costs by "+strings.Join(r.GroupBy, ", "))
+	}
+
+	return fmt.Errorf("Unknown report format '%s'", format)
+}
+
+// Returns the MIME type of the format
+func ContentType(format string) string {
+	switch format {
+	case FORMAT_XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case FORMAT_JSON:
+		return "application/json"
+	case FORMAT_HTML:
+		return "text/html; charset=utf-8"
+	}
+	return "text/csv"
+}
+
+/*
+ * Writes a header line and a line per row. Costs are written with a dot as decimal separator and the period in RFC 3339
+ */
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(r.Header()); err != nil {
+		return err
+	}
+
+	for _, row := range r.Rows {
+		record := append([]string{}, row.Group...)
+		for _, value := range []float64{row.CPU, row.Memory, row.Waste, row.Idle, row.Total()} {
+			record = append(record, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+
+		record = append(record, r.Currency, r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
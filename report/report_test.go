@@ -0,0 +1,119 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testStart = time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+var testEnd = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testReport() *Report {
+	r := New([]string{"Namespace"}, "SEK", testStart, testEnd)
+	r.Add([]string{"shop"}, Cost{CPU: 1, Memory: 2, Waste: 0.5})
+	r.Add([]string{"blog"}, Cost{CPU: 0.25, Memory: 0.25})
+	r.Add([]string{"shop"}, Cost{CPU: 1, Idle: 0.5})
+	r.Sort()
+	return r
+}
+
+func TestAdd(t *testing.T) {
+	r := testReport()
+
+	assert.Len(t, r.Rows, 2)
+	assert.Equal(t, []string{"blog"}, r.Rows[0].Group)
+	assert.Equal(t, Cost{CPU: 2, Memory: 2, Waste: 0.5, Idle: 0.5}, r.Rows[1].Cost)
+	assert.Equal(t, 5.0, r.Rows[1].Total())
+	assert.Equal(t, 5.5, r.Total().Total())
+
+	// Groups are still merged after sorting
+	r.Add([]string{"blog"}, Cost{CPU: 1})
+	assert.Len(t, r.Rows, 2)
+	assert.Equal(t, 1.25, r.Rows[0].CPU)
+}
+
+func TestWriteCSV(t *testing.T) {
+	r := testReport()
+	r.Add([]string{"a,b"}, Cost{})
+
+	out := bytes.Buffer{}
+	assert.Nil(t, r.Write(&out, FORMAT_CSV))
+	assert.Equal(t, "Namespace,CPU cost,Memory cost,Waste,Idle share,Total,Currency,Period start,Period end\n"+
+		"blog,0.25,0.25,0,0,0.5,SEK,2021-12-01T00:00:00Z,2022-01-01T00:00:00Z\n"+
+		"shop,2,2,0.5,0.5,5,SEK,2021-12-01T00:00:00Z,2022-01-01T00:00:00Z\n"+
+		"\"a,b\",0,0,0,0,0,SEK,2021-12-01T00:00:00Z,2022-01-01T00:00:00Z\n", out.String())
+
+	assert.NotNil(t, r.Write(&out, "pdf"))
+}
+
+func TestWriteXLSX(t *testing.T) {
+	r := testReport()
+	r.Add([]string{"<ops>"}, Cost{CPU: 1})
+
+	out := bytes.Buffer{}
+	assert.Nil(t, r.Write(&out, FORMAT_XLSX))
+
+	archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	assert.Nil(t, err)
+
+	parts := make(map[string]string)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		assert.Nil(t, err)
+		content, err := ioutil.ReadAll(reader)
+		assert.Nil(t, err)
+		parts[file.Name] = string(content)
+	}
+
+	assert.Contains(t, parts, "[Content_Types].xml")
+	assert.Contains(t, parts, "xl/workbook.xml")
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `<c r="A1" t="inlineStr"><is><t>Namespace</t></is></c>`)
+	assert.Contains(t, sheet, `<c r="F3" s="1"><v>5</v></c>`)
+	assert.Contains(t, sheet, `<t>&lt;ops&gt;</t>`)
+	// 2021-12-01 is day 44531 of the 1900 date system
+	assert.Contains(t, sheet, `<c r="H2" s="2"><v>44531</v></c>`)
+	assert.Equal(t, 4, strings.Count(sheet, "<row "))
+}
+
+func TestCellReference(t *testing.T) {
+	assert.Equal(t, "A1", cellReference(0, 1))
+	assert.Equal(t, "Z2", cellReference(25, 2))
+	assert.Equal(t, "AA3", cellReference(26, 3))
+	assert.Equal(t, "AZ4", cellReference(51, 4))
+	assert.Equal(t, "BA5", cellReference(52, 5))
+}
+
+func TestWriteJSON(t *testing.T) {
+	out := bytes.Buffer{}
+	assert.Nil(t, testReport().Write(&out, FORMAT_JSON))
+
+	decoded := jsonReport{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, []string{"Namespace"}, decoded.GroupBy)
+	assert.Len(t, decoded.Rows, 2)
+	assert.Equal(t, 5.0, decoded.Rows[1].Total)
+	assert.Equal(t, 5.5, decoded.Total.Total)
+	assert.True(t, testStart.Equal(decoded.Start))
+}
+
+func TestWriteHTML(t *testing.T) {
+	r := testReport()
+	r.Add([]string{"<ops>"}, Cost{CPU: 1})
+
+	out := bytes.Buffer{}
+	assert.Nil(t, r.WriteHTML(&out, "Chargeback by team"))
+	html := out.String()
+	assert.Contains(t, html, "<title>Chargeback by team</title>")
+	assert.Contains(t, html, "<th>Namespace</th><th>CPU cost</th>")
+	assert.NotContains(t, html, "<th>Currency</th>")
+	assert.Contains(t, html, "<td>&lt;ops&gt;</td>")
+	assert.Contains(t, html, `<td class="cost">6.50</td></tr></tfoot>`)
+}
@@ -0,0 +1,152 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`
+
+const xlsxRelationships = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Costs" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const xlsxWorkbookRelationships = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+// Style 1 shows costs with two decimals and style 2 shows dates, the built in number formats 2 and 22
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="3">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="2" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+</styleSheet>`
+
+const xlsxStyleCost = 1
+const xlsxStyleDate = 2
+
+// The day before the first day of the 1900 date system, with Excel's leap day of 1900 accounted for
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+/*
+ * Writes the report as a workbook with a single sheet, so that spreadsheets open it without an import dialog. Costs are numbers and the
+ * period is dates in UTC, so that they can be summed and filtered
+ */
+func (r *Report) WriteXLSX(w io.Writer) error {
+	sheet := bytes.Buffer{}
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	writeRow := func(number int, cells []string) {
+		fmt.Fprintf(&sheet, `<row r="%d">`, number)
+		for _, cell := range cells {
+			sheet.WriteString(cell)
+		}
+		sheet.WriteString(`</row>`)
+	}
+
+	header := []string{}
+	for column, title := range r.Header() {
+		header = append(header, stringCell(column, 1, title))
+	}
+	writeRow(1, header)
+
+	for i, row := range r.Rows {
+		number := i + 2
+		cells := []string{}
+		for column, value := range row.Group {
+			cells = append(cells, stringCell(column, number, value))
+		}
+
+		column := len(row.Group)
+		for _, value := range []float64{row.CPU, row.Memory, row.Waste, row.Idle, row.Total()} {
+			cells = append(cells, numberCell(column, number, value, xlsxStyleCost))
+			column++
+		}
+
+		cells = append(cells,
+			stringCell(column, number, r.Currency),
+			numberCell(column+1, number, excelDate(r.Start), xlsxStyleDate),
+			numberCell(column+2, number, excelDate(r.End), xlsxStyleDate))
+		writeRow(number, cells)
+	}
+
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	archive := zip.NewWriter(w)
+	parts := []struct {
+		name    string
+		content []byte
+	}{
+		{"[Content_Types].xml", []byte(xlsxContentTypes)},
+		{"_rels/.rels", []byte(xlsxRelationships)},
+		{"xl/workbook.xml", []byte(xlsxWorkbook)},
+		{"xl/_rels/workbook.xml.rels", []byte(xlsxWorkbookRelationships)},
+		{"xl/styles.xml", []byte(xlsxStyles)},
+		{"xl/worksheets/sheet1.xml", sheet.Bytes()},
+	}
+
+	for _, part := range parts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+
+		if _, err := file.Write(part.content); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// Returns the reference of a cell, e.g. A1 for the first column of the first row
+func cellReference(column int, row int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name + strconv.Itoa(row)
+}
+
+func stringCell(column int, row int, value string) string {
+	text := bytes.Buffer{}
+	xml.EscapeText(&text, []byte(value))
+	return fmt.Sprintf(`<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, cellReference(column, row), text.String())
+}
+
+func numberCell(column int, row int, value float64, style int) string {
+	return fmt.Sprintf(`<c r="%s" s="%d"><v>%s</v></c>`, cellReference(column, row), style, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// Returns the time as the number of days since the epoch of the 1900 date system
+func excelDate(t time.Time) float64 {
+	return t.UTC().Sub(xlsxEpoch).Hours() / 24
+}
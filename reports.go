@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dat067/costestimation/allocation"
+	"dat067/costestimation/pricing"
+	"dat067/costestimation/prometheus"
+	"dat067/costestimation/report"
+	"dat067/costestimation/timerange"
+
+	"github.com/gin-gonic/gin"
+)
+
+const REPORT_NAMESPACE = "namespace"
+const REPORT_DEPLOYMENT = "deployment"
+const REPORT_LABEL = "label"
+
+/*
+ * Returns the cost of every namespace as a CSV or XLSX file, see getCostReportOfRequest
+ * in postman URL: http://localhost:8080/report/namespace?startTime=startOfMonth&endTime=now&format=xlsx
+ */
+func getNamespaceReport(c *gin.Context) {
+	getCostReportOfRequest(c, REPORT_NAMESPACE, nil)
+}
+
+/*
+ * Returns the cost of every deployment as a CSV or XLSX file. Pods that do not belong to a deployment are left out, like in /price
+ */
+func getDeploymentReport(c *gin.Context) {
+	getCostReportOfRequest(c, REPORT_DEPLOYMENT, nil)
+}
+
+/*
+ * Returns the cost grouped by the values of one or more comma separated pod labels as a CSV or XLSX file
+ * in postman URL: http://localhost:8080/report/by-label/team,env?startTime=startOfMonth&endTime=now
+ */
+func getLabelReport(c *gin.Context) {
+	labels := allocation.ParseList(c.Param("label"))
+	if len(labels) == 0 {
+		c.String(http.StatusBadRequest, "no label to group by")
+		return
+	}
+
+	getCostReportOfRequest(c, REPORT_LABEL, labels)
+}
+
+/*
+ * Reads the time range and the format query parameter, 'csv' (the default) or 'xlsx', and writes the report as an attachment
+ */
+func getCostReportOfRequest(c *gin.Context, groupBy string, labels []string) {
+	format := strings.ToLower(c.DefaultQuery("format", report.FORMAT_CSV))
+	if format != report.FORMAT_CSV && format != report.FORMAT_XLSX {
+		c.String(http.StatusBadRequest, fmt.Sprintf("Unknown report format '%s'", format))
+		return
+	}
+
+	startTime, endTime, resolution, err := parseTimeRange(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	costReport, err := getCostReport(metricsSource, startTime, endTime, resolution, groupBy, labels)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", reportFileName(groupBy, startTime, endTime, format)))
+	c.Header("Content-Type", report.ContentType(format))
+	c.Status(http.StatusOK)
+	if err := costReport.Write(c.Writer, format); err != nil {
+		fmt.Printf("Could not write the report: '%v'\n", err)
+	}
+}
+
+// Returns e.g. costs-namespace-2021-12-01-2022-01-01.csv
+func reportFileName(groupBy string, startTime time.Time, endTime time.Time, format string) string {
+	return fmt.Sprintf("costs-%s-%s-%s.%s", groupBy, startTime.UTC().Format("2006-01-02"), endTime.UTC().Format("2006-01-02"), format)
+}
+
+/*
+ * Splits the cost of every pod between startTime and endTime into CPU, memory, waste and idle share, and sums them per namespace,
+ * deployment or values of the labels. With the separate idle policy the idle cost of the nodes is a row of its own, and with shared
+ * overhead configured every group has a line of its own with its share of the shared cost, see addPodCosts
+ */
+func getCostReport(source prometheus.MetricsSource, startTime time.Time, endTime time.Time, resolution time.Duration, groupBy string, labels []string) (*report.Report, error) {
+	duration := endTime.Sub(startTime)
+	currency, err := pricing.SEK.String()
+	if err != nil {
+		return nil, err
+	}
+
+	costs, err := getPodCosts(source, startTime, endTime, resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	podLabels := make(map[string]map[string]string)
+	if groupBy == REPORT_LABEL || len(sharedConfig.Labels) > 0 {
+		var warnings []string
+		podLabels, warnings, err = source.GetPodLabels(endTime, duration)
+		if warnings != nil {
+			fmt.Println(warnings)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var columns []string
+	var groupOf func(pod string) ([]string, bool)
+	switch groupBy {
+	case REPORT_NAMESPACE:
+		columns = []string{"Namespace"}
+		groupOf = func(pod string) ([]string, bool) {
+			return []string{costs.Namespaces[pod]}, true
+		}
+	case REPORT_DEPLOYMENT:
+		columns = []string{"Namespace", "Deployment"}
+		deployments := source.GetPodsToDeployment(endTime, duration)
+		groupOf = func(pod string) ([]string, bool) {
+			deployment, ok := deployments[pod]
+			return []string{costs.Namespaces[pod], deployment}, ok
+		}
+	case REPORT_LABEL:
+		columns = labels
+		groupOf = func(pod string) ([]string, bool) {
+			values := make([]string, len(labels))
+			for i, label := range labels {
+				values[i] = podLabels[pod][prometheus.SanitizeLabelName(label)]
+				if values[i] == "" {
+					values[i] = allocation.UNLABELLED
+				}
+			}
+			return values, true
+		}
+	default:
+		return nil, fmt.Errorf("Unknown report grouping '%s'", groupBy)
+	}
+
+	costReport := newCostReport(columns, currency, startTime, endTime)
+	addPodCosts(costReport, costs, podLabels, groupOf)
+	costReport.Sort()
+
+	if idlePolicy == allocation.IdleSeparate {
+		idle := 0.0
+		for _, nodeIdle := range costs.NodeIdle {
+			idle += nodeIdle
+		}
+		costReport.Add(workloadGroup(costReport, allocation.IDLE_WORKLOAD), report.Cost{Idle: idle})
+	}
+
+	return costReport, nil
+}
+
+// The values of the cost type column the reports have when shared overhead is configured
+const REPORT_COST_USAGE = "usage"
+const REPORT_COST_SHARED = "shared overhead"
+
+/*
+ * Returns an empty report grouped by the columns. With shared overhead configured the report has a last column telling the cost of the
+ * pods of a group apart from its share of the shared cost
+ */
+func newCostReport(columns []string, currency string, startTime time.Time, endTime time.Time) *report.Report {
+	if sharedConfig.Enabled() {
+		columns = append(append([]string{}, columns...), "Cost type")
+	}
+
+	return report.New(columns, currency, startTime, endTime)
+}
+
+// Returns the group of a pseudo-workload such as the idle cost, the workload name in every column
+func workloadGroup(costReport *report.Report, workload string) []string {
+	group := make([]string, len(costReport.GroupBy))
+	for i := range group {
+		group[i] = workload
+	}
+	return group
+}
+
+/*
+ * Adds the cost of every pod to the row of its group. With shared overhead configured the shared pods have no rows of their own.
+ * Instead every group gets a second line with the shared overhead of its pods, which keeps the CPU, memory, waste and idle split of
+ * the shared pods. The shared cost is a line of its own when there is no tenant to split it between
+ */
+func addPodCosts(costReport *report.Report, costs podCosts, podLabels map[string]map[string]string, groupOf func(pod string) ([]string, bool)) {
+	costOf := func(pod string) report.Cost {
+		return report.Cost{
+			CPU:    costs.UsageCPU[pod],
+			Memory: costs.UsageMemory[pod],
+			Waste:  costs.Wasted[pod],
+			Idle:   costs.IdleShares[pod],
+		}
+	}
+
+	if !sharedConfig.Enabled() {
+		for pod := range costs.Prices {
+			if group, ok := groupOf(pod); ok {
+				costReport.Add(group, costOf(pod))
+			}
+		}
+		return
+	}
+
+	sharedCost := report.Cost{}
+	tenantPrices := make(map[string]float64)
+	tenantUsage := make(map[string]float64)
+	for pod, price := range costs.Prices {
+		if sharedConfig.IsShared(costs.Namespaces[pod], podLabels[pod]) {
+			cost := costOf(pod)
+			sharedCost.CPU += cost.CPU
+			sharedCost.Memory += cost.Memory
+			sharedCost.Waste += cost.Waste
+			sharedCost.Idle += cost.Idle
+			continue
+		}
+
+		tenantPrices[pod] = price
+		tenantUsage[costs.Namespaces[pod]] += price
+	}
+
+	overheads := sharedConfig.Distribute(sharedCost.Total(), tenantUsage)
+	podOverheads := allocation.SplitToPods(tenantPrices, costs.Namespaces, overheads)
+
+	// A share of the shared cost keeps the split of the shared cost
+	shareOf := func(overhead float64) report.Cost {
+		if sharedCost.Total() == 0 {
+			return report.Cost{}
+		}
+
+		fraction := overhead / sharedCost.Total()
+		return report.Cost{CPU: sharedCost.CPU * fraction, Memory: sharedCost.Memory * fraction, Waste: sharedCost.Waste * fraction, Idle: sharedCost.Idle * fraction}
+	}
+
+	for pod := range tenantPrices {
+		group, ok := groupOf(pod)
+		if !ok {
+			continue
+		}
+
+		costReport.Add(append(append([]string{}, group...), REPORT_COST_USAGE), costOf(pod))
+		if podOverheads[pod] != 0 {
+			costReport.Add(append(append([]string{}, group...), REPORT_COST_SHARED), shareOf(podOverheads[pod]))
+		}
+	}
+
+	if overhead, ok := overheads[allocation.SHARED_WORKLOAD]; ok {
+		group := workloadGroup(costReport, allocation.SHARED_WORKLOAD)
+		group[len(group)-1] = REPORT_COST_SHARED
+		costReport.Add(group, shareOf(overhead))
+	}
+}
+
+/*
+ * Parses the grouping of a report given on the command line: 'namespace', 'deployment' or 'label:' followed by comma separated labels,
+ * e.g. label:team,env
+ */
+func parseReportGroup(s string) (string, []string, error) {
+	switch {
+	case s == REPORT_NAMESPACE || s == REPORT_DEPLOYMENT:
+		return s, nil, nil
+	case strings.HasPrefix(s, REPORT_LABEL+":"):
+		labels := allocation.ParseList(strings.TrimPrefix(s, REPORT_LABEL+":"))
+		if len(labels) == 0 {
+			return "", nil, fmt.Errorf("No label to group the report by in '%s'", s)
+		}
+		return REPORT_LABEL, labels, nil
+	}
+
+	return "", nil, fmt.Errorf("Invalid report grouping '%s', use 'namespace', 'deployment' or 'label:<labels>'", s)
+}
+
+/*
+ * Writes the report of the period between the start and end times to the file. The format is XLSX if the file name ends with .xlsx,
+ * and CSV otherwise
+ */
+func writeCostReportFile(source prometheus.MetricsSource, path string, groupByStr string, startStr string, endStr string) error {
+	groupBy, labels, err := parseReportGroup(groupByStr)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	startTime, err := timerange.ParseTime(startStr, now)
+	if err != nil {
+		return err
+	}
+
+	endTime, err := timerange.ParseTime(endStr, now)
+	if err != nil {
+		return err
+	}
+
+	startTime, warnings, err := timerange.Validate(startTime, endTime, endTime.Sub(startTime), now, timeLimits)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range warnings {
+		fmt.Println(warning)
+	}
+
+	costReport, err := getCostReport(source, startTime, endTime, endTime.Sub(startTime), groupBy, labels)
+	if err != nil {
+		return err
+	}
+
+	format := report.FORMAT_CSV
+	if strings.ToLower(filepath.Ext(path)) == "."+report.FORMAT_XLSX {
+		format = report.FORMAT_XLSX
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := costReport.Write(file, format); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
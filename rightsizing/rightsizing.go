@@ -0,0 +1,101 @@
+package rightsizing
+
+import (
+	"math"
+
+	"dat067/costestimation/models"
+)
+
+// The average number of hours in a month, 365 * 24 / 12
+const HOURS_PER_MONTH = 730
+
+const MEBIBYTE = 1024 * 1024
+
+// CPU in cores and memory in bytes
+type Resources struct {
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+}
+
+// The usage quantiles of a container over the recommendation window
+type Usage struct {
+	CPUP95    float64 `json:"cpuP95"`
+	CPUP99    float64 `json:"cpuP99"`
+	MemoryP95 float64 `json:"memoryP95"`
+	MemoryP99 float64 `json:"memoryP99"`
+}
+
+// The capacity in cores and bytes and the price per hour of a node
+type Node struct {
+	CPU    float64
+	Memory float64
+	Price  float64
+}
+
+/*
+ * Proposes container requests from the observed usage. CPU requests follow the 95th percentile, since a container exceeding
+ * its CPU request is only throttled when the node is busy. Memory requests follow the 99th percentile, since a node running out of memory evicts pods
+ */
+type Recommender struct {
+	// The share added on top of the usage percentile, e.g. 0.15 for 15%
+	Headroom float64
+	// The smallest proposed CPU request in cores
+	MinCPU float64
+	// The smallest proposed memory request in bytes
+	MinMemory float64
+}
+
+func DefaultRecommender() Recommender {
+	return Recommender{
+		Headroom:  0.15,
+		MinCPU:    0.01,
+		MinMemory: 16 * MEBIBYTE,
+	}
+}
+
+/*
+ * Returns the proposed requests for the usage, rounded up to whole millicores and mebibytes
+ */
+func (r Recommender) Propose(usage Usage) Resources {
+	cpu := math.Max(usage.CPUP95*(1+r.Headroom), r.MinCPU)
+	memory := math.Max(usage.MemoryP99*(1+r.Headroom), r.MinMemory)
+
+	return Resources{
+		CPU:    roundUp(cpu, 0.001),
+		Memory: roundUp(memory, MEBIBYTE),
+	}
+}
+
+// Rounds up to a multiple of the unit, ignoring the rounding errors of e.g. 0.2 * 1.1
+func roundUp(value float64, unit float64) float64 {
+	return math.Ceil(value/unit-1e-9) * unit
+}
+
+/*
+ * Returns the cost per month of the requested resources on the node, charged by the cost model. The requests are priced as a container
+ * next to another one taking the rest of the node, so no unused capacity is charged to them. Negative requests give negative costs
+ */
+func MonthlyCost(model models.ICostCalculator, node Node, requests Resources) float64 {
+	if node.CPU <= 0 || node.Memory <= 0 {
+		return 0
+	}
+
+	// The resources are ordered memory first, like in the cost computation of the pods
+	costs, _ := model.CalculateCost(
+		[]float64{node.Memory, node.CPU},
+		[][]float64{
+			{requests.Memory, requests.CPU},
+			{node.Memory - requests.Memory, node.CPU - requests.CPU},
+		},
+		node.Price, HOURS_PER_MONTH)
+
+	return costs[0]
+}
+
+/*
+ * Returns how much less the proposed requests cost per month than the current ones. Negative if the proposal requests more
+ */
+func MonthlySavings(model models.ICostCalculator, node Node, current Resources, proposed Resources) float64 {
+	freed := Resources{CPU: current.CPU - proposed.CPU, Memory: current.Memory - proposed.Memory}
+	return MonthlyCost(model, node, freed)
+}
@@ -0,0 +1,41 @@
+package rightsizing
+
+import (
+	"testing"
+
+	"dat067/costestimation/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const epsilon = 1e-9
+
+func TestPropose(t *testing.T) {
+	recommender := Recommender{Headroom: 0.1, MinCPU: 0.01, MinMemory: 16 * MEBIBYTE}
+
+	proposed := recommender.Propose(Usage{CPUP95: 0.2, CPUP99: 0.9, MemoryP95: 50 * MEBIBYTE, MemoryP99: 100 * MEBIBYTE})
+	assert.InDelta(t, 0.22, proposed.CPU, epsilon)
+	assert.Equal(t, float64(110*MEBIBYTE), proposed.Memory)
+
+	// Idle containers get the smallest requests
+	proposed = recommender.Propose(Usage{})
+	assert.Equal(t, Resources{CPU: 0.01, Memory: 16 * MEBIBYTE}, proposed)
+}
+
+func TestMonthlySavings(t *testing.T) {
+	model := models.GoodModel{Balance: []float64{1, 1}}
+	node := Node{CPU: 4, Memory: 16e9, Price: 2}
+
+	// Half of the node is charged half of the node price
+	assert.InDelta(t, HOURS_PER_MONTH, MonthlyCost(model, node, Resources{CPU: 2, Memory: 8e9}), epsilon)
+
+	// Freeing a quarter of the CPU and no memory saves an eighth of the node price with equally balanced resources
+	savings := MonthlySavings(model, node, Resources{CPU: 2, Memory: 4e9}, Resources{CPU: 1, Memory: 4e9})
+	assert.InDelta(t, HOURS_PER_MONTH/4.0, savings, epsilon)
+
+	// Requesting more costs more
+	savings = MonthlySavings(model, node, Resources{CPU: 1, Memory: 4e9}, Resources{CPU: 2, Memory: 4e9})
+	assert.InDelta(t, -HOURS_PER_MONTH/4.0, savings, epsilon)
+
+	assert.Equal(t, 0.0, MonthlyCost(model, Node{Price: 2}, Resources{CPU: 1}))
+}
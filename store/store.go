@@ -0,0 +1,249 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var windowBucket = []byte("windows")
+var checkpointBucket = []byte("checkpoints")
+
+// The cost of a pod during one window
+type PodCost struct {
+	Namespace  string  `json:"namespace"`
+	Deployment string  `json:"deployment"`
+	Price      float64 `json:"price"`
+	// The price split into the cost of the CPU and memory usage, the unused capacity charged to the pod and its share of the idle cost.
+	// Windows stored before the split was kept only have the price
+	CPU    float64 `json:"cpu,omitempty"`
+	Memory float64 `json:"memory,omitempty"`
+	Waste  float64 `json:"waste,omitempty"`
+	Idle   float64 `json:"idle,omitempty"`
+	// The labels of the pod, named the way kube-state-metrics names them without the "label_" prefix
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Returns whether the price of the pod is split into its parts
+func (p PodCost) HasSplit() bool {
+	return p.Price == 0 || p.CPU != 0 || p.Memory != 0 || p.Waste != 0 || p.Idle != 0
+}
+
+// The costs computed for a fixed period of time, e.g. one hour
+type Window struct {
+	Start    time.Time          `json:"start"`
+	End      time.Time          `json:"end"`
+	Pods     map[string]PodCost `json:"pods"`
+	NodeIdle map[string]float64 `json:"nodeIdle"`
+}
+
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Persists computed cost windows in an embedded bbolt database
+type Store struct {
+	db *bolt.DB
+}
+
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(windowBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(checkpointBucket)
+		return err
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Stores the window, replacing any window with the same start time
+func (s *Store) PutWindow(w Window) error {
+	value, err := json.Marshal(w)
+
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(windowBucket).Put(timeKey(w.Start), value)
+	})
+}
+
+/*
+ * Returns all stored windows lying completely between start and end, ordered by start time
+ */
+func (s *Store) GetWindows(start time.Time, end time.Time) ([]Window, error) {
+	windows := []Window{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(windowBucket).Cursor()
+
+		for key, value := cursor.Seek(timeKey(start)); key != nil; key, value = cursor.Next() {
+			window := Window{}
+
+			if err := json.Unmarshal(value, &window); err != nil {
+				return err
+			}
+
+			if !window.Start.Before(end) {
+				break
+			}
+
+			if window.End.After(end) {
+				continue
+			}
+
+			windows = append(windows, window)
+		}
+
+		return nil
+	})
+
+	return windows, err
+}
+
+/*
+ * Returns the end time of the latest stored window. The boolean is false if no window has been stored
+ */
+func (s *Store) LastWindowEnd() (time.Time, bool, error) {
+	var end time.Time
+	found := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, value := tx.Bucket(windowBucket).Cursor().Last()
+
+		if value == nil {
+			return nil
+		}
+
+		window := Window{}
+
+		if err := json.Unmarshal(value, &window); err != nil {
+			return err
+		}
+
+		end = window.End
+		found = true
+		return nil
+	})
+
+	return end, found, err
+}
+
+// Remembers how far a named job has come, so it can resume after being interrupted
+func (s *Store) PutCheckpoint(name string, t time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointBucket).Put([]byte(name), timeKey(t))
+	})
+}
+
+/*
+ * Returns the checkpoint of a named job. The boolean is false if the job has no checkpoint
+ */
+func (s *Store) GetCheckpoint(name string) (time.Time, bool, error) {
+	var t time.Time
+	found := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(checkpointBucket).Get([]byte(name))
+
+		if value == nil {
+			return nil
+		}
+
+		t = time.Unix(int64(binary.BigEndian.Uint64(value)), 0).UTC()
+		found = true
+		return nil
+	})
+
+	return t, found, err
+}
+
+/*
+ * Returns the summed price of every deployment in the window. Pods not belonging to a deployment are left out
+ */
+func (w Window) DeploymentPrices() map[string]float64 {
+	prices := make(map[string]float64)
+
+	for _, pod := range w.Pods {
+		if pod.Deployment == "" {
+			continue
+		}
+
+		prices[pod.Deployment] += pod.Price
+	}
+
+	return prices
+}
+
+/*
+ * Returns the parts of the time range between start and end that are not covered by any of the windows
+ */
+func Uncovered(windows []Window, start time.Time, end time.Time) []TimeRange {
+	sorted := make([]Window, len(windows))
+	copy(sorted, windows)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+
+	ranges := []TimeRange{}
+	current := start
+
+	for _, window := range sorted {
+		if window.Start.After(current) {
+			ranges = append(ranges, TimeRange{Start: current, End: minTime(window.Start, end)})
+		}
+
+		if window.End.After(current) {
+			current = window.End
+		}
+
+		if !current.Before(end) {
+			return ranges
+		}
+	}
+
+	if current.Before(end) {
+		ranges = append(ranges, TimeRange{Start: current, End: end})
+	}
+
+	return ranges
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+
+	return b
+}
+
+// Big endian Unix time, so the keys are ordered by time
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.Unix()))
+	return key
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func window(start time.Time, hours int, price float64) Window {
+	return Window{
+		Start: start,
+		End:   start.Add(time.Duration(hours) * time.Hour),
+		Pods: map[string]PodCost{
+			"web-1": {Namespace: "shop", Deployment: "web", Price: price},
+			"job-1": {Namespace: "shop", Price: 1},
+		},
+		NodeIdle: map[string]float64{"node-1": 0.5},
+	}
+}
+
+func TestStore(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "costs.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+
+	_, found, err := s.LastWindowEnd()
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	start := time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, s.PutWindow(window(start.Add(time.Duration(i)*time.Hour), 1, float64(i))))
+	}
+
+	end, found, err := s.LastWindowEnd()
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.True(t, end.Equal(start.Add(3*time.Hour)))
+
+	windows, err := s.GetWindows(start.Add(30*time.Minute), start.Add(3*time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, windows, 2)
+	assert.True(t, windows[0].Start.Equal(start.Add(time.Hour)))
+	assert.Equal(t, map[string]float64{"web": 1}, windows[0].DeploymentPrices())
+
+	// Replacing a window keeps a single window per start time
+	assert.Nil(t, s.PutWindow(window(start, 1, 10)))
+	windows, err = s.GetWindows(start, start.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, windows, 1)
+	assert.Equal(t, map[string]float64{"web": 10}, windows[0].DeploymentPrices())
+}
+
+func TestUncovered(t *testing.T) {
+	start := time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC)
+	windows := []Window{window(start.Add(2*time.Hour), 1, 0), window(start.Add(time.Hour), 1, 0), window(start.Add(5*time.Hour), 1, 0)}
+
+	ranges := Uncovered(windows, start, start.Add(8*time.Hour))
+	assert.Equal(t, []TimeRange{
+		{Start: start, End: start.Add(time.Hour)},
+		{Start: start.Add(3 * time.Hour), End: start.Add(5 * time.Hour)},
+		{Start: start.Add(6 * time.Hour), End: start.Add(8 * time.Hour)},
+	}, ranges)
+
+	assert.Empty(t, Uncovered(windows[:2], start.Add(time.Hour), start.Add(3*time.Hour)))
+	assert.Equal(t, []TimeRange{{Start: start, End: start.Add(time.Hour)}}, Uncovered(nil, start, start.Add(time.Hour)))
+}
+
+func TestCheckpoint(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "costs.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+
+	_, found, err := s.GetCheckpoint("backfill")
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	checkpoint := time.Date(2021, 12, 24, 13, 0, 0, 0, time.UTC)
+	assert.Nil(t, s.PutCheckpoint("backfill", checkpoint))
+
+	saved, found, err := s.GetCheckpoint("backfill")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.True(t, checkpoint.Equal(saved))
+}
@@ -0,0 +1,214 @@
+package timerange
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prometheus refuses range queries returning more than 11000 points per series
+const PROMETHEUS_MAX_POINTS = 11000
+
+// The default retention of Prometheus
+const PROMETHEUS_RETENTION = 15 * 24 * time.Hour
+
+// Limits the time ranges that are accepted by Validate
+type Limits struct {
+	// The largest allowed number of resolution steps in a range
+	MaxPoints int
+	// How long Prometheus keeps data. Zero means forever
+	Retention time.Duration
+}
+
+var relativePattern = regexp.MustCompile(`^([a-zA-Z]+)\s*(?:([+-])\s*(\S+))?$`)
+var durationPartPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)([a-zA-Zµ]+)`)
+var unixPattern = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+/*
+ * Parses a point in time. Accepted formats are
+ *  - RFC3339, with or without fractional seconds, e.g. 2021-12-24T00:00:00.371Z
+ *  - Unix time stamps in seconds or milliseconds, e.g. 1640304000
+ *  - Relative expressions: now, today, startOfWeek, startOfMonth or startOfYear, optionally followed by an offset, e.g. now-7d or startOfMonth+1w
+ * Relative expressions are computed from now in UTC
+ */
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return time.Time{}, fmt.Errorf("Missing time")
+	}
+
+	if unixPattern.MatchString(s) {
+		return parseUnix(s)
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+
+	match := relativePattern.FindStringSubmatch(s)
+
+	if match == nil {
+		return time.Time{}, fmt.Errorf("Invalid time '%s'", s)
+	}
+
+	base, err := relativeBase(match[1], now.UTC())
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if match[2] == "" {
+		return base, nil
+	}
+
+	offset, err := ParseDuration(match[3])
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if match[2] == "-" {
+		offset = -offset
+	}
+
+	return base.Add(offset), nil
+}
+
+/*
+ * Parses a duration like time.ParseDuration, but also accepts days (d) and weeks (w), e.g. 7d or 1w2d12h
+ */
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	if !strings.ContainsAny(s, "dw") {
+		return time.ParseDuration(s)
+	}
+
+	parts := durationPartPattern.FindAllStringSubmatch(s, -1)
+
+	if parts == nil || strings.Join(durationPartPattern.FindAllString(s, -1), "") != s {
+		return 0, fmt.Errorf("Invalid duration '%s'", s)
+	}
+
+	total := time.Duration(0)
+
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(part[1], 64)
+
+		if err != nil {
+			return 0, fmt.Errorf("Invalid duration '%s'", s)
+		}
+
+		switch part[2] {
+		case "w":
+			total += time.Duration(value * float64(7*24*time.Hour))
+		case "d":
+			total += time.Duration(value * float64(24*time.Hour))
+		default:
+			duration, err := time.ParseDuration(part[0])
+
+			if err != nil {
+				return 0, err
+			}
+
+			total += duration
+		}
+	}
+
+	return total, nil
+}
+
+/*
+ * Checks that the range between startTime and endTime can be queried with the resolution.
+ * A start time older than the retention is moved forward to the oldest retained data, which is reported as a warning.
+ * Returns the start time to use
+ */
+func Validate(startTime time.Time, endTime time.Time, resolution time.Duration, now time.Time, limits Limits) (time.Time, []string, error) {
+	warnings := []string{}
+
+	if !startTime.Before(endTime) {
+		return startTime, warnings, fmt.Errorf("The start time %s is not before the end time %s", startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
+	}
+
+	if endTime.After(now) {
+		return startTime, warnings, fmt.Errorf("The end time %s is in the future", endTime.Format(time.RFC3339))
+	}
+
+	if resolution <= 0 {
+		return startTime, warnings, fmt.Errorf("The resolution %s is not positive", resolution)
+	}
+
+	if limits.Retention > 0 {
+		oldest := now.Add(-limits.Retention)
+
+		if !endTime.After(oldest) {
+			return startTime, warnings, fmt.Errorf("The end time %s is older than the retention of %s", endTime.Format(time.RFC3339), limits.Retention)
+		}
+
+		if startTime.Before(oldest) {
+			warnings = append(warnings, fmt.Sprintf("The start time %s is older than the retention of %s and was moved to %s", startTime.Format(time.RFC3339), limits.Retention, oldest.Format(time.RFC3339)))
+			startTime = oldest
+		}
+	}
+
+	points := math.Ceil(float64(endTime.Sub(startTime)) / float64(resolution))
+
+	if limits.MaxPoints > 0 && points > float64(limits.MaxPoints) {
+		return startTime, warnings, fmt.Errorf("The range would need %.0f steps of %s, but at most %d are allowed", points, resolution, limits.MaxPoints)
+	}
+
+	return startTime, warnings, nil
+}
+
+func relativeBase(name string, now time.Time) (time.Time, error) {
+	year, month, day := now.Date()
+
+	switch strings.ToLower(name) {
+	case "now":
+		return now, nil
+	case "today", "startofday":
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC), nil
+	case "startofweek":
+		// Weeks start on Monday
+		daysSinceMonday := (int(now.Weekday()) + 6) % 7
+		return time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, time.UTC), nil
+	case "startofmonth":
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC), nil
+	case "startofyear":
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid relative time '%s'", name)
+}
+
+// Unix time stamps larger than this are taken to be in milliseconds
+const millisecondThreshold = 1e11
+
+func parseUnix(s string) (time.Time, error) {
+	if !strings.Contains(s, ".") {
+		value, err := strconv.ParseInt(s, 10, 64)
+
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid Unix time stamp '%s'", s)
+		}
+
+		if value > millisecondThreshold {
+			return time.Unix(0, value*int64(time.Millisecond)).UTC(), nil
+		}
+
+		return time.Unix(value, 0).UTC(), nil
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid Unix time stamp '%s'", s)
+	}
+
+	seconds, fraction := math.Modf(value)
+	return time.Unix(int64(seconds), int64(math.Round(fraction*1e6))*int64(time.Microsecond)).UTC(), nil
+}
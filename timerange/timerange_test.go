@@ -0,0 +1,90 @@
+package timerange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A Wednesday
+var now = time.Date(2021, 12, 22, 15, 30, 0, 0, time.UTC)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output time.Time
+	}{
+		{Input: "2021-12-24T00:00:00.371Z", Output: time.Date(2021, 12, 24, 0, 0, 0, 371000000, time.UTC)},
+		{Input: "2021-12-24T01:00:00+01:00", Output: time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC)},
+		{Input: "1640304000", Output: time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC)},
+		{Input: "1640304000.5", Output: time.Date(2021, 12, 24, 0, 0, 0, 500000000, time.UTC)},
+		{Input: "1640304000371", Output: time.Date(2021, 12, 24, 0, 0, 0, 371000000, time.UTC)},
+		{Input: "now", Output: now},
+		{Input: "now-7d", Output: now.Add(-7 * 24 * time.Hour)},
+		{Input: "now - 1h30m", Output: now.Add(-90 * time.Minute)},
+		{Input: "today", Output: time.Date(2021, 12, 22, 0, 0, 0, 0, time.UTC)},
+		{Input: "startOfWeek", Output: time.Date(2021, 12, 20, 0, 0, 0, 0, time.UTC)},
+		{Input: "startOfMonth", Output: time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{Input: "startOfMonth+1w", Output: time.Date(2021, 12, 8, 0, 0, 0, 0, time.UTC)},
+		{Input: "startOfYear", Output: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		result, err := ParseTime(test.Input, now)
+		assert.Nil(t, err, test.Input)
+		assert.True(t, test.Output.Equal(result), "%s: expected %s, got %s", test.Input, test.Output, result)
+	}
+
+	for _, input := range []string{"", "yesterday", "now-7x", "2021-12-24", "now-"} {
+		_, err := ParseTime(input, now)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	duration, err := ParseDuration("1w2d12h")
+	assert.Nil(t, err)
+	assert.Equal(t, 9*24*time.Hour+12*time.Hour, duration)
+
+	duration, err = ParseDuration("90m")
+	assert.Nil(t, err)
+	assert.Equal(t, 90*time.Minute, duration)
+
+	_, err = ParseDuration("7days")
+	assert.NotNil(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	limits := Limits{MaxPoints: 100, Retention: 15 * 24 * time.Hour}
+
+	start, warnings, err := Validate(now.Add(-24*time.Hour), now, time.Hour, now, limits)
+	assert.Nil(t, err)
+	assert.Empty(t, warnings)
+	assert.True(t, start.Equal(now.Add(-24*time.Hour)))
+
+	// Inverted range
+	_, _, err = Validate(now, now.Add(-time.Hour), time.Hour, now, limits)
+	assert.NotNil(t, err)
+
+	// Future range
+	_, _, err = Validate(now.Add(-time.Hour), now.Add(time.Hour), time.Hour, now, limits)
+	assert.NotNil(t, err)
+
+	// Too many points
+	_, _, err = Validate(now.Add(-101*time.Hour), now, time.Hour, now, limits)
+	assert.NotNil(t, err)
+
+	// Older than the retention
+	start, warnings, err = Validate(now.Add(-30*24*time.Hour), now.Add(-10*24*time.Hour), 24*time.Hour, now, limits)
+	assert.Nil(t, err)
+	assert.Len(t, warnings, 1)
+	assert.True(t, start.Equal(now.Add(-15*24*time.Hour)))
+
+	_, _, err = Validate(now.Add(-30*24*time.Hour), now.Add(-20*24*time.Hour), 24*time.Hour, now, limits)
+	assert.NotNil(t, err)
+
+	// No limits
+	_, _, err = Validate(now.Add(-365*24*time.Hour), now, time.Minute, now, Limits{})
+	assert.Nil(t, err)
+}